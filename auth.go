@@ -1,6 +1,7 @@
 package winrm
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -84,9 +85,14 @@ func parse(response *http.Response) (string, error) {
 
 // Post Post
 func (c ClientAuthRequest) Post(client *Client, request *soap.SoapMessage) (string, error) {
+	return c.PostWithContext(context.Background(), client, request)
+}
+
+// PostWithContext makes the post, canceling the request with ctx
+func (c ClientAuthRequest) PostWithContext(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
 	httpClient := &http.Client{Transport: c.transport}
 
-	req, err := http.NewRequest("POST", client.url, strings.NewReader(request.String()))
+	req, err := http.NewRequestWithContext(ctx, "POST", client.url, strings.NewReader(request.String()))
 	if err != nil {
 		return "", fmt.Errorf("impossible to create http request %w", err)
 	}
@@ -104,15 +110,11 @@ func (c ClientAuthRequest) Post(client *Client, request *soap.SoapMessage) (stri
 		return "", fmt.Errorf("http response error: %d - %w", resp.StatusCode, err)
 	}
 
-	// if we have different 200 http status code
-	// we must replace the error
-	defer func() {
-		if resp.StatusCode != 200 {
-			body, err = "", fmt.Errorf("http error %d: %s", resp.StatusCode, body)
-		}
-	}()
+	if resp.StatusCode != 200 {
+		return "", responseError(resp.StatusCode, body)
+	}
 
-	return body, err
+	return body, nil
 }
 
 // NewClientAuthRequestWithDial NewClientAuthRequestWithDial
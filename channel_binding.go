@@ -0,0 +1,68 @@
+package winrm
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+
+	// register the hash functions of the certificate hashes
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	"github.com/bodgit/ntlmssp"
+)
+
+// channelBindings returns the tls-server-end-point channel bindings (RFC 5929) of the TLS
+// connection of state, binding the authentication to the certificate of the server as
+// servers requiring Extended Protection for Authentication (CbtHardeningLevel) expect.
+// It returns nil without TLS.
+func channelBindings(state *tls.ConnectionState) *ntlmssp.ChannelBindings {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return &ntlmssp.ChannelBindings{
+		ApplicationData: append([]byte(ntlmssp.TLSServerEndPoint+":"), certificateHash(state.PeerCertificates[0])...),
+	}
+}
+
+// certificateHash returns the hash of cert with the hash function of its signature,
+// SHA-256 when it is MD5, SHA-1 or unknown, RFC 5929 section 4.1
+func certificateHash(cert *x509.Certificate) []byte {
+	hash := crypto.SHA256
+	switch cert.SignatureAlgorithm {
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		hash = crypto.SHA512
+	}
+	h := hash.New()
+	h.Write(cert.Raw)
+	return h.Sum(nil)
+}
+
+// channelBindingsHash returns the MD5 hash of the channel bindings marshalled as
+// gss_channel_bindings_struct (RFC 4121 section 4.1.1.2), or zeros without bindings
+func channelBindingsHash(bindings *ntlmssp.ChannelBindings) []byte {
+	if bindings == nil {
+		return make([]byte, md5.Size)
+	}
+	var b []byte
+	b = binary.LittleEndian.AppendUint32(b, bindings.InitiatorAddrtype)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(bindings.InitiatorAddress)))
+	b = append(b, bindings.InitiatorAddress...)
+	b = binary.LittleEndian.AppendUint32(b, bindings.AcceptorAddrtype)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(bindings.AcceptorAddress)))
+	b = append(b, bindings.AcceptorAddress...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(bindings.ApplicationData)))
+	b = append(b, bindings.ApplicationData...)
+	sum := md5.Sum(b)
+	return sum[:]
+}
+
+// sameChannelBindings reports whether a and b bind to the same channel
+func sameChannelBindings(a, b *ntlmssp.ChannelBindings) bool {
+	return bytes.Equal(channelBindingsHash(a), channelBindingsHash(b))
+}
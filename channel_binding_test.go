@@ -0,0 +1,58 @@
+package winrm
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// testChannelBindings returns the hash of the tls-server-end-point channel bindings of cert,
+// signed with SHA-256, as servers checking them compute it
+func testChannelBindings(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.Raw)
+	data := append([]byte("tls-server-end-point:"), sum[:]...)
+	b := binary.LittleEndian.AppendUint32(make([]byte, 16), uint32(len(data)))
+	return md5Sum(append(b, data...))
+}
+
+func (s *WinRMSuite) TestChannelBindings(c *C) {
+	c.Check(channelBindings(nil), IsNil)
+	c.Check(channelBindings(&tls.ConnectionState{}), IsNil)
+	c.Check(channelBindingsHash(nil), DeepEquals, make([]byte, 16))
+
+	cert := testCertificate(c).Leaf
+	bindings := channelBindings(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	c.Assert(bindings, NotNil)
+	sum := sha256.Sum256(cert.Raw)
+	c.Check(bindings.ApplicationData, DeepEquals, append([]byte("tls-server-end-point:"), sum[:]...))
+	c.Check(channelBindingsHash(bindings), DeepEquals, testChannelBindings(cert))
+}
+
+func (s *WinRMSuite) TestCertificateHashSHA384(c *C) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "winrm.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	c.Assert(cert.SignatureAlgorithm, Equals, x509.ECDSAWithSHA384)
+
+	sum := sha512.Sum384(cert.Raw)
+	c.Check(certificateHash(cert), DeepEquals, sum[:])
+}
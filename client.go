@@ -10,6 +10,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/masterzen/winrm/clixml"
 	"github.com/masterzen/winrm/soap"
 )
 
@@ -21,6 +22,8 @@ type Client struct {
 	useHTTPS bool
 	url      string
 	http     Transporter
+
+	shellPool *ShellPool
 }
 
 // Transporter does different transporters
@@ -31,6 +34,15 @@ type Transporter interface {
 	Transport(*Endpoint) error
 }
 
+// ContextTransporter is a Transporter whose requests are canceled with their context,
+// so that a hung endpoint doesn't block the caller. All the transports of this package
+// implement it, the context of the requests sent with another Transporter only being
+// checked before sending them.
+type ContextTransporter interface {
+	Transporter
+	PostWithContext(context.Context, *Client, *soap.SoapMessage) (string, error)
+}
+
 // NewClient will create a new remote client on url, connecting with user and password
 // This function doesn't connect (connection happens only when CreateShell is called)
 func NewClient(endpoint *Endpoint, user, password string) (*Client, error) {
@@ -77,10 +89,15 @@ func readCACerts(certs []byte) (*x509.CertPool, error) {
 // CreateShell will create a WinRM Shell,
 // which is the prealable for running commands.
 func (c *Client) CreateShell() (*Shell, error) {
+	return c.CreateShellWithContext(context.Background())
+}
+
+// CreateShellWithContext will create a WinRM Shell, canceling the request with ctx
+func (c *Client) CreateShellWithContext(ctx context.Context) (*Shell, error) {
 	request := NewOpenShellRequest(c.url, &c.Parameters)
 	defer request.Free()
 
-	response, err := c.sendRequest(request)
+	response, err := c.sendRequestWithContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -98,9 +115,45 @@ func (c *Client) NewShell(id string) *Shell {
 	return &Shell{client: c, id: id}
 }
 
+// ConnectShell connects this client to the disconnected shell id, such as a shell disconnected
+// by another process, whose commands are then resumed with Shell.ResumeCommand
+func (c *Client) ConnectShell(ctx context.Context, id string) (*Shell, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := NewConnectRequest(c.url, id, &c.Parameters)
+	defer request.Free()
+
+	if _, err := c.sendRequestWithContext(ctx, request); err != nil {
+		return nil, err
+	}
+	return c.NewShell(id), nil
+}
+
 // sendRequest exec the custom http func from the client
 func (c *Client) sendRequest(request *soap.SoapMessage) (string, error) {
-	return c.http.Post(c, request)
+	return c.sendRequestWithContext(context.Background(), request)
+}
+
+// sendRequestWithContext exec the custom http func from the client, canceling the
+// request with ctx when the transport supports it. The error of a canceled request
+// is the one of the context.
+func (c *Client) sendRequestWithContext(ctx context.Context, request *soap.SoapMessage) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	transport, ok := c.http.(ContextTransporter)
+	if !ok {
+		return c.http.Post(c, request)
+	}
+
+	response, err := transport.PostWithContext(ctx, c, request)
+	if err != nil && ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return response, err
 }
 
 // Run will run command on the the remote host, writing the process stdout and stderr to
@@ -181,11 +234,24 @@ func (c *Client) RunPSWithContext(ctx context.Context, command string) (string,
 	return outWriter.String(), errWriter.String(), exitCode, err
 }
 
+// RunPSWithContextWithErrorRecords will basically wrap your code to execute commands in powershell.exe,
+// like RunPSWithContext, but returns the error records decoded from the CLIXML
+// written by powershell.exe on its error stream instead of the raw stderr.
+func (c *Client) RunPSWithContextWithErrorRecords(ctx context.Context, command string) (string, []*clixml.ErrorRecord, int, error) {
+	stdout, stderr, exitCode, err := c.RunPSWithContext(ctx, command)
+	if err != nil {
+		return stdout, nil, exitCode, err
+	}
+
+	records, err := clixml.ParseErrorStream([]byte(stderr))
+	return stdout, records, exitCode, err
+}
+
 // RunWithInput will run command on the the remote host, writing the process stdout and stderr to
 // the given writers, and injecting the process stdin with the stdin reader.
-// Warning stdin (not stdout/stderr) are bufferized, which means reading only one byte in stdin will
-// send a winrm http packet to the remote host. If stdin is a pipe, it might be better for
-// performance reasons to buffer it.
+// Warning stdin (not stdout/stderr) is not buffered unless StdinBufferSize or StdinFlushInterval
+// are set in the Parameters of the client, which means reading only one byte in stdin will
+// send a winrm http packet to the remote host.
 // If stdin is nil, this is equivalent to c.Run()
 //
 // Deprecated: use RunWithContextWithInput()
@@ -196,21 +262,44 @@ func (c *Client) RunWithInput(command string, stdout, stderr io.Writer, stdin io
 // RunWithContextWithInput will run command on the the remote host, writing the process stdout and stderr to
 // the given writers, and injecting the process stdin with the stdin reader.
 // If the context is canceled, the command on the remote machine is canceled.
-// Warning stdin (not stdout/stderr) are bufferized, which means reading only one byte in stdin will
-// send a winrm http packet to the remote host. If stdin is a pipe, it might be better for
-// performance reasons to buffer it.
+// Warning stdin (not stdout/stderr) is not buffered unless StdinBufferSize or StdinFlushInterval
+// are set in the Parameters of the client, which means reading only one byte in stdin will
+// send a winrm http packet to the remote host.
 // If stdin is nil, this is equivalent to c.RunWithContext()
 func (c *Client) RunWithContextWithInput(ctx context.Context, command string, stdout, stderr io.Writer, stdin io.Reader) (int, error) {
-	shell, err := c.CreateShell()
-	if err != nil {
+	cmd, err := c.run(ctx, command, stdout, stderr, stdin)
+	if cmd == nil {
 		return 1, err
 	}
-	defer shell.Close()
+	return cmd.ExitCode(), err
+}
+
+// RunWithResult runs command on the remote host like RunWithContextWithInput, returning the
+// result of the command, which records whether it finished, its timing and the amount of
+// output received. The result is nil when the command could not be executed.
+func (c *Client) RunWithResult(ctx context.Context, command string, stdout, stderr io.Writer, stdin io.Reader) (*CommandResult, error) {
+	cmd, err := c.run(ctx, command, stdout, stderr, stdin)
+	if cmd == nil {
+		return nil, err
+	}
+	return cmd.Result(), err
+}
+
+// run runs command in a shell of the client until it ends, returning a nil command when it
+// could not be executed
+func (c *Client) run(ctx context.Context, command string, stdout, stderr io.Writer, stdin io.Reader) (*Command, error) {
+	shell, err := c.acquireShell(ctx)
+	if err != nil {
+		return nil, err
+	}
 	cmd, err := shell.ExecuteWithContext(ctx, command)
 	if err != nil {
-		return 1, err
+		c.releaseShell(shell, true)
+		return nil, err
 	}
 
+	cmd.discardOtherStreams()
+
 	var wg sync.WaitGroup
 	wg.Add(3)
 
@@ -238,6 +327,29 @@ func (c *Client) RunWithContextWithInput(ctx context.Context, command string, st
 	cmd.Wait()
 	wg.Wait()
 	cmd.Close()
+	c.releaseShell(shell, cmd.err != nil)
 
-	return cmd.ExitCode(), cmd.err
+	return cmd, cmd.err
+}
+
+// acquireShell returns the shell running the command of a Run* method, taken from the
+// shell pool of the client if any
+func (c *Client) acquireShell(ctx context.Context) (*Shell, error) {
+	if c.shellPool == nil {
+		return c.CreateShellWithContext(ctx)
+	}
+	return c.shellPool.Acquire(ctx)
+}
+
+// releaseShell closes the shell of a Run* method, or gives it back to the shell pool
+// of the client unless its command failed
+func (c *Client) releaseShell(shell *Shell, failed bool) {
+	switch {
+	case c.shellPool == nil:
+		_ = shell.Close()
+	case failed:
+		c.shellPool.Discard(shell)
+	default:
+		c.shellPool.Release(shell)
+	}
 }
@@ -2,9 +2,13 @@ package winrm
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/masterzen/winrm/soap"
 
@@ -119,6 +123,22 @@ func (s *WinRMSuite) TestRunPSWithString(c *C) {
 	c.Assert(stderr, Equals, "This is stderr, I'm pretty sure!")
 }
 
+func (s *WinRMSuite) TestRunPSWithContextWithErrorRecords(c *C) {
+	ts, host, port, err := runWinRMFakeServer(c, "no input")
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	stdout, records, code, err := client.RunPSWithContextWithErrorRecords(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	c.Assert(code, Equals, 123)
+	c.Assert(stdout, Equals, "That's all folks!!!")
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Error(), Equals, "This is stderr, I'm pretty sure!")
+}
+
 func (s *WinRMSuite) TestRunWithInput(c *C) {
 	ts, host, port, err := runWinRMFakeServer(c, "this is the input")
 	c.Assert(err, IsNil)
@@ -260,3 +280,100 @@ func (s *WinRMSuite) TestReplaceDial(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(usedCustomDial, Equals, true)
 }
+
+func (s *WinRMSuite) TestCreateShellWithContextHangingServer(c *C) {
+	stop := make(chan struct{})
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	defer close(stop)
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	shell, err := client.CreateShellWithContext(ctx)
+	c.Assert(shell, IsNil)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+}
+
+func (s *WinRMSuite) TestCreateShellWithContextNonContextTransporter(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	posted := false
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		posted = true
+		return createShellResponse, nil
+	}
+	client.http = &r
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.CreateShellWithContext(ctx)
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(posted, Equals, false)
+
+	shell, err := client.CreateShellWithContext(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+}
+
+func (s *WinRMSuite) TestRunWithContextCancelsPendingReceive(c *C) {
+	stop := make(chan struct{})
+	var mutex sync.Mutex
+	signaled := false
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/soap+xml")
+		switch {
+		case strings.Contains(string(body), "transfer/Create"):
+			fmt.Fprintln(w, createShellResponse)
+		case strings.Contains(string(body), "shell/Command"):
+			fmt.Fprintln(w, executeCommandResponse)
+		case strings.Contains(string(body), "shell/Receive"):
+			select {
+			case <-r.Context().Done():
+			case <-stop:
+			}
+		case strings.Contains(string(body), "shell/Signal"):
+			mutex.Lock()
+			signaled = true
+			mutex.Unlock()
+			fmt.Fprintln(w, "<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body/></s:Envelope>")
+		default:
+			fmt.Fprintln(w, "<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body/></s:Envelope>")
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	defer close(stop)
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = client.RunWithContext(ctx, "ping -t localhost", io.Discard, io.Discard)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	c.Assert(signaled, Equals, true)
+}
@@ -0,0 +1,480 @@
+package clixml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// node is the generic tree CLIXML documents are parsed into before being decoded
+type node struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Text    string     `xml:",chardata"`
+	Nodes   []node     `xml:",any"`
+}
+
+func (n *node) attr(name string) string {
+	for _, attr := range n.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// decoder keeps track of the objects and type names that may be referenced later in a document
+type decoder struct {
+	objects map[string]interface{}
+	types   map[string][]string
+}
+
+func newDecoder() *decoder {
+	return &decoder{
+		objects: make(map[string]interface{}),
+		types:   make(map[string][]string),
+	}
+}
+
+// Unmarshal decodes a CLIXML stream, as written by powershell.exe on its output streams.
+// The stream may start with the "#< CLIXML" header and contain several <Objs> documents.
+func Unmarshal(data []byte) ([]Element, error) {
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, []byte(Header))
+
+	var elements []Element
+	reader := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := reader.Token()
+		if errors.Is(err, io.EOF) {
+			return elements, nil
+		}
+		if err != nil {
+			return elements, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var root node
+		if err := reader.DecodeElement(&root, &start); err != nil {
+			return elements, err
+		}
+
+		// a bare object is accepted as a document of its own
+		children := []node{root}
+		if root.XMLName.Local == "Objs" {
+			children = root.Nodes
+		}
+
+		d := newDecoder()
+		for i := range children {
+			value, err := d.decode(&children[i])
+			if err != nil {
+				return elements, err
+			}
+			elements = append(elements, Element{Stream: children[i].attr("S"), Value: value})
+		}
+	}
+}
+
+// UnmarshalValue decodes a single serialized object, such as the data of a PSRP message
+func UnmarshalValue(data []byte) (interface{}, error) {
+	var root node
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	return newDecoder().decode(&root)
+}
+
+func (d *decoder) decode(n *node) (interface{}, error) {
+	text := n.Text
+	switch n.XMLName.Local {
+	case "Nil":
+		return nil, nil
+	case "S":
+		return DecodeString(text), nil
+	case "C":
+		v, err := strconv.ParseUint(text, 10, 16)
+		return Char(v), err
+	case "B":
+		return strconv.ParseBool(text)
+	case "DT":
+		return parseDateTime(text)
+	case "TS":
+		return ParseDuration(text)
+	case "By":
+		v, err := strconv.ParseUint(text, 10, 8)
+		return uint8(v), err
+	case "SB":
+		v, err := strconv.ParseInt(text, 10, 8)
+		return int8(v), err
+	case "U16":
+		v, err := strconv.ParseUint(text, 10, 16)
+		return uint16(v), err
+	case "I16":
+		v, err := strconv.ParseInt(text, 10, 16)
+		return int16(v), err
+	case "U32":
+		v, err := strconv.ParseUint(text, 10, 32)
+		return uint32(v), err
+	case "I32":
+		v, err := strconv.ParseInt(text, 10, 32)
+		return int32(v), err
+	case "U64":
+		return strconv.ParseUint(text, 10, 64)
+	case "I64":
+		return strconv.ParseInt(text, 10, 64)
+	case "Sg":
+		v, err := parseFloat(text, 32)
+		return float32(v), err
+	case "Db":
+		return parseFloat(text, 64)
+	case "D":
+		return Decimal(text), nil
+	case "BA":
+		return base64.StdEncoding.DecodeString(text)
+	case "G":
+		return uuid.FromString(text)
+	case "URI":
+		return url.Parse(DecodeString(text))
+	case "Version":
+		return Version(text), nil
+	case "XD":
+		return DecodeString(text), nil
+	case "SBK":
+		return ScriptBlock(DecodeString(text)), nil
+	case "SS":
+		return SecureString(text), nil
+	case "PR":
+		return d.decodeProgress(n)
+	case "Ref":
+		value, ok := d.objects[n.attr("RefId")]
+		if !ok {
+			return nil, fmt.Errorf("reference to unknown object %s", n.attr("RefId"))
+		}
+		return value, nil
+	case "Obj":
+		return d.decodeObject(n)
+	}
+	return nil, fmt.Errorf("unsupported CLIXML element %s", n.XMLName.Local)
+}
+
+func (d *decoder) decodeObject(n *node) (interface{}, error) {
+	object := &Object{}
+	hasProperties := false
+
+	for i := range n.Nodes {
+		child := &n.Nodes[i]
+		switch child.XMLName.Local {
+		case "TN":
+			for _, t := range child.Nodes {
+				object.TypeNames = append(object.TypeNames, t.Text)
+			}
+			d.types[child.attr("RefId")] = object.TypeNames
+		case "TNRef":
+			object.TypeNames = d.types[child.attr("RefId")]
+		case "ToString":
+			object.ToString = DecodeString(child.Text)
+		case "Props", "MS":
+			hasProperties = true
+			if object.Properties == nil {
+				object.Properties = make(map[string]interface{})
+			}
+			for j := range child.Nodes {
+				value, err := d.decode(&child.Nodes[j])
+				if err != nil {
+					return nil, err
+				}
+				object.Properties[DecodeString(child.Nodes[j].attr("N"))] = value
+			}
+		case "LST", "IL", "STK", "QUE":
+			object.List = make([]interface{}, 0, len(child.Nodes))
+			for j := range child.Nodes {
+				value, err := d.decode(&child.Nodes[j])
+				if err != nil {
+					return nil, err
+				}
+				object.List = append(object.List, value)
+			}
+		case "DCT":
+			object.Dictionary = make(map[string]interface{}, len(child.Nodes))
+			for _, entry := range child.Nodes {
+				var key, value interface{}
+				for j := range entry.Nodes {
+					v, err := d.decode(&entry.Nodes[j])
+					if err != nil {
+						return nil, err
+					}
+					switch entry.Nodes[j].attr("N") {
+					case "Key":
+						key = v
+					case "Value":
+						value = v
+					}
+				}
+				object.Dictionary[fmt.Sprint(key)] = value
+			}
+		default:
+			value, err := d.decode(child)
+			if err != nil {
+				return nil, err
+			}
+			object.Value = value
+		}
+	}
+
+	var result interface{} = object
+	switch {
+	case object.Is("System.Management.Automation.ErrorRecord"):
+		result = newErrorRecord(object)
+	case object.Dictionary != nil && !hasProperties:
+		result = object.Dictionary
+	case object.List != nil && !hasProperties:
+		result = object.List
+	}
+
+	d.objects[n.attr("RefId")] = result
+	return result, nil
+}
+
+func (d *decoder) decodeProgress(n *node) (*ProgressRecord, error) {
+	record := &ProgressRecord{}
+	for _, child := range n.Nodes {
+		text := DecodeString(child.Text)
+		var err error
+		switch child.XMLName.Local {
+		case "AV":
+			record.Activity = text
+		case "AI":
+			record.ActivityID, err = parseInt32(text)
+		case "CO":
+			record.CurrentOperation = text
+		case "PI":
+			record.ParentActivityID, err = parseInt32(text)
+		case "PC":
+			record.PercentComplete, err = parseInt32(text)
+		case "T":
+			record.Completed = text == "Completed"
+		case "SR":
+			record.SecondsRemaining, err = parseInt32(text)
+		case "SD":
+			record.StatusDescription = text
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return record, nil
+}
+
+func newErrorRecord(object *Object) *ErrorRecord {
+	record := &ErrorRecord{Object: object}
+
+	str := func(names ...string) string {
+		for _, name := range names {
+			if value, ok := object.Property(name); ok && value != nil {
+				if s := fmt.Sprint(value); s != "" {
+					return s
+				}
+			}
+		}
+		return ""
+	}
+
+	record.Message = str("ErrorDetails_Message", "Exception.Message")
+	if record.Message == "" {
+		record.Message = object.ToString
+	}
+	record.FullyQualifiedErrorID = str("FullyQualifiedErrorId")
+	record.CategoryInfo = str("ErrorCategory_Message")
+	record.PositionMessage = str("InvocationInfo.PositionMessage", "InvocationInfo_PositionMessage")
+	record.ScriptStackTrace = str("ErrorDetails_ScriptStackTrace")
+	record.TargetObject, _ = object.Property("TargetObject")
+
+	return record
+}
+
+func parseInt32(s string) (int32, error) {
+	v, err := strconv.ParseInt(s, 10, 32)
+	return int32(v), err
+}
+
+func parseFloat(s string, bitSize int) (float64, error) {
+	switch s {
+	case "INF", "Infinity":
+		return math.Inf(1), nil
+	case "-INF", "-Infinity":
+		return math.Inf(-1), nil
+	case "NaN":
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, bitSize)
+}
+
+func parseDateTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err == nil {
+		return t, nil
+	}
+	// DateTime of kind Unspecified carry no offset
+	return time.Parse("2006-01-02T15:04:05.999999999", s)
+}
+
+var durationRegexp = regexp.MustCompile(`^(-)?P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// ParseDuration parses a xs:duration, such as the P1DT2H3M4.5S serializing System.TimeSpan
+// or the PT7200.000S of the WS-Management shells, the years and months counting as 365
+// and 30 days
+func ParseDuration(s string) (time.Duration, error) {
+	matches := durationRegexp.FindStringSubmatch(s)
+	if matches == nil || strings.HasSuffix(s, "P") || strings.HasSuffix(s, "T") {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+
+	var d time.Duration
+	units := []time.Duration{365 * 24 * time.Hour, 30 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute}
+	for i, unit := range units {
+		if matches[i+2] == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(matches[i+2], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(v) * unit
+	}
+	if matches[7] != "" {
+		seconds, err := strconv.ParseFloat(matches[7], 64)
+		if err != nil {
+			return 0, err
+		}
+		d += time.Duration(math.Round(seconds * float64(time.Second)))
+	}
+
+	if matches[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// errorRecordEnd matches the last line powershell.exe writes for an error record
+var errorRecordEnd = regexp.MustCompile(`^\s*\+ FullyQualifiedErrorId : (.*)$`)
+
+// ErrorRecords returns the error records of the elements. Error records written as
+// objects are returned as is, while the formatted lines powershell.exe writes
+// on its error stream are grouped back into records.
+func ErrorRecords(elements []Element) []*ErrorRecord {
+	var records []*ErrorRecord
+	var lines []string
+
+	flush := func() {
+		if len(lines) == 0 {
+			return
+		}
+		records = append(records, parseErrorLines(lines))
+		lines = nil
+	}
+
+	for _, element := range elements {
+		switch value := element.Value.(type) {
+		case *ErrorRecord:
+			flush()
+			records = append(records, value)
+		case string:
+			if !strings.EqualFold(element.Stream, "Error") {
+				continue
+			}
+			for _, line := range strings.Split(strings.TrimRight(value, "\r\n"), "\n") {
+				line = strings.TrimRight(line, "\r")
+				if len(lines) == 0 && strings.TrimSpace(line) == "" {
+					continue
+				}
+				lines = append(lines, line)
+				if errorRecordEnd.MatchString(line) {
+					flush()
+				}
+			}
+		}
+	}
+	flush()
+
+	return records
+}
+
+// parseErrorLines rebuilds an error record from its formatted representation:
+//
+//	message
+//	At line:1 char:1
+//	+ foo
+//	+ ~~~
+//	    + CategoryInfo          : ObjectNotFound: (foo:String) [], CommandNotFoundException
+//	    + FullyQualifiedErrorId : CommandNotFoundException
+func parseErrorLines(lines []string) *ErrorRecord {
+	record := &ErrorRecord{}
+
+	var message, position []string
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "+ CategoryInfo"):
+			record.CategoryInfo = strings.TrimSpace(trimmed[strings.Index(trimmed, ":")+1:])
+		case strings.HasPrefix(trimmed, "+ FullyQualifiedErrorId"):
+			record.FullyQualifiedErrorID = strings.TrimSpace(trimmed[strings.Index(trimmed, ":")+1:])
+		case strings.HasPrefix(trimmed, "At ") && len(position) == 0 || len(position) > 0 && strings.HasPrefix(trimmed, "+"):
+			position = append(position, line)
+		case trimmed == "":
+		default:
+			message = append(message, line)
+		}
+	}
+
+	// powershell.exe wraps long messages at the console width, after a space
+	var buf strings.Builder
+	for i, line := range message {
+		if i > 0 && !strings.HasSuffix(message[i-1], " ") {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	record.Message = buf.String()
+	record.PositionMessage = strings.Join(position, "\n")
+	record.Object = &Object{
+		TypeNames: []string{"System.Management.Automation.ErrorRecord", "System.Object"},
+		ToString:  record.Message,
+	}
+	return record
+}
+
+// ParseErrorStream returns the error records written by powershell.exe on its error stream.
+// The stream is usually a CLIXML document, but plain text is also accepted and
+// returned as a single error record.
+func ParseErrorStream(stderr []byte) ([]*ErrorRecord, error) {
+	trimmed := bytes.TrimSpace(stderr)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+	if !bytes.HasPrefix(trimmed, []byte(Header)) && !bytes.HasPrefix(trimmed, []byte("<Objs")) {
+		lines := strings.Split(strings.ReplaceAll(string(trimmed), "\r\n", "\n"), "\n")
+		return []*ErrorRecord{parseErrorLines(lines)}, nil
+	}
+
+	elements, err := Unmarshal(trimmed)
+	if err != nil {
+		return nil, err
+	}
+	return ErrorRecords(elements), nil
+}
@@ -0,0 +1,199 @@
+package clixml
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofrs/uuid"
+	. "gopkg.in/check.v1"
+)
+
+const commandNotFoundStderr = `#< CLIXML
+<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><Obj S="progress" RefId="0"><TN RefId="0"><T>System.Management.Automation.PSCustomObject</T><T>System.Object</T></TN><MS><I64 N="SourceId">1</I64><PR N="Record"><AV>Preparing modules for first use.</AV><AI>0</AI><Nil /><PI>-1</PI><PC>-1</PC><T>Completed</T><SR>-1</SR><SD> </SD></PR></MS></Obj><S S="Error">foo : The term 'foo' is not recognized as the name of a cmdlet, function, script file, or operable program. Check the _x000D__x000A_</S><S S="Error">spelling of the name, or if a path was included, verify that the path is correct and try again._x000D__x000A_</S><S S="Error">At line:1 char:1_x000D__x000A_</S><S S="Error">+ foo_x000D__x000A_</S><S S="Error">+ ~~~_x000D__x000A_</S><S S="Error">    + CategoryInfo          : ObjectNotFound: (foo:String) [], CommandNotFoundException_x000D__x000A_</S><S S="Error">    + FullyQualifiedErrorId : CommandNotFoundException_x000D__x000A_</S><S S="Error"> _x000D__x000A_</S></Objs>`
+
+const errorRecordObject = `<Obj RefId="0"><TN RefId="0"><T>System.Management.Automation.ErrorRecord</T><T>System.Object</T></TN><ToString>access denied</ToString><MS><Obj N="Exception" RefId="1"><TN RefId="1"><T>System.UnauthorizedAccessException</T><T>System.SystemException</T><T>System.Exception</T><T>System.Object</T></TN><ToString>System.UnauthorizedAccessException: access denied</ToString><Props><S N="Message">access denied</S></Props></Obj><S N="TargetObject">C:\secret</S><S N="FullyQualifiedErrorId">UnauthorizedAccess,Microsoft.PowerShell.Commands.GetContentCommand</S><Obj N="InvocationInfo" RefId="2"><TN RefId="2"><T>System.Management.Automation.InvocationInfo</T><T>System.Object</T></TN><Props><S N="PositionMessage">At line:1 char:1_x000D__x000A_+ Get-Content C:\secret</S></Props></Obj><S N="ErrorCategory_Message">PermissionDenied: (C:\secret:String) [Get-Content], UnauthorizedAccessException</S><Nil N="ErrorDetails_Message" /><S N="ErrorDetails_ScriptStackTrace">at &lt;ScriptBlock&gt;, &lt;No file&gt;: line 1</S></MS></Obj>`
+
+func (s *MySuite) TestUnmarshalPrimitives(c *C) {
+	data := `<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04">` +
+		`<S>a_x000A_b</S><C>97</C><B>true</B><DT>2020-01-02T03:04:05.5+01:00</DT><TS>P1DT2H3M4.5S</TS>` +
+		`<By>255</By><SB>-1</SB><U16>65535</U16><I16>-2</I16><U32>3</U32><I32>-4</I32><U64>5</U64><I64>-6</I64>` +
+		`<Sg>1.5</Sg><Db>-INF</Db><D>1.10</D><BA>AQID</BA><G>00112233-4455-6677-8899-aabbccddeeff</G>` +
+		`<URI>http://example.com/</URI><Version>5.1.0.0</Version><SBK>Get-Date</SBK><Nil /></Objs>`
+
+	elements, err := Unmarshal([]byte(data))
+	c.Assert(err, IsNil)
+	c.Assert(elements, HasLen, 22)
+
+	values := make([]interface{}, len(elements))
+	for i, element := range elements {
+		values[i] = element.Value
+	}
+
+	c.Assert(values[0], Equals, "a\nb")
+	c.Assert(values[1], Equals, Char('a'))
+	c.Assert(values[2], Equals, true)
+	c.Assert(values[3].(time.Time).Equal(time.Date(2020, 1, 2, 2, 4, 5, 500000000, time.UTC)), Equals, true)
+	c.Assert(values[4], Equals, 26*time.Hour+3*time.Minute+4500*time.Millisecond)
+	c.Assert(values[5], Equals, uint8(255))
+	c.Assert(values[6], Equals, int8(-1))
+	c.Assert(values[7], Equals, uint16(65535))
+	c.Assert(values[8], Equals, int16(-2))
+	c.Assert(values[9], Equals, uint32(3))
+	c.Assert(values[10], Equals, int32(-4))
+	c.Assert(values[11], Equals, uint64(5))
+	c.Assert(values[12], Equals, int64(-6))
+	c.Assert(values[13], Equals, float32(1.5))
+	c.Assert(values[14].(float64) < 0, Equals, true)
+	c.Assert(values[15], Equals, Decimal("1.10"))
+	c.Assert(values[16], DeepEquals, []byte{1, 2, 3})
+	c.Assert(values[17], Equals, uuid.Must(uuid.FromString("00112233-4455-6677-8899-aabbccddeeff")))
+	c.Assert(values[18].(fmt.Stringer).String(), Equals, "http://example.com/")
+	c.Assert(values[19], Equals, Version("5.1.0.0"))
+	c.Assert(values[20], Equals, ScriptBlock("Get-Date"))
+	c.Assert(values[21], IsNil)
+}
+
+func (s *MySuite) TestParseDuration(c *C) {
+	for s, expected := range map[string]time.Duration{
+		"PT30S":          30 * time.Second,
+		"PT7200.000S":    2 * time.Hour,
+		"P1DT2H3M4.25S":  26*time.Hour + 3*time.Minute + 4250*time.Millisecond,
+		"P2D":            48 * time.Hour,
+		"P1Y1M":          395 * 24 * time.Hour,
+		"PT2147483.647S": 2147483647 * time.Millisecond,
+		"P0DT0H0M0S":     0,
+	} {
+		d, err := ParseDuration(s)
+		c.Assert(err, IsNil)
+		c.Check(d, Equals, expected, Commentf("duration %s", s))
+	}
+
+	for _, s := range []string{"", "P", "-P", "PT", "1S", "PT1H2S3M"} {
+		_, err := ParseDuration(s)
+		c.Check(err, NotNil, Commentf("duration %s", s))
+	}
+}
+
+func (s *MySuite) TestUnmarshalCollections(c *C) {
+	data := `<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04">` +
+		`<Obj RefId="0"><TN RefId="0"><T>System.Object[]</T><T>System.Array</T><T>System.Object</T></TN><LST><I32>1</I32><S>two</S></LST></Obj>` +
+		`<Obj RefId="1"><TN RefId="1"><T>System.Collections.Hashtable</T><T>System.Object</T></TN><DCT><En><S N="Key">name</S><S N="Value">winrm</S></En><En><I32 N="Key">3</I32><Ref N="Value" RefId="0" /></En></DCT></Obj>` +
+		`<Obj RefId="2"><TN RefId="2"><T>System.ServiceProcess.ServiceControllerStatus</T><T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>Running</ToString><I32>4</I32></Obj>` +
+		`<Obj RefId="3"><TNRef RefId="2" /><ToString>Stopped</ToString><I32>1</I32></Obj>` +
+		`</Objs>`
+
+	elements, err := Unmarshal([]byte(data))
+	c.Assert(err, IsNil)
+	c.Assert(elements, HasLen, 4)
+
+	list := []interface{}{int32(1), "two"}
+	c.Assert(elements[0].Value, DeepEquals, list)
+	c.Assert(elements[1].Value, DeepEquals, map[string]interface{}{"name": "winrm", "3": list})
+
+	status := elements[2].Value.(*Object)
+	c.Assert(status.String(), Equals, "Running")
+	c.Assert(status.Value, Equals, int32(4))
+
+	stopped := elements[3].Value.(*Object)
+	c.Assert(stopped.Is("System.Enum"), Equals, true)
+	c.Assert(stopped.String(), Equals, "Stopped")
+}
+
+func (s *MySuite) TestUnmarshalCustomObject(c *C) {
+	data := `<Obj RefId="0"><TN RefId="0"><T>Deserialized.System.Diagnostics.Process</T><T>Deserialized.System.Object</T></TN>` +
+		`<ToString>System.Diagnostics.Process (winlogon)</ToString><Props><S N="Name">winlogon</S><I32 N="Id">560</I32></Props><MS><S N="Company">Microsoft</S></MS></Obj>`
+
+	value, err := UnmarshalValue([]byte(data))
+	c.Assert(err, IsNil)
+
+	process := value.(*Object)
+	c.Assert(process.TypeNames[0], Equals, "Deserialized.System.Diagnostics.Process")
+	c.Assert(process.Properties, DeepEquals, map[string]interface{}{"Name": "winlogon", "Id": int32(560), "Company": "Microsoft"})
+}
+
+func (s *MySuite) TestUnmarshalErrorRecord(c *C) {
+	value, err := UnmarshalValue([]byte(errorRecordObject))
+	c.Assert(err, IsNil)
+
+	record := value.(*ErrorRecord)
+	c.Assert(record.Error(), Equals, "access denied")
+	c.Assert(record.FullyQualifiedErrorID, Equals, "UnauthorizedAccess,Microsoft.PowerShell.Commands.GetContentCommand")
+	c.Assert(record.CategoryInfo, Equals, "PermissionDenied: (C:\\secret:String) [Get-Content], UnauthorizedAccessException")
+	c.Assert(record.PositionMessage, Equals, "At line:1 char:1\r\n+ Get-Content C:\\secret")
+	c.Assert(record.ScriptStackTrace, Equals, "at <ScriptBlock>, <No file>: line 1")
+	c.Assert(record.TargetObject, Equals, "C:\\secret")
+
+	c.Assert(ErrorRecords([]Element{{Value: record}}), DeepEquals, []*ErrorRecord{record})
+}
+
+func (s *MySuite) TestUnmarshalStderr(c *C) {
+	elements, err := Unmarshal([]byte(commandNotFoundStderr))
+	c.Assert(err, IsNil)
+	c.Assert(elements, HasLen, 9)
+	c.Assert(elements[0].Stream, Equals, "progress")
+	c.Assert(elements[1].Stream, Equals, "Error")
+
+	progress, ok := elements[0].Value.(*Object).Property("Record")
+	c.Assert(ok, Equals, true)
+	c.Assert(progress, DeepEquals, &ProgressRecord{
+		Activity:          "Preparing modules for first use.",
+		StatusDescription: " ",
+		ParentActivityID:  -1,
+		PercentComplete:   -1,
+		SecondsRemaining:  -1,
+		Completed:         true,
+	})
+
+	records := ErrorRecords(elements)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Message, Equals, "foo : The term 'foo' is not recognized as the name of a cmdlet, function, script file, or operable program. "+
+		"Check the spelling of the name, or if a path was included, verify that the path is correct and try again.")
+	c.Assert(records[0].PositionMessage, Equals, "At line:1 char:1\n+ foo\n+ ~~~")
+	c.Assert(records[0].CategoryInfo, Equals, "ObjectNotFound: (foo:String) [], CommandNotFoundException")
+	c.Assert(records[0].FullyQualifiedErrorID, Equals, "CommandNotFoundException")
+	c.Assert(records[0].Is("System.Management.Automation.ErrorRecord"), Equals, true)
+}
+
+func (s *MySuite) TestUnmarshalSeveralDocuments(c *C) {
+	data := "#< CLIXML\r\n" +
+		`<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><S S="Error">first_x000D__x000A_</S></Objs>` +
+		`<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><S S="Error">second_x000D__x000A_</S></Objs>`
+
+	elements, err := Unmarshal([]byte(data))
+	c.Assert(err, IsNil)
+	c.Assert(elements, HasLen, 2)
+
+	records := ErrorRecords(elements)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Message, Equals, "first\nsecond")
+}
+
+func (s *MySuite) TestUnmarshalInvalid(c *C) {
+	_, err := Unmarshal([]byte(`<Objs><Unknown /></Objs>`))
+	c.Assert(err, ErrorMatches, "unsupported CLIXML element Unknown")
+
+	_, err = Unmarshal([]byte(`<Objs><Ref RefId="4" /></Objs>`))
+	c.Assert(err, ErrorMatches, "reference to unknown object 4")
+
+	_, err = Unmarshal([]byte(`<Objs><I32>abc</I32></Objs>`))
+	c.Assert(err, NotNil)
+}
+
+func (s *MySuite) TestParseErrorStream(c *C) {
+	records, err := ParseErrorStream([]byte(commandNotFoundStderr))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].FullyQualifiedErrorID, Equals, "CommandNotFoundException")
+
+	records, err = ParseErrorStream([]byte("Access is denied.\r\n"))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Error(), Equals, "Access is denied.")
+
+	records, err = ParseErrorStream([]byte(" \r\n"))
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 0)
+
+	_, err = ParseErrorStream([]byte(Header + "<Objs><S>"))
+	c.Assert(err, NotNil)
+}
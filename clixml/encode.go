@@ -0,0 +1,353 @@
+package clixml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+var (
+	hashtableTypeNames      = []string{"System.Collections.Hashtable", "System.Object"}
+	arrayListTypeNames      = []string{"System.Object[]", "System.Array", "System.Object"}
+	pscustomObjectTypeNames = []string{"System.Management.Automation.PSCustomObject", "System.Object"}
+)
+
+// encoder hands out the RefId of objects and type name lists within a document
+type encoder struct {
+	buf     strings.Builder
+	objects int
+	types   map[string]int
+}
+
+func newEncoder() *encoder {
+	return &encoder{types: make(map[string]int)}
+}
+
+// Marshal encodes values as a CLIXML document, one top level element per value.
+// Strings, booleans, numbers, byte slices, time.Time, time.Duration, uuid.UUID and
+// *url.URL are encoded as primitives. Slices become arrays, maps with string keys
+// become hashtables and structs become PSCustomObject with their exported fields as
+// properties, named after their clixml tag when present.
+func Marshal(values ...interface{}) ([]byte, error) {
+	e := newEncoder()
+	e.buf.WriteString(`<Objs Version="1.1.0.1" xmlns="` + Namespace + `">`)
+	for _, value := range values {
+		if err := e.encode("", reflect.ValueOf(value)); err != nil {
+			return nil, err
+		}
+	}
+	e.buf.WriteString(`</Objs>`)
+	return []byte(e.buf.String()), nil
+}
+
+// MarshalValue encodes a single value without the <Objs> document wrapper,
+// as found in the data of a PSRP message
+func MarshalValue(value interface{}) ([]byte, error) {
+	e := newEncoder()
+	if err := e.encode("", reflect.ValueOf(value)); err != nil {
+		return nil, err
+	}
+	return []byte(e.buf.String()), nil
+}
+
+func (e *encoder) primitive(tag, name, text string) {
+	e.buf.WriteString("<" + tag)
+	if name != "" {
+		e.buf.WriteString(` N="` + EncodeString(name) + `"`)
+	}
+	e.buf.WriteString(">" + text + "</" + tag + ">")
+}
+
+func (e *encoder) encode(name string, v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf.WriteString("<Nil")
+		if name != "" {
+			e.buf.WriteString(` N="` + EncodeString(name) + `"`)
+		}
+		e.buf.WriteString(" />")
+		return nil
+	}
+
+	switch value := v.Interface().(type) {
+	case Char:
+		e.primitive("C", name, strconv.FormatUint(uint64(uint16(value)), 10))
+		return nil
+	case Decimal:
+		e.primitive("D", name, string(value))
+		return nil
+	case Version:
+		e.primitive("Version", name, string(value))
+		return nil
+	case ScriptBlock:
+		e.primitive("SBK", name, EncodeString(string(value)))
+		return nil
+	case SecureString:
+		e.primitive("SS", name, string(value))
+		return nil
+	case time.Time:
+		e.primitive("DT", name, value.Format(time.RFC3339Nano))
+		return nil
+	case time.Duration:
+		e.primitive("TS", name, FormatDuration(value))
+		return nil
+	case uuid.UUID:
+		e.primitive("G", name, value.String())
+		return nil
+	case *url.URL:
+		if value != nil {
+			e.primitive("URI", name, EncodeString(value.String()))
+			return nil
+		}
+	case []byte:
+		e.primitive("BA", name, base64.StdEncoding.EncodeToString(value))
+		return nil
+	case *Object:
+		if value != nil {
+			return e.encodeObject(name, value)
+		}
+	case *ErrorRecord:
+		if value != nil && value.Object != nil {
+			return e.encodeObject(name, value.Object)
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return e.encode(name, reflect.Value{})
+		}
+		return e.encode(name, v.Elem())
+	case reflect.String:
+		e.primitive("S", name, EncodeString(v.String()))
+	case reflect.Bool:
+		e.primitive("B", name, strconv.FormatBool(v.Bool()))
+	case reflect.Int8:
+		e.primitive("SB", name, strconv.FormatInt(v.Int(), 10))
+	case reflect.Int16:
+		e.primitive("I16", name, strconv.FormatInt(v.Int(), 10))
+	case reflect.Int32:
+		e.primitive("I32", name, strconv.FormatInt(v.Int(), 10))
+	case reflect.Int, reflect.Int64:
+		e.primitive("I64", name, strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint8:
+		e.primitive("By", name, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Uint16:
+		e.primitive("U16", name, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Uint32:
+		e.primitive("U32", name, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Uint, reflect.Uint64:
+		e.primitive("U64", name, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32:
+		e.primitive("Sg", name, formatFloat(v.Float(), 32))
+	case reflect.Float64:
+		e.primitive("Db", name, formatFloat(v.Float(), 64))
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return e.encode(name, reflect.Value{})
+		}
+		return e.encodeList(name, v)
+	case reflect.Map:
+		if v.IsNil() {
+			return e.encode(name, reflect.Value{})
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		return e.encodeDictionary(name, v)
+	case reflect.Struct:
+		return e.encodeStruct(name, v)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// openObject writes the start of an <Obj> element along with its type names
+func (e *encoder) openObject(name string, typeNames []string) {
+	e.buf.WriteString("<Obj")
+	if name != "" {
+		e.buf.WriteString(` N="` + EncodeString(name) + `"`)
+	}
+	e.buf.WriteString(` RefId="` + strconv.Itoa(e.objects) + `">`)
+	e.objects++
+
+	if len(typeNames) == 0 {
+		return
+	}
+	key := strings.Join(typeNames, "\n")
+	if refID, ok := e.types[key]; ok {
+		e.buf.WriteString(`<TNRef RefId="` + strconv.Itoa(refID) + `" />`)
+		return
+	}
+	refID := len(e.types)
+	e.types[key] = refID
+	e.buf.WriteString(`<TN RefId="` + strconv.Itoa(refID) + `">`)
+	for _, typeName := range typeNames {
+		e.buf.WriteString("<T>" + EncodeString(typeName) + "</T>")
+	}
+	e.buf.WriteString("</TN>")
+}
+
+func (e *encoder) encodeList(name string, v reflect.Value) error {
+	e.openObject(name, arrayListTypeNames)
+	e.buf.WriteString("<LST>")
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode("", v.Index(i)); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteString("</LST></Obj>")
+	return nil
+}
+
+func (e *encoder) encodeDictionary(name string, v reflect.Value) error {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+
+	e.openObject(name, hashtableTypeNames)
+	e.buf.WriteString("<DCT>")
+	for _, key := range keys {
+		e.buf.WriteString("<En>")
+		e.primitive("S", "Key", EncodeString(key.String()))
+		if err := e.encode("Value", v.MapIndex(key)); err != nil {
+			return err
+		}
+		e.buf.WriteString("</En>")
+	}
+	e.buf.WriteString("</DCT></Obj>")
+	return nil
+}
+
+func (e *encoder) encodeStruct(name string, v reflect.Value) error {
+	e.openObject(name, pscustomObjectTypeNames)
+	e.buf.WriteString("<MS>")
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldName := field.Name
+		if tag := field.Tag.Get("clixml"); tag == "-" {
+			continue
+		} else if tag != "" {
+			fieldName = tag
+		}
+		if err := e.encode(fieldName, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteString("</MS></Obj>")
+	return nil
+}
+
+func (e *encoder) encodeObject(name string, object *Object) error {
+	e.openObject(name, object.TypeNames)
+	if object.ToString != "" {
+		e.buf.WriteString("<ToString>" + EncodeString(object.ToString) + "</ToString>")
+	}
+	if object.Value != nil {
+		if err := e.encode("", reflect.ValueOf(object.Value)); err != nil {
+			return err
+		}
+	}
+	if object.List != nil {
+		e.buf.WriteString("<LST>")
+		for _, item := range object.List {
+			if err := e.encode("", reflect.ValueOf(item)); err != nil {
+				return err
+			}
+		}
+		e.buf.WriteString("</LST>")
+	}
+	if object.Dictionary != nil {
+		if err := e.encodeEntries("DCT", object.Dictionary, true); err != nil {
+			return err
+		}
+	}
+	if object.Properties != nil {
+		if err := e.encodeEntries("MS", object.Properties, false); err != nil {
+			return err
+		}
+	}
+	e.buf.WriteString("</Obj>")
+	return nil
+}
+
+// encodeEntries writes the entries of a dictionary, or the members of an object, in key order
+func (e *encoder) encodeEntries(tag string, entries map[string]interface{}, dictionary bool) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	e.buf.WriteString("<" + tag + ">")
+	for _, key := range keys {
+		if !dictionary {
+			if err := e.encode(key, reflect.ValueOf(entries[key])); err != nil {
+				return err
+			}
+			continue
+		}
+		e.buf.WriteString("<En>")
+		e.primitive("S", "Key", EncodeString(key))
+		if err := e.encode("Value", reflect.ValueOf(entries[key])); err != nil {
+			return err
+		}
+		e.buf.WriteString("</En>")
+	}
+	e.buf.WriteString("</" + tag + ">")
+	return nil
+}
+
+func formatFloat(f float64, bitSize int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "INF"
+	case math.IsInf(f, -1):
+		return "-INF"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'G', -1, bitSize)
+}
+
+// FormatDuration formats d as a xs:duration, such as the PT1M30S serializing System.TimeSpan,
+// leaving out its zero components
+func FormatDuration(d time.Duration) string {
+	var buf strings.Builder
+	if d < 0 {
+		buf.WriteByte('-')
+		d = -d
+	}
+	buf.WriteByte('P')
+	if days := d / (24 * time.Hour); days > 0 {
+		fmt.Fprintf(&buf, "%dD", days)
+		d -= days * 24 * time.Hour
+	}
+	if d == 0 && strings.HasSuffix(buf.String(), "D") {
+		return buf.String()
+	}
+	buf.WriteByte('T')
+	if hours := d / time.Hour; hours > 0 {
+		fmt.Fprintf(&buf, "%dH", hours)
+		d -= hours * time.Hour
+	}
+	if minutes := d / time.Minute; minutes > 0 {
+		fmt.Fprintf(&buf, "%dM", minutes)
+		d -= minutes * time.Minute
+	}
+	if d > 0 || strings.HasSuffix(buf.String(), "T") {
+		fmt.Fprintf(&buf, "%sS", strconv.FormatFloat(d.Seconds(), 'f', -1, 64))
+	}
+	return buf.String()
+}
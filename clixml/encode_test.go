@@ -0,0 +1,109 @@
+package clixml
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestMarshalPrimitives(c *C) {
+	data, err := Marshal("a<b\r\n", true, int32(-4), int64(5), uint8(7), 1.5, []byte{1, 2, 3}, 90*time.Second, nil)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04">`+
+		`<S>a&lt;b_x000D__x000A_</S><B>true</B><I32>-4</I32><I64>5</I64><By>7</By><Db>1.5</Db><BA>AQID</BA><TS>PT1M30S</TS><Nil />`+
+		`</Objs>`)
+}
+
+func (s *MySuite) TestFormatDuration(c *C) {
+	for d, expected := range map[time.Duration]string{
+		0:                                    "PT0S",
+		90 * time.Second:                     "PT1M30S",
+		time.Hour:                            "PT1H",
+		48 * time.Hour:                       "P2D",
+		26*time.Hour + 4250*time.Millisecond: "P1DT2H4.25S",
+		-time.Minute:                         "-PT1M",
+	} {
+		c.Check(FormatDuration(d), Equals, expected)
+		parsed, err := ParseDuration(expected)
+		c.Assert(err, IsNil)
+		c.Check(parsed, Equals, d)
+	}
+}
+
+func (s *MySuite) TestMarshalHashtable(c *C) {
+	data, err := MarshalValue(map[string]interface{}{"b": []string{"x", "y"}, "a": int32(1)})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `<Obj RefId="0"><TN RefId="0"><T>System.Collections.Hashtable</T><T>System.Object</T></TN><DCT>`+
+		`<En><S N="Key">a</S><I32 N="Value">1</I32></En>`+
+		`<En><S N="Key">b</S><Obj N="Value" RefId="1"><TN RefId="1"><T>System.Object[]</T><T>System.Array</T><T>System.Object</T></TN><LST><S>x</S><S>y</S></LST></Obj></En>`+
+		`</DCT></Obj>`)
+}
+
+func (s *MySuite) TestMarshalStruct(c *C) {
+	type credential struct {
+		User     string
+		Password SecureString `clixml:"pwd"`
+		Ignored  string       `clixml:"-"`
+		internal string
+	}
+
+	data, err := Marshal(credential{User: "admin", Password: "AQID", Ignored: "x", internal: "y"}, &credential{User: "guest"})
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04">`+
+		`<Obj RefId="0"><TN RefId="0"><T>System.Management.Automation.PSCustomObject</T><T>System.Object</T></TN><MS><S N="User">admin</S><SS N="pwd">AQID</SS></MS></Obj>`+
+		`<Obj RefId="1"><TNRef RefId="0" /><MS><S N="User">guest</S><SS N="pwd"></SS></MS></Obj>`+
+		`</Objs>`)
+}
+
+func (s *MySuite) TestMarshalUnsupported(c *C) {
+	_, err := Marshal(map[int]string{1: "a"})
+	c.Assert(err, ErrorMatches, "unsupported map key type int")
+
+	_, err = Marshal(make(chan int))
+	c.Assert(err, ErrorMatches, "unsupported type chan int")
+}
+
+func (s *MySuite) TestMarshalRoundTrip(c *C) {
+	now := time.Date(2021, 5, 6, 7, 8, 9, 123000000, time.UTC)
+	values := []interface{}{
+		"_x_ & <tag>",
+		Char('z'),
+		Decimal("3.14"),
+		Version("1.2.3"),
+		ScriptBlock("{ $_ }"),
+		now,
+		-(49*time.Hour + 500*time.Millisecond),
+		float32(2.5),
+		uint64(1 << 40),
+		[]interface{}{int32(1), "two", nil},
+		map[string]interface{}{"key": "value"},
+	}
+
+	data, err := Marshal(values...)
+	c.Assert(err, IsNil)
+
+	elements, err := Unmarshal(data)
+	c.Assert(err, IsNil)
+	c.Assert(elements, HasLen, len(values))
+	for i, element := range elements {
+		if t, ok := values[i].(time.Time); ok {
+			c.Assert(element.Value.(time.Time).Equal(t), Equals, true)
+			continue
+		}
+		c.Assert(element.Value, DeepEquals, values[i])
+	}
+}
+
+func (s *MySuite) TestMarshalObjectRoundTrip(c *C) {
+	value, err := UnmarshalValue([]byte(errorRecordObject))
+	c.Assert(err, IsNil)
+
+	data, err := MarshalValue(value)
+	c.Assert(err, IsNil)
+
+	back, err := UnmarshalValue(data)
+	c.Assert(err, IsNil)
+	c.Assert(back.(*ErrorRecord).Message, Equals, "access denied")
+	c.Assert(back.(*ErrorRecord).PositionMessage, Equals, value.(*ErrorRecord).PositionMessage)
+	c.Assert(back.(*ErrorRecord).TargetObject, Equals, "C:\\secret")
+}
@@ -0,0 +1,186 @@
+package clixml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Namespace is the XML namespace of CLIXML documents
+const Namespace = "http://schemas.microsoft.com/powershell/2004/04"
+
+// Header prefixes the CLIXML documents written by powershell.exe on its output streams
+const Header = "#< CLIXML"
+
+// Primitive types without a direct Go equivalent, so that they survive a round trip
+type (
+	// Char is a UTF-16 code unit (System.Char)
+	Char rune
+	// Decimal is kept in its textual form to avoid losing precision (System.Decimal)
+	Decimal string
+	// Version is a dotted version number (System.Version)
+	Version string
+	// ScriptBlock is the source of a PowerShell script block
+	ScriptBlock string
+	// SecureString is the encrypted form of a System.Security.SecureString
+	SecureString string
+)
+
+// Element is a top level value of a CLIXML document along with the
+// stream it was written to (the S attribute), such as "Error", "progress" or "warning"
+type Element struct {
+	Stream string
+	Value  interface{}
+}
+
+// Object is a deserialized complex object
+type Object struct {
+	// TypeNames lists the type of the object first, then its ancestors
+	TypeNames []string
+	// ToString is the string representation of the object, when it was serialized
+	ToString string
+	// Value is the underlying primitive value, for enums and wrapped primitives
+	Value interface{}
+	// Properties holds both the adapted and the extended properties
+	Properties map[string]interface{}
+	// List holds the items of a collection
+	List []interface{}
+	// Dictionary holds the entries of a dictionary, keyed by their string representation
+	Dictionary map[string]interface{}
+}
+
+// Is reports whether the object is of the given .NET type, or inherits from it
+func (o *Object) Is(typeName string) bool {
+	for _, name := range o.TypeNames {
+		if name == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+// Property returns the value of the named property, following nested objects
+// when name is a dotted path such as "Exception.Message"
+func (o *Object) Property(name string) (interface{}, bool) {
+	current := o
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		value, ok := current.Properties[part]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return value, true
+		}
+		switch next := value.(type) {
+		case *Object:
+			current = next
+		case *ErrorRecord:
+			current = next.Object
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// String returns the ToString of the object, or a textual representation of its value
+func (o *Object) String() string {
+	if o.ToString != "" {
+		return o.ToString
+	}
+	if o.Value != nil {
+		return fmt.Sprint(o.Value)
+	}
+	return ""
+}
+
+// ErrorRecord is a PowerShell error, either deserialized from a
+// System.Management.Automation.ErrorRecord object or rebuilt from the
+// formatted lines powershell.exe writes on its error stream
+type ErrorRecord struct {
+	*Object
+	Message               string
+	FullyQualifiedErrorID string
+	CategoryInfo          string
+	PositionMessage       string
+	ScriptStackTrace      string
+	TargetObject          interface{}
+}
+
+// Error implements the error interface
+func (e *ErrorRecord) Error() string {
+	return e.Message
+}
+
+// ProgressRecord is a progress notification (System.Management.Automation.ProgressRecord)
+type ProgressRecord struct {
+	Activity          string
+	ActivityID        int32
+	StatusDescription string
+	CurrentOperation  string
+	ParentActivityID  int32
+	PercentComplete   int32
+	SecondsRemaining  int32
+	Completed         bool
+}
+
+// EncodeString escapes s for use as the content of a CLIXML string element.
+// Besides the usual XML escaping, control characters are encoded as _xHHHH_
+// and a literal _x is escaped so that it survives the round trip.
+func EncodeString(s string) string {
+	var buf strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '_' && strings.HasPrefix(s[i:], "_x"):
+			buf.WriteString("_x005F_")
+		case r < 0x20 || r == 0x7F:
+			fmt.Fprintf(&buf, "_x%04X_", r)
+		case r == '<':
+			buf.WriteString("&lt;")
+		case r == '>':
+			buf.WriteString("&gt;")
+		case r == '&':
+			buf.WriteString("&amp;")
+		case r == '"':
+			buf.WriteString("&quot;")
+		case r == '\'':
+			buf.WriteString("&apos;")
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
+
+// DecodeString reverses the _xHHHH_ escaping applied by EncodeString,
+// including UTF-16 surrogate pairs encoded as two consecutive escapes
+func DecodeString(s string) string {
+	if !strings.Contains(s, "_x") {
+		return s
+	}
+
+	var buf strings.Builder
+	var high rune
+	for len(s) > 0 {
+		if len(s) >= 7 && s[0] == '_' && s[1] == 'x' && s[6] == '_' {
+			if code, err := strconv.ParseUint(s[2:6], 16, 16); err == nil {
+				r := rune(code)
+				switch {
+				case r >= 0xD800 && r < 0xDC00:
+					high = r
+				case r >= 0xDC00 && r < 0xE000 && high != 0:
+					buf.WriteRune((high-0xD800)<<10 + (r - 0xDC00) + 0x10000)
+					high = 0
+				default:
+					buf.WriteRune(r)
+				}
+				s = s[7:]
+				continue
+			}
+		}
+		buf.WriteByte(s[0])
+		s = s[1:]
+	}
+	return buf.String()
+}
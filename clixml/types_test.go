@@ -0,0 +1,56 @@
+package clixml
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+func (s *MySuite) TestEncodeString(c *C) {
+	c.Assert(EncodeString("a<b>&\"c'\r\n_x_"), Equals, "a&lt;b&gt;&amp;&quot;c&apos;_x000D__x000A__x005F_x_")
+	c.Assert(DecodeString("a_x000D__x000A__x005F_x_"), Equals, "a\r\n_x_")
+	c.Assert(DecodeString("no_xescape_"), Equals, "no_xescape_")
+}
+
+func (s *MySuite) TestDecodeStringSurrogatePair(c *C) {
+	c.Assert(DecodeString("_xD83D__xDE00_!"), Equals, "\U0001F600!")
+}
+
+func (s *MySuite) TestObjectProperty(c *C) {
+	object := &Object{
+		TypeNames: []string{"System.Management.Automation.ErrorRecord", "System.Object"},
+		Properties: map[string]interface{}{
+			"Exception": &Object{Properties: map[string]interface{}{"Message": "boom"}},
+			"Count":     int32(2),
+		},
+	}
+
+	c.Assert(object.Is("System.Object"), Equals, true)
+	c.Assert(object.Is("System.String"), Equals, false)
+
+	value, ok := object.Property("Exception.Message")
+	c.Assert(ok, Equals, true)
+	c.Assert(value, Equals, "boom")
+
+	value, ok = object.Property("Count")
+	c.Assert(ok, Equals, true)
+	c.Assert(value, Equals, int32(2))
+
+	_, ok = object.Property("Count.Value")
+	c.Assert(ok, Equals, false)
+	_, ok = object.Property("Missing")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *MySuite) TestObjectString(c *C) {
+	c.Assert((&Object{ToString: "Running", Value: int32(4)}).String(), Equals, "Running")
+	c.Assert((&Object{Value: int32(4)}).String(), Equals, "4")
+	c.Assert((&Object{}).String(), Equals, "")
+}
@@ -1,19 +1,42 @@
 package winrm
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/masterzen/winrm/soap"
+)
+
+// Signal codes sent to a command with Command.Signal
+const (
+	// SignalTerminate terminates the command
+	SignalTerminate = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate"
+	// SignalCtrlC sends a Ctrl+C to the command, which may handle it to exit gracefully
+	SignalCtrlC = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/ctrl_c"
+	// SignalCtrlBreak sends a Ctrl+Break to the command
+	SignalCtrlBreak = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/ctrl_break"
 )
 
 type commandWriter struct {
 	*Command
 	mutex sync.Mutex
 	eof   bool
+
+	// buffer holds the input not sent yet when the input is buffered, see StdinBufferSize
+	buffer []byte
+	timer  *time.Timer
+	// err is the error of sending the buffer, returned by the writer from then on since the
+	// input which could not be sent is dropped
+	err error
+	// chunkSize is the number of bytes of input sent in a request, see maxInputSize
+	chunkSize int
 }
 
 type commandReader struct {
@@ -23,15 +46,50 @@ type commandReader struct {
 	stream string
 }
 
+// errCommandCanceled closes the output streams of a command closed before it finished
+var errCommandCanceled = errors.New("canceled")
+
+// LostOutputError is returned by the output streams of a command when the sequence id of a
+// Receive response is not the expected one, the output of the missing responses being lost
+type LostOutputError struct {
+	Expected uint64
+	Received uint64
+}
+
+func (e *LostOutputError) Error() string {
+	return fmt.Sprintf("lost command output: expected sequence id %d, received %d", e.Expected, e.Received)
+}
+
 // Command represents a given command running on a Shell. This structure allows to get access
 // to the various stdout, stderr and stdin pipes.
 type Command struct {
+	ctx      context.Context
 	client   *Client
 	shell    *Shell
 	id       string
 	exitCode int
 	err      error
 
+	// sequenceID is the sequence id of the next Receive response, sent to the server once
+	// the command is resumed so that the output is received from where it was left
+	sequenceID atomic.Uint64
+	// sequenced is set once the sequence id of the next response is known
+	sequenced bool
+	resumed   bool
+
+	// streams are the names of the output streams received, whose readers are in readers
+	streams []string
+	readers map[string]*commandReader
+
+	// resultMutex guards the state of the command reported by Result
+	resultMutex sync.Mutex
+	started     time.Time
+	ended       time.Time
+	finished    bool
+	signaled    bool
+	receives    int
+	received    map[string]int64
+
 	Stdin  *commandWriter
 	Stdout *commandReader
 	Stderr *commandReader
@@ -40,25 +98,53 @@ type Command struct {
 	cancel chan struct{}
 }
 
-func newCommand(ctx context.Context, shell *Shell, ids string) *Command {
+func newCommand(ctx context.Context, shell *Shell, ids string, started time.Time) *Command {
+	command := initCommand(ctx, shell, ids)
+	command.started = started
+	go fetchOutput(ctx, command)
+	return command
+}
+
+// newResumedCommand resumes receiving the output of the command ids from the Receive
+// response sequenceID
+func newResumedCommand(ctx context.Context, shell *Shell, ids string, sequenceID uint64) *Command {
+	command := initCommand(ctx, shell, ids)
+	command.sequenceID.Store(sequenceID)
+	command.sequenced = sequenceID > 0
+	command.resumed = true
+	go fetchOutput(ctx, command)
+	return command
+}
+
+func initCommand(ctx context.Context, shell *Shell, ids string) *Command {
 	command := &Command{
+		ctx:      ctx,
 		shell:    shell,
 		client:   shell.client,
 		id:       ids,
 		exitCode: 0,
 		err:      nil,
+		streams:  shell.receivedStreams(),
+		readers:  make(map[string]*commandReader),
+		started:  time.Now(),
+		received: make(map[string]int64),
 		done:     make(chan struct{}),
 		cancel:   make(chan struct{}),
 	}
 
-	command.Stdout = newCommandReader("stdout", command)
+	// stdout and stderr always have a reader, closed when the command ends
+	// even when they are not received
+	for _, stream := range append([]string{"stdout", "stderr"}, command.streams...) {
+		if _, ok := command.readers[stream]; !ok {
+			command.readers[stream] = newCommandReader(stream, command)
+		}
+	}
+	command.Stdout = command.readers["stdout"]
 	command.Stdin = &commandWriter{
 		Command: command,
 		eof:     false,
 	}
-	command.Stderr = newCommandReader("stderr", command)
-
-	go fetchOutput(ctx, command)
+	command.Stderr = command.readers["stderr"]
 
 	return command
 }
@@ -73,28 +159,163 @@ func newCommandReader(stream string, command *Command) *commandReader {
 	}
 }
 
+// fetchOutput receives the output of the command until it ends or is closed. The output of
+// each Receive response is consumed from the output streams before the next one is requested.
 func fetchOutput(ctx context.Context, command *Command) {
+	defer close(command.done)
+	defer command.end()
+
 	ctxDone := ctx.Done()
 	for {
 		select {
 		case <-command.cancel:
-			_, _ = command.slurpAllOutput()
-			err := errors.New("canceled")
-			command.Stderr.write.CloseWithError(err)
-			command.Stdout.write.CloseWithError(err)
-			close(command.done)
+			// the command was closed, its remaining output is not received
+			command.closeStreams(errCommandCanceled)
 			return
 		case <-ctxDone:
 			command.err = ctx.Err()
 			ctxDone = nil
 			command.Close()
+			continue
 		default:
-			finished, err := command.slurpAllOutput()
-			if finished {
-				command.err = err
-				close(command.done)
-				return
-			}
+		}
+
+		output, retry, err := command.receive()
+		if retry {
+			continue
+		}
+		if err != nil {
+			command.err = err
+			command.closeStreams(err)
+			return
+		}
+		if !command.deliver(output) {
+			return
+		}
+		if output.Done {
+			command.resultMutex.Lock()
+			command.exitCode = output.ExitCode
+			command.finished = true
+			command.resultMutex.Unlock()
+			command.closeStreams(nil)
+			return
+		}
+	}
+}
+
+// receive sends a Receive request for the output of the command, returning whether it must
+// be retried, such as when the command had no output within the operation timeout
+func (c *Command) receive() (*ReceiveOutput, bool, error) {
+	if err := c.check(); err != nil {
+		return nil, false, err
+	}
+
+	streams := strings.Join(c.streams, " ")
+	var request *soap.SoapMessage
+	if c.resumed {
+		request = NewResumeOutputRequest(c.client.url, c.shell.id, c.id, streams, c.sequenceID.Load(), &c.client.Parameters)
+	} else {
+		request = NewGetOutputRequest(c.client.url, c.shell.id, c.id, streams, &c.client.Parameters)
+	}
+	defer request.Free()
+
+	c.resultMutex.Lock()
+	c.receives++
+	c.resultMutex.Unlock()
+
+	response, err := c.client.sendRequestWithContext(c.ctx, request)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			// the context was canceled, fetchOutput is going to close the command
+			return nil, true, err
+		}
+		var errWithTimeout *url.Error
+		if errors.As(err, &errWithTimeout) && errWithTimeout.Timeout() {
+			// Operation timeout because the server didn't respond in time
+			return nil, true, err
+		}
+		if isOperationTimeout(err) {
+			// Operation timeout because there was no command output
+			return nil, true, err
+		}
+		if strings.Contains(err.Error(), "EOF") {
+			c.resultMutex.Lock()
+			c.exitCode = 16001
+			c.resultMutex.Unlock()
+		}
+		return nil, false, err
+	}
+
+	output, err := ParseReceiveResponse(response)
+	if err != nil {
+		return nil, false, err
+	}
+	if output.HasSequenceID {
+		if expected := c.sequenceID.Load(); c.sequenced && output.SequenceID != expected {
+			return nil, false, &LostOutputError{Expected: expected, Received: output.SequenceID}
+		}
+		c.sequenced = true
+		c.sequenceID.Store(output.SequenceID + 1)
+	}
+	return output, false, nil
+}
+
+// deliver writes the output of a Receive response to the output streams, returning once it
+// was consumed from all of them, or false when the command was closed in the meantime
+func (c *Command) deliver(output *ReceiveOutput) bool {
+	c.resultMutex.Lock()
+	for stream, content := range output.Streams {
+		c.received[stream] += int64(len(content))
+	}
+	c.resultMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for stream, content := range output.Streams {
+		reader := c.readers[stream]
+		if reader == nil || len(content) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(content []byte) {
+			defer wg.Done()
+			// fails when the stream is discarded or closed
+			_, _ = reader.write.Write(content)
+		}(content)
+	}
+
+	consumed := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(consumed)
+	}()
+
+	select {
+	case <-consumed:
+		return true
+	case <-c.ctx.Done():
+		c.err = c.ctx.Err()
+		c.Close()
+	case <-c.cancel:
+	}
+	c.closeStreams(errCommandCanceled)
+	<-consumed
+	return false
+}
+
+// end records the time fetchOutput ended
+func (c *Command) end() {
+	c.resultMutex.Lock()
+	c.ended = time.Now()
+	c.resultMutex.Unlock()
+}
+
+// closeStreams closes the output streams of the command, with err unless it is nil
+func (c *Command) closeStreams(err error) {
+	for _, reader := range c.readers {
+		if err == nil {
+			_ = reader.write.Close()
+		} else {
+			_ = reader.write.CloseWithError(err)
 		}
 	}
 }
@@ -114,6 +335,11 @@ func (c *Command) check() error {
 
 // Close will terminate the running command
 func (c *Command) Close() error {
+	return c.CloseWithContext(context.Background())
+}
+
+// CloseWithContext will terminate the running command, canceling the request with ctx
+func (c *Command) CloseWithContext(ctx context.Context) error {
 	if err := c.check(); err != nil {
 		return err
 	}
@@ -124,64 +350,47 @@ func (c *Command) Close() error {
 		close(c.cancel)
 	}
 
-	request := NewSignalRequest(c.client.url, c.shell.id, c.id, &c.client.Parameters)
-	defer request.Free()
+	// terminating the command isn't signaling it, see CommandResult.Signaled
+	return c.signal(ctx, SignalTerminate)
+}
 
-	_, err := c.client.sendRequest(request)
-	return err
+// Signal sends the signal code, such as SignalCtrlC, to the command, canceling the request
+// with ctx. Unlike Close, the output of the command is still received until it exits, so that
+// a graceful shutdown can send SignalCtrlC, wait for the command for a while, and only then
+// Close it.
+func (c *Command) Signal(ctx context.Context, code string) error {
+	if err := c.signal(ctx, code); err != nil {
+		return err
+	}
+
+	c.resultMutex.Lock()
+	// signaling a finished command does nothing
+	c.signaled = c.signaled || !c.finished
+	c.resultMutex.Unlock()
+	return nil
 }
 
-func (c *Command) slurpAllOutput() (bool, error) {
+func (c *Command) signal(ctx context.Context, code string) error {
 	if err := c.check(); err != nil {
-		c.Stderr.write.CloseWithError(err)
-		c.Stdout.write.CloseWithError(err)
-		return true, err
+		return err
 	}
 
-	request := NewGetOutputRequest(c.client.url, c.shell.id, c.id, "stdout stderr", &c.client.Parameters)
+	request := NewSignalRequestWithCode(c.client.url, c.shell.id, c.id, code, &c.client.Parameters)
 	defer request.Free()
 
-	response, err := c.client.sendRequest(request)
-	if err != nil {
-		var errWithTimeout *url.Error
-		if errors.As(err, &errWithTimeout) && errWithTimeout.Timeout() {
-			// Operation timeout because the server didn't respond in time
-			return false, err
-		}
-		if strings.Contains(err.Error(), "OperationTimeout") {
-			// Operation timeout because there was no command output
-			return false, err
-		}
-		if strings.Contains(err.Error(), "EOF") {
-			c.exitCode = 16001
-		}
-
-		c.Stderr.write.CloseWithError(err)
-		c.Stdout.write.CloseWithError(err)
-		return true, err
-	}
+	_, err := c.client.sendRequestWithContext(ctx, request)
+	return err
+}
 
-	var exitCode int
-	var stdout, stderr bytes.Buffer
-	finished, exitCode, err := ParseSlurpOutputErrResponse(response, &stdout, &stderr)
-	if err != nil {
-		c.Stderr.write.CloseWithError(err)
-		c.Stdout.write.CloseWithError(err)
-		return true, err
-	}
-	if stdout.Len() > 0 {
-		_, _ = c.Stdout.write.Write(stdout.Bytes())
-	}
-	if stderr.Len() > 0 {
-		_, _ = c.Stderr.write.Write(stderr.Bytes())
-	}
-	if finished {
-		c.exitCode = exitCode
-		_ = c.Stderr.write.Close()
-		_ = c.Stdout.write.Close()
-	}
+// ID returns the id of the command, which with SequenceID allows resuming its output
+// once its shell is reconnected, see Shell.ResumeCommand
+func (c *Command) ID() string {
+	return c.id
+}
 
-	return finished, nil
+// SequenceID returns the sequence id of the next output of the command to be received
+func (c *Command) SequenceID() uint64 {
+	return c.sequenceID.Load()
 }
 
 func (c *Command) sendInput(data []byte, eof bool) error {
@@ -192,12 +401,14 @@ func (c *Command) sendInput(data []byte, eof bool) error {
 	request := NewSendInputRequest(c.client.url, c.shell.id, c.id, data, eof, &c.client.Parameters)
 	defer request.Free()
 
-	_, err := c.client.sendRequest(request)
+	_, err := c.client.sendRequestWithContext(c.ctx, request)
 	return err
 }
 
 // ExitCode returns command exit code when it is finished. Before that the result is always 0.
 func (c *Command) ExitCode() int {
+	c.resultMutex.Lock()
+	defer c.resultMutex.Unlock()
 	return c.exitCode
 }
 
@@ -208,7 +419,10 @@ func (c *Command) Wait() {
 }
 
 // Write data to this Pipe
-// commandWriter implements io.Writer and io.Closer interface
+// commandWriter implements io.Writer and io.Closer interface.
+// Unless the input is buffered, see StdinBufferSize and StdinFlushInterval, each write is sent
+// to the command right away. Once sending the buffered input failed, the input left in the
+// buffer is dropped and the writer returns that error from then on.
 func (w *commandWriter) Write(data []byte) (int, error) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
@@ -216,45 +430,64 @@ func (w *commandWriter) Write(data []byte) (int, error) {
 	if w.eof {
 		return 0, io.ErrClosedPipe
 	}
-
-	var (
-		written int
-		err     error
-	)
-	origLen := len(data)
-	for len(data) > 0 {
-		// never send more data than our EnvelopeSize.
-		n := min(w.client.Parameters.EnvelopeSize-1000, len(data))
-		if err := w.sendInput(data[:n], false); err != nil {
-			break
-		}
-		data = data[n:]
-		written += n
+	if w.err != nil {
+		return 0, w.err
 	}
-
-	// signal that we couldn't write all data
-	if err == nil && written < origLen {
-		err = io.ErrShortWrite
+	if !w.buffered() {
+		return w.send(data, false)
 	}
 
-	return written, err
+	buffered := len(w.buffer)
+	w.buffer = append(w.buffer, data...)
+	if len(w.buffer) >= w.bufferSize() {
+		if n, err := w.flush(false); err != nil {
+			// the bytes of data which were not sent are dropped
+			return max(n-buffered, 0), err
+		}
+	} else if interval := w.client.Parameters.StdinFlushInterval; interval > 0 && w.timer == nil {
+		w.timer = time.AfterFunc(interval, w.timedFlush)
+	}
+	return len(data), nil
 }
 
 // Write data to this Pipe and mark EOF
 func (w *commandWriter) WriteClose(data []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.eof {
+		return 0, io.ErrClosedPipe
+	}
 	w.eof = true
-	return w.Write(data)
+	if w.err != nil {
+		return 0, w.err
+	}
+	buffered := len(w.buffer)
+	w.buffer = append(w.buffer, data...)
+	if n, err := w.flush(true); err != nil {
+		return max(n-buffered, 0), err
+	}
+	return len(data), nil
 }
 
-func min(a int, b int) int {
-	if a < b {
-		return a
+// Flush sends the buffered input to the command
+func (w *commandWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return w.err
 	}
-	return b
+	if w.eof {
+		return nil
+	}
+	_, err := w.flush(false)
+	return err
 }
 
 // Close method wrapper
-// commandWriter implements io.Closer interface
+// commandWriter implements io.Closer interface.
+// The buffered input is sent along with the end of the input.
 func (w *commandWriter) Close() error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
@@ -263,7 +496,140 @@ func (w *commandWriter) Close() error {
 		return io.ErrClosedPipe
 	}
 	w.eof = true
-	return w.sendInput(nil, w.eof)
+	if w.err != nil {
+		return w.err
+	}
+	_, err := w.flush(true)
+	return err
+}
+
+func (w *commandWriter) buffered() bool {
+	return w.client.Parameters.StdinBufferSize > 0 || w.client.Parameters.StdinFlushInterval > 0
+}
+
+// bufferSize returns the number of buffered bytes sent right away
+func (w *commandWriter) bufferSize() int {
+	size := w.client.Parameters.StdinBufferSize
+	if max := w.maxInputSize(); size <= 0 || size > max {
+		return max
+	}
+	return size
+}
+
+// maxInputSize returns the number of bytes of input sent in a request, the most which
+// base64 encoded fit in the EnvelopeSize along with the rest of the Send request
+func (w *commandWriter) maxInputSize() int {
+	if w.chunkSize > 0 {
+		return w.chunkSize
+	}
+
+	// one byte of input is encoded as 4 characters, measuring the request with input
+	// rather than without it, which could be an empty element
+	request := NewSendInputRequest(w.client.url, w.shell.id, w.id, []byte{0}, true, &w.client.Parameters)
+	overhead := len(request.String()) - 4
+	request.Free()
+
+	w.chunkSize = (w.client.Parameters.EnvelopeSize - overhead) / 4 * 3
+	if w.chunkSize < minInputSize {
+		w.chunkSize = minInputSize
+	}
+	return w.chunkSize
+}
+
+// minInputSize is the number of bytes of input sent in a request when the EnvelopeSize
+// leaves no room for more, or once the service rejected larger ones
+const minInputSize = 3
+
+// flush sends the buffer, followed by the end of the input if eof, returning the number of
+// bytes of the buffer sent. When it fails, the rest of the buffer is dropped and the error
+// kept in err.
+func (w *commandWriter) flush(eof bool) (int, error) {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.buffer) == 0 && !eof {
+		return 0, nil
+	}
+
+	n, err := w.send(w.buffer, eof)
+	w.buffer = nil
+	w.err = err
+	return n, err
+}
+
+// timedFlush sends the buffer once StdinFlushInterval elapsed, its error being returned by
+// the calls of the writer which follow
+func (w *commandWriter) timedFlush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.timer = nil
+	if w.eof || w.err != nil {
+		return
+	}
+	_, _ = w.flush(false)
+}
+
+// send sends data in as many requests as needed, the last one marking the end of the input
+// if eof, returning the number of bytes sent
+func (w *commandWriter) send(data []byte, eof bool) (int, error) {
+	if len(data) == 0 && !eof {
+		return 0, nil
+	}
+	written := 0
+	for {
+		n := min(w.maxInputSize(), len(data)-written)
+		last := written+n == len(data)
+		if err := w.sendInput(data[written:written+n], eof && last); err != nil {
+			// the service accepts smaller envelopes than our EnvelopeSize, resend in smaller chunks
+			if isEnvelopeSizeExceeded(err) && n > minInputSize {
+				w.chunkSize = max(n/2, minInputSize)
+				continue
+			}
+			return written, err
+		}
+		written += n
+		if last {
+			return written, nil
+		}
+	}
+}
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Stream returns the reader of the output stream named stream, such as a custom stream of the
+// shell, or nil when the stream is not received. Stdout and Stderr always have a reader.
+func (c *Command) Stream(stream string) *commandReader {
+	return c.readers[stream]
+}
+
+// discardOtherStreams discards the output streams other than stdout and stderr, which would
+// hold back the output of the command when only stdout and stderr are read
+func (c *Command) discardOtherStreams() {
+	for stream, reader := range c.readers {
+		if stream != "stdout" && stream != "stderr" {
+			reader.Discard()
+		}
+	}
+}
+
+// Discard drops the rest of the output of this stream, which must otherwise be read
+// for the output of the command to be received
+func (r *commandReader) Discard() {
+	_ = r.read.Close()
 }
 
 // Read data from this Pipe
@@ -0,0 +1,66 @@
+package winrm
+
+import "time"
+
+// CommandResult records what happened to a command, see Command.Result
+type CommandResult struct {
+	ShellID   string
+	CommandID string
+	// ExitCode is the exit code of the command when it Finished
+	ExitCode int
+	// Finished is set once the server reported the command as done
+	Finished bool
+	// Signaled is set when a signal was sent to the running command with Signal, the
+	// termination of Close not counting as one
+	Signaled bool
+	// Started is the time the command was executed, or resumed for a resumed command
+	Started time.Time
+	// Ended is the time the output of the command stopped being received, zero while it runs
+	Ended time.Time
+	// Receives is the number of Receive round trips made for the output of the command
+	Receives int
+	// Bytes is the number of bytes received by name of output stream, including the
+	// discarded ones
+	Bytes map[string]int64
+	// Err is the error which stopped receiving the output of the command, if any,
+	// once it Ended
+	Err error
+}
+
+// Duration returns the time the command ran, until now while it is running
+func (r *CommandResult) Duration() time.Duration {
+	if r.Ended.IsZero() {
+		return time.Since(r.Started)
+	}
+	return r.Ended.Sub(r.Started)
+}
+
+// Result returns the state of the command, which is final once Wait returned
+func (c *Command) Result() *CommandResult {
+	c.resultMutex.Lock()
+	defer c.resultMutex.Unlock()
+
+	result := &CommandResult{
+		ShellID:   c.shell.id,
+		CommandID: c.id,
+		Finished:  c.finished,
+		Signaled:  c.signaled,
+		Started:   c.started,
+		Ended:     c.ended,
+		Receives:  c.receives,
+		Bytes:     make(map[string]int64, len(c.received)),
+	}
+	if c.finished {
+		result.ExitCode = c.exitCode
+	}
+	for stream, n := range c.received {
+		result.Bytes[stream] = n
+	}
+
+	select {
+	case <-c.done:
+		result.Err = c.err
+	default:
+	}
+	return result
+}
@@ -0,0 +1,151 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/masterzen/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestCommandResult(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	count := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "shell/Command<"):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Signal<"):
+			return "", nil
+		}
+		count++
+		if count == 1 {
+			return outputResponse, nil
+		}
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	before := time.Now()
+	command, err := shell.ExecuteWithContext(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	go func() { _, _ = io.Copy(io.Discard, command.Stderr) }()
+	_, _ = io.Copy(io.Discard, command.Stdout)
+	command.Wait()
+	c.Assert(command.Close(), IsNil)
+
+	result := command.Result()
+	c.Check(result.ShellID, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Check(result.CommandID, Equals, "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4")
+	c.Check(result.ExitCode, Equals, 123)
+	c.Check(result.Finished, Equals, true)
+	c.Check(result.Signaled, Equals, false)
+	c.Check(result.Receives, Equals, 2)
+	c.Check(result.Bytes, DeepEquals, map[string]int64{"stdout": 19, "stderr": 32})
+	c.Check(result.Err, IsNil)
+	c.Check(result.Started.Before(before), Equals, false)
+	c.Check(result.Ended.Before(result.Started), Equals, false)
+	c.Check(result.Duration(), Equals, result.Ended.Sub(result.Started))
+}
+
+func (s *WinRMSuite) TestCommandResultSignaled(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	closed := make(chan struct{})
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "shell/Command<"):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Signal<"):
+			if strings.Contains(body, SignalTerminate) {
+				close(closed)
+			}
+			return "", nil
+		}
+		// the command runs until it is closed
+		<-closed
+		fault, err := ParseFault(operationTimeoutResponse)
+		c.Assert(err, IsNil)
+		return "", fault
+	}
+	client.http = &r
+
+	command, err := shell.ExecuteWithContext(context.Background(), "ping -t localhost")
+	c.Assert(err, IsNil)
+	c.Check(command.Result().Ended.IsZero(), Equals, true)
+	c.Check(command.Result().Signaled, Equals, false)
+
+	c.Assert(command.Signal(context.Background(), SignalCtrlC), IsNil)
+	c.Assert(command.Close(), IsNil)
+	command.Wait()
+
+	result := command.Result()
+	c.Check(result.Finished, Equals, false)
+	c.Check(result.Signaled, Equals, true)
+	c.Check(result.ExitCode, Equals, 0)
+	c.Check(result.Ended.IsZero(), Equals, false)
+}
+
+func (s *WinRMSuite) TestCommandResultClosedNotSignaled(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	var signals int
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "transfer/Create<"):
+			return createShellResponse, nil
+		case strings.Contains(body, "shell/Command<"):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Signal<"):
+			signals++
+			return "", nil
+		case strings.Contains(body, "shell/Receive<"):
+			return "", errors.New("connection reset by peer")
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	// the command is closed after failing to receive its output, unfinished
+	result, err := client.RunWithResult(context.Background(), "ipconfig /all", io.Discard, io.Discard, nil)
+	c.Assert(err, NotNil)
+	c.Assert(result, NotNil)
+	c.Check(signals, Equals, 1)
+	c.Check(result.Finished, Equals, false)
+	c.Check(result.Signaled, Equals, false)
+	c.Check(result.Err, ErrorMatches, ".*connection reset by peer")
+}
+
+func (s *WinRMSuite) TestRunWithResult(c *C) {
+	client, _, stop := runShellPoolFakeServer(c)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	result, err := client.RunWithResult(context.Background(), "hostname", &stdout, &stderr, nil)
+	c.Assert(err, IsNil)
+	c.Check(result.ShellID, Equals, "00000000-0000-4000-8000-000000000001")
+	c.Check(result.CommandID, Equals, "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4")
+	c.Check(result.Finished, Equals, true)
+	c.Check(result.Signaled, Equals, false)
+	c.Check(result.ExitCode, Equals, 0)
+	c.Check(result.Receives, Equals, 1)
+}
@@ -2,9 +2,13 @@ package winrm
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -127,6 +131,164 @@ func (s *WinRMSuite) TestCommandExitCode(c *C) {
 	c.Assert(command.ExitCode(), Equals, 123)
 }
 
+func (s *WinRMSuite) TestCommandSignalKeepsReceiving(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	interrupted := make(chan struct{})
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "shell/Command<"):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Signal<"):
+			c.Assert(body, Contains, "<rsp:Code>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/ctrl_c</rsp:Code>")
+			close(interrupted)
+			return "", nil
+		}
+		// the command runs until it handles Ctrl+C and exits
+		<-interrupted
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	command, err := shell.ExecuteWithContext(context.Background(), "ping -t localhost")
+	c.Assert(err, IsNil)
+	c.Assert(command.Signal(context.Background(), SignalCtrlC), IsNil)
+	command.Wait()
+
+	c.Assert(command.ExitCode(), Equals, 123)
+}
+
+func (s *WinRMSuite) TestCommandCustomStreams(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	shell.SetOutputStreams("stdout", "progress", "debug")
+	count := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		if strings.Contains(body, "shell/Command<") {
+			return executeCommandResponse, nil
+		}
+		c.Assert(body, Contains, ">stdout progress debug</rsp:DesiredStream>")
+		count++
+		if count == 1 {
+			response := strings.Replace(outputResponse, "Name=\"stderr\"", "Name=\"progress\"", 1)
+			return strings.Replace(response, "</rsp:ReceiveResponse>", "<rsp:Stream Name=\"debug\" CommandId=\"1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4\">ZGVidWc=</rsp:Stream></rsp:ReceiveResponse>", 1), nil
+		}
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	command, err := shell.ExecuteWithContext(context.Background(), "setup.exe")
+	c.Assert(err, IsNil)
+	c.Assert(command.Stream("stderr"), Equals, command.Stderr)
+	c.Assert(command.Stream("verbose"), IsNil)
+	command.Stream("debug").Discard()
+
+	var stdout, stderr, progress []byte
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() { defer wg.Done(); stdout, _ = io.ReadAll(command.Stdout) }()
+	go func() { defer wg.Done(); stderr, _ = io.ReadAll(command.Stderr) }()
+	go func() { defer wg.Done(); progress, _ = io.ReadAll(command.Stream("progress")) }()
+	command.Wait()
+	wg.Wait()
+
+	c.Check(string(stdout), Equals, "That's all folks!!!")
+	c.Check(string(stderr), Equals, "")
+	c.Check(string(progress), Equals, "This is stderr, I'm pretty sure!")
+	c.Check(command.ExitCode(), Equals, 123)
+}
+
+func (s *WinRMSuite) TestCommandBackPressure(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	receives := make(chan struct{}, 10)
+	count := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), "shell/Command<") {
+			return executeCommandResponse, nil
+		}
+		count++
+		receives <- struct{}{}
+		if count == 1 {
+			return singleOutputResponse, nil
+		}
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	command, err := shell.ExecuteWithContext(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+
+	<-receives
+	select {
+	case <-receives:
+		c.Fatal("the output was received again before the previous one was consumed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	buf := make([]byte, 6)
+	n, err := command.Stdout.Read(buf)
+	c.Assert(err, IsNil)
+	c.Check(string(buf[:n]), Equals, "That's")
+	select {
+	case <-receives:
+		c.Fatal("the output was received again before the previous one was consumed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	rest, err := io.ReadAll(command.Stdout)
+	c.Assert(err, IsNil)
+	c.Check(string(rest), Equals, " all folks!!!")
+	command.Wait()
+	c.Check(command.ExitCode(), Equals, 123)
+}
+
+func (s *WinRMSuite) TestCommandLostOutput(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	sequenceID := 0
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		if strings.Contains(message.String(), "shell/Command<") {
+			return executeCommandResponse, nil
+		}
+		response := strings.Replace(singleOutputResponse, "<rsp:ReceiveResponse>", fmt.Sprintf("<rsp:ReceiveResponse SequenceID=\"%d\">", sequenceID), 1)
+		// the response of sequence id 1 is lost
+		sequenceID += 2
+		return response, nil
+	}
+	client.http = &r
+
+	command, err := shell.ExecuteWithContext(context.Background(), "ipconfig /all")
+	c.Assert(err, IsNil)
+	go func() { _, _ = io.ReadAll(command.Stderr) }()
+
+	stdout, err := io.ReadAll(command.Stdout)
+	c.Check(string(stdout), Equals, "That's all folks!!!")
+	var lost *LostOutputError
+	c.Assert(errors.As(err, &lost), Equals, true)
+	c.Check(lost.Expected, Equals, uint64(1))
+	c.Check(lost.Received, Equals, uint64(2))
+	command.Wait()
+}
+
 func (s *WinRMSuite) TestCloseCommandStopsFetch(c *C) {
 	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
 	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
@@ -294,3 +456,266 @@ func (s *WinRMSuite) TestEOFError(c *C) {
 	c.Assert(command.exitCode, Equals, 16001)
 	c.Assert(command.err.Error(), Contains, "EOF")
 }
+
+// recordStdin runs a command recording its input, the service rejecting the requests
+// holding more than limit bytes of input unless limit is 0
+func recordStdin(c *C, client *Client, limit int) (*Command, func() []string) {
+	sent := recordStdinRequests(c, client, limit)
+	return executeMore(c, client), sent
+}
+
+// recordStdinRequests makes the client answer like recordStdin without executing the command
+func recordStdinRequests(c *C, client *Client, limit int) func() []string {
+	var mutex sync.Mutex
+	var sent []string
+	closed := make(chan struct{})
+
+	stdin := regexp.MustCompile(`<rsp:Stream[^>]*Name="stdin"[^>]*?(?:/>|>([^<]*)</rsp:Stream>)`)
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "shell/Command<"):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Send<"):
+			match := stdin.FindStringSubmatch(body)
+			c.Assert(match, NotNil)
+			c.Assert(len(body) <= client.Parameters.EnvelopeSize, Equals, true)
+			data, err := base64.StdEncoding.DecodeString(match[1])
+			c.Assert(err, IsNil)
+			if limit > 0 && len(data) > limit {
+				return "", responseError(500, maxEnvelopeSizeResponse)
+			}
+			if strings.Contains(body, "End=\"true\"") {
+				data = append(data, "<EOF>"...)
+			}
+			mutex.Lock()
+			sent = append(sent, string(data))
+			mutex.Unlock()
+			return "", nil
+		case strings.Contains(body, "shell/Signal<"):
+			close(closed)
+			return "", nil
+		}
+		<-closed
+		return doneCommandResponse, nil
+	}
+	client.http = &r
+
+	return func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string(nil), sent...)
+	}
+}
+
+func executeMore(c *C, client *Client) *Command {
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	command, err := shell.ExecuteWithContext(context.Background(), "more")
+	c.Assert(err, IsNil)
+	return command
+}
+
+func (s *WinRMSuite) TestStdinUnbuffered(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	command, sent := recordStdin(c, client, 0)
+	defer command.Close()
+	// room for 4 characters of base64, 3 bytes of input
+	client.Parameters.EnvelopeSize = sendInputOverhead(command) + 4
+
+	n, err := command.Stdin.Write([]byte("abcdefghij"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 10)
+	c.Assert(sent(), DeepEquals, []string{"abc", "def", "ghi", "j"})
+
+	n, err = command.Stdin.WriteClose([]byte("xyz"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 3)
+	c.Assert(sent(), DeepEquals, []string{"abc", "def", "ghi", "j", "xyz<EOF>"})
+
+	_, err = command.Stdin.Write([]byte("late"))
+	c.Assert(err, Equals, io.ErrClosedPipe)
+}
+
+// sendInputOverhead returns the size of the last Send request of command without its input
+func sendInputOverhead(command *Command) int {
+	request := NewSendInputRequest(command.client.url, command.shell.id, command.id, []byte("abc"), true, &command.client.Parameters)
+	defer request.Free()
+	return len(request.String()) - len("YWJj")
+}
+
+func (s *WinRMSuite) TestStdinFitsEnvelopeSize(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	command, sent := recordStdin(c, client, 0)
+	defer command.Close()
+
+	data := bytes.Repeat([]byte("x"), 3*client.Parameters.EnvelopeSize/4)
+	n, err := command.Stdin.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(data))
+
+	chunks := sent()
+	c.Assert(chunks, HasLen, 2)
+	c.Assert(len(chunks[0]), Equals, (client.Parameters.EnvelopeSize-sendInputOverhead(command))/4*3)
+	c.Assert(strings.Join(chunks, ""), Equals, string(data))
+}
+
+func (s *WinRMSuite) TestStdinEnvelopeSizeFault(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	command, sent := recordStdin(c, client, 4)
+	defer command.Close()
+
+	n, err := command.Stdin.Write([]byte("abcdefghij"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 10)
+	c.Assert(sent(), DeepEquals, []string{"abc", "def", "ghi", "j"})
+
+	// later writes keep to the smaller chunks
+	n, err = command.Stdin.Write([]byte("klmnop"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 6)
+	c.Assert(sent(), DeepEquals, []string{"abc", "def", "ghi", "j", "klm", "nop"})
+}
+
+func (s *WinRMSuite) TestStdinBufferSize(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	client.Parameters.StdinBufferSize = 4
+	command, sent := recordStdin(c, client, 0)
+	defer command.Close()
+
+	for _, data := range []string{"ab", "cde"} {
+		_, err := command.Stdin.Write([]byte(data))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(sent(), DeepEquals, []string{"abcde"})
+
+	_, err = command.Stdin.Write([]byte("f"))
+	c.Assert(err, IsNil)
+	c.Assert(sent(), DeepEquals, []string{"abcde"})
+	c.Assert(command.Stdin.Flush(), IsNil)
+	c.Assert(sent(), DeepEquals, []string{"abcde", "f"})
+
+	_, err = command.Stdin.Write([]byte("gh"))
+	c.Assert(err, IsNil)
+	c.Assert(command.Stdin.Close(), IsNil)
+	c.Assert(sent(), DeepEquals, []string{"abcde", "f", "gh<EOF>"})
+}
+
+// failStdin makes the Send requests of the client fail once failing is closed, counting them
+func failStdin(client *Client, failing chan struct{}) func() int {
+	var mutex sync.Mutex
+	var failed int
+	r := client.http.(*Requester)
+	post := r.http
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		select {
+		case <-failing:
+			if strings.Contains(message.String(), "shell/Send<") {
+				mutex.Lock()
+				failed++
+				mutex.Unlock()
+				return "", errors.New("connection reset by peer")
+			}
+		default:
+		}
+		return post(client, message)
+	}
+	return func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return failed
+	}
+}
+
+func (s *WinRMSuite) TestStdinBufferSizeFailure(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	client.Parameters.StdinBufferSize = 4
+	sent := recordStdinRequests(c, client, 0)
+	failing := make(chan struct{})
+	failed := failStdin(client, failing)
+	command := executeMore(c, client)
+	defer command.Close()
+
+	n, err := command.Stdin.Write([]byte("ab"))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 2)
+
+	// none of the bytes of this write were sent, and the buffer is dropped
+	close(failing)
+	n, err = command.Stdin.Write([]byte("cde"))
+	c.Assert(err, ErrorMatches, ".*connection reset by peer")
+	c.Assert(n, Equals, 0)
+	c.Assert(failed(), Equals, 1)
+
+	// the failure is sticky
+	n, err = command.Stdin.Write([]byte("f"))
+	c.Assert(err, ErrorMatches, ".*connection reset by peer")
+	c.Assert(n, Equals, 0)
+	c.Assert(command.Stdin.Flush(), ErrorMatches, ".*connection reset by peer")
+	c.Assert(command.Stdin.Close(), ErrorMatches, ".*connection reset by peer")
+	c.Assert(failed(), Equals, 1)
+	c.Assert(sent(), HasLen, 0)
+}
+
+func (s *WinRMSuite) TestStdinFlushIntervalFailure(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	client.Parameters.StdinFlushInterval = 20 * time.Millisecond
+	sent := recordStdinRequests(c, client, 0)
+	failing := make(chan struct{})
+	close(failing)
+	failed := failStdin(client, failing)
+	command := executeMore(c, client)
+	defer command.Close()
+
+	_, err = command.Stdin.Write([]byte("abc"))
+	c.Assert(err, IsNil)
+	deadline := time.Now().Add(5 * time.Second)
+	for failed() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(failed(), Equals, 1)
+
+	// the failure of the timed flush is returned by every call which follows
+	_, err = command.Stdin.Write([]byte("def"))
+	c.Assert(err, ErrorMatches, ".*connection reset by peer")
+	c.Assert(command.Stdin.Flush(), ErrorMatches, ".*connection reset by peer")
+	c.Assert(command.Stdin.Close(), ErrorMatches, ".*connection reset by peer")
+	c.Assert(failed(), Equals, 1)
+	c.Assert(sent(), HasLen, 0)
+}
+
+func (s *WinRMSuite) TestStdinFlushInterval(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	client.Parameters.StdinFlushInterval = 50 * time.Millisecond
+	command, sent := recordStdin(c, client, 0)
+	defer command.Close()
+
+	for _, data := range []string{"a", "b", "c"} {
+		_, err := command.Stdin.Write([]byte(data))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(sent(), HasLen, 0)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(sent(), DeepEquals, []string{"abc"})
+
+	c.Assert(command.Stdin.Close(), IsNil)
+	c.Assert(sent(), DeepEquals, []string{"abc", "<EOF>"})
+}
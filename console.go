@@ -0,0 +1,184 @@
+package winrm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+)
+
+// DefaultConsoleCommand is the command run by a Console unless another one is given
+const DefaultConsoleCommand = "cmd.exe"
+
+// consoleInterrupt is the Ctrl+C character read from terminals in raw mode
+const consoleInterrupt = "\x03"
+
+// ConsoleOptions configures a Console, the zero value running DefaultConsoleCommand
+type ConsoleOptions struct {
+	// Command is the interactive program run in the console, such as diskpart.exe
+	Command   string
+	Arguments []string
+	// Echo writes the input lines to the output of the console, for when the local input
+	// is not a terminal echoing them itself
+	Echo bool
+}
+
+// Console drives an interactive program, such as the cmd.exe prompt, keeping one command open
+// in a shell and forwarding it input lines:
+//
+//	console, err := shell.NewConsole(ctx, nil)
+//	if err != nil {
+//		return err
+//	}
+//	exitCode, err := console.RunTerminal()
+type Console struct {
+	ctx     context.Context
+	command *Command
+	echo    bool
+}
+
+// NewConsole starts the interactive program of options, which may be nil, in this shell.
+// Canceling the context terminates the program.
+func (s *Shell) NewConsole(ctx context.Context, options *ConsoleOptions) (*Console, error) {
+	var o ConsoleOptions
+	if options != nil {
+		o = *options
+	}
+	if o.Command == "" {
+		o.Command = DefaultConsoleCommand
+	}
+
+	command, err := s.ExecuteWithContext(ctx, o.Command, o.Arguments...)
+	if err != nil {
+		return nil, err
+	}
+	return &Console{ctx: ctx, command: command, echo: o.Echo}, nil
+}
+
+// Command returns the command running the interactive program
+func (c *Console) Command() *Command {
+	return c.command
+}
+
+// SendLine sends line to the program, terminated by the CRLF the Windows console expects
+func (c *Console) SendLine(line string) error {
+	_, err := c.command.Stdin.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// Interrupt sends a Ctrl+C to the program
+func (c *Console) Interrupt() error {
+	return c.command.Signal(c.ctx, SignalCtrlC)
+}
+
+// Run forwards the lines read from stdin to the program and copies its output to stdout and
+// stderr until it exits, returning its exit code. A Ctrl+C character read from stdin, as sent
+// by a terminal in raw mode, is sent as a signal. The end of stdin is forwarded to the program.
+// Reading stdin may go on until its next line once the program exited. The output streams
+// other than stdout and stderr, as selected with Shell.SetOutputStreams, are discarded.
+func (c *Console) Run(stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	output := &lockedWriter{writer: stdout}
+	c.command.discardOtherStreams()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(output, c.command.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(stderr, c.command.Stderr)
+	}()
+	go c.forward(stdin, output)
+
+	c.command.Wait()
+	wg.Wait()
+	return c.command.ExitCode(), c.command.err
+}
+
+// forward sends the lines of stdin to the program until the end of stdin or of the program
+func (c *Console) forward(stdin io.Reader, output io.Writer) {
+	reader := bufio.NewReader(stdin)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			if sendErr := c.forwardLine(line, output); sendErr != nil {
+				return
+			}
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				_ = c.command.Stdin.Close()
+			}
+			return
+		}
+	}
+}
+
+func (c *Console) forwardLine(line string, output io.Writer) error {
+	parts := strings.Split(line, consoleInterrupt)
+	for i, part := range parts {
+		if i > 0 {
+			if err := c.Interrupt(); err != nil {
+				return err
+			}
+		}
+		if part == "" {
+			continue
+		}
+		if c.echo {
+			_, _ = io.WriteString(output, part)
+		}
+		var err error
+		if text := strings.TrimRight(part, "\r\n"); text != part {
+			err = c.SendLine(text)
+		} else {
+			_, err = c.command.Stdin.Write([]byte(part))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunTerminal runs the console on the standard input and outputs of the process, the
+// interrupt signal of the process, such as Ctrl+C in a terminal, being sent to the program
+// instead of interrupting the process
+func (c *Console) RunTerminal() (int, error) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-interrupts:
+				_ = c.Interrupt()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return c.Run(os.Stdin, os.Stdout, os.Stderr)
+}
+
+// lockedWriter serializes the writes of the output of the program and of the echoed input
+type lockedWriter struct {
+	mutex  sync.Mutex
+	writer io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.writer.Write(p)
+}
@@ -0,0 +1,128 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterzen/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+// runConsoleFakeRequester answers like an interactive program printing a prompt, which exits
+// once it reads exit, recording the input and the signals it received
+func runConsoleFakeRequester(c *C, client *Client) func() (string, []string) {
+	var mutex sync.Mutex
+	var input bytes.Buffer
+	var signals []string
+	output := make(chan string, 10)
+	output <- "C:\\> "
+
+	stdin := regexp.MustCompile(`<rsp:Stream[^>]*Name="stdin"[^>]*>([^<]*)</rsp:Stream>`)
+	code := regexp.MustCompile(`<rsp:Code>([^<]*)</rsp:Code>`)
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "shell/Command<"):
+			c.Assert(body, Contains, "<rsp:Command><![CDATA[diskpart.exe]]></rsp:Command>")
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Send<"):
+			match := stdin.FindStringSubmatch(body)
+			if match == nil {
+				// the end of the input
+				c.Assert(body, Contains, "End=\"true\"")
+				return "", nil
+			}
+			data, err := base64.StdEncoding.DecodeString(match[1])
+			c.Assert(err, IsNil)
+			mutex.Lock()
+			input.Write(data)
+			mutex.Unlock()
+			if string(data) == "exit\r\n" {
+				close(output)
+			} else if strings.HasSuffix(string(data), "\r\n") {
+				output <- "C:\\> "
+			}
+			return "", nil
+		case strings.Contains(body, "shell/Signal<"):
+			mutex.Lock()
+			signals = append(signals, code.FindStringSubmatch(body)[1])
+			mutex.Unlock()
+			return "", nil
+		}
+		prompt, ok := <-output
+		if !ok {
+			return doneCommandExitCode0Response, nil
+		}
+		return strings.Replace(singleOutputResponse, "VGhhdCdzIGFsbCBmb2xrcyEhIQ==", base64.StdEncoding.EncodeToString([]byte(prompt)), 1), nil
+	}
+	client.http = &r
+
+	return func() (string, []string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return input.String(), signals
+	}
+}
+
+func (s *WinRMSuite) TestConsoleRun(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	received := runConsoleFakeRequester(c, client)
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	console, err := shell.NewConsole(context.Background(), &ConsoleOptions{Command: "diskpart.exe", Echo: true})
+	c.Assert(err, IsNil)
+
+	var stdout, stderr bytes.Buffer
+	exitCode, err := console.Run(strings.NewReader("list disk\n\x03\nexit\n"), &stdout, &stderr)
+	c.Assert(err, IsNil)
+	c.Check(exitCode, Equals, 0)
+
+	input, signals := received()
+	c.Check(input, Equals, "list disk\r\n\r\nexit\r\n")
+	c.Check(signals, DeepEquals, []string{SignalCtrlC})
+	c.Check(stdout.String(), Contains, "list disk\n")
+	c.Check(stdout.String(), Contains, "exit\n")
+	c.Check(strings.Count(stdout.String(), "C:\\> "), Equals, 3)
+}
+
+func (s *WinRMSuite) TestConsoleRunCustomStreams(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	runConsoleFakeRequester(c, client)
+	// the program also writes to a progress stream
+	r := client.http.(*Requester)
+	post := r.http
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		response, err := post(client, message)
+		return strings.Replace(response, "</rsp:ReceiveResponse>", "<rsp:Stream Name=\"progress\" CommandId=\"1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4\">cHJvZ3Jlc3M=</rsp:Stream></rsp:ReceiveResponse>", 1), err
+	}
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	shell.SetOutputStreams("stdout", "stderr", "progress")
+	console, err := shell.NewConsole(context.Background(), &ConsoleOptions{Command: "diskpart.exe"})
+	c.Assert(err, IsNil)
+
+	var stdout, stderr bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		exitCode, err := console.Run(strings.NewReader("list disk\nexit\n"), &stdout, &stderr)
+		c.Check(err, IsNil)
+		c.Check(exitCode, Equals, 0)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("the console is held back by the progress stream")
+	}
+	c.Check(strings.Count(stdout.String(), "C:\\> "), Equals, 2)
+}
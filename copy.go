@@ -0,0 +1,470 @@
+package winrm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/masterzen/winrm/clixml"
+)
+
+// copyLineOverhead is the room kept in an envelope for the SOAP headers of a Send request
+const copyLineOverhead = 1000
+
+const uploadScript = `$ErrorActionPreference = 'Stop'
+$path = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)
+$dir = [IO.Path]::GetDirectoryName($path)
+if ($dir) { [void][IO.Directory]::CreateDirectory($dir) }
+$sha = [Security.Cryptography.SHA256]::Create()
+$file = [IO.File]::Open($path, 'Create', 'Write')
+try {
+  while (($line = [Console]::In.ReadLine()) -ne $null) {
+    if ($line.Length -eq 0) { continue }
+    $bytes = [Convert]::FromBase64String($line)
+    $file.Write($bytes, 0, $bytes.Length)
+    [void]$sha.TransformBlock($bytes, 0, $bytes.Length, $null, 0)
+  }
+  [void]$sha.TransformFinalBlock([byte[]]@(), 0, 0)
+} finally { $file.Close() }
+[Console]::Out.WriteLine('sha256:' + [BitConverter]::ToString($sha.Hash).Replace('-', ''))
+`
+
+const downloadScript = `$ErrorActionPreference = 'Stop'
+$path = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)
+$sha = [Security.Cryptography.SHA256]::Create()
+$file = [IO.File]::OpenRead($path)
+$buffer = New-Object byte[] %d
+try {
+  [Console]::Out.WriteLine('size:' + $file.Length)
+  while (($n = $file.Read($buffer, 0, $buffer.Length)) -gt 0) {
+    [void]$sha.TransformBlock($buffer, 0, $n, $null, 0)
+    [Console]::Out.WriteLine([Convert]::ToBase64String($buffer, 0, $n))
+  }
+  [void]$sha.TransformFinalBlock($buffer, 0, 0)
+} finally { $file.Close() }
+[Console]::Out.WriteLine('sha256:' + [BitConverter]::ToString($sha.Hash).Replace('-', ''))
+`
+
+const mkdirScript = `$ErrorActionPreference = 'Stop'
+$path = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)
+[void][IO.Directory]::CreateDirectory($path)
+`
+
+const listScript = `$ErrorActionPreference = 'Stop'
+$path = $ExecutionContext.SessionState.Path.GetUnresolvedProviderPathFromPSPath(%s)
+$root = (Get-Item -LiteralPath $path -Force).FullName.TrimEnd('\')
+Get-ChildItem -LiteralPath $root -Recurse -Force | ForEach-Object {
+  $type = if ($_.PSIsContainer) { 'd' } else { 'f' }
+  $name = [Text.Encoding]::UTF8.GetBytes($_.FullName.Substring($root.Length + 1))
+  [Console]::Out.WriteLine($type + ':' + [Convert]::ToBase64String($name))
+}
+`
+
+// CopyProgress reports the advancement of a file copy
+type CopyProgress struct {
+	// RemotePath is the path of the file on the remote host
+	RemotePath string
+	// Copied is the number of bytes copied so far
+	Copied int64
+	// Total is the size of the file, or -1 when it isn't known in advance
+	Total int64
+}
+
+// ChecksumError is returned when the SHA-256 of a copied file differs on both ends
+type ChecksumError struct {
+	RemotePath string
+	Local      string
+	Remote     string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: local sha256 %s, remote sha256 %s", e.RemotePath, e.Local, e.Remote)
+}
+
+// FileCopier copies files and directory trees from and to the remote host.
+// All the copies go through a single Shell, opened by NewFileCopier, each file
+// being streamed base64 encoded through the stdin or stdout of a powershell.exe.
+// A FileCopier runs one copy at a time.
+type FileCopier struct {
+	shell *Shell
+	mutex sync.Mutex
+
+	// Progress, when set, is called after every chunk copied
+	Progress func(CopyProgress)
+}
+
+// NewFileCopier opens the Shell used by the copies, which must be released with Close
+func (c *Client) NewFileCopier(ctx context.Context) (*FileCopier, error) {
+	shell, err := c.CreateShellWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &FileCopier{shell: shell}, nil
+}
+
+// CopyTo copies the content of reader to the file remotePath on the remote host,
+// creating its parent directories when needed
+func (c *Client) CopyTo(ctx context.Context, reader io.Reader, remotePath string) error {
+	copier, err := c.NewFileCopier(ctx)
+	if err != nil {
+		return err
+	}
+	defer copier.Close()
+
+	return copier.CopyTo(ctx, reader, remotePath)
+}
+
+// CopyFrom copies the content of the file remotePath on the remote host to writer
+func (c *Client) CopyFrom(ctx context.Context, remotePath string, writer io.Writer) error {
+	copier, err := c.NewFileCopier(ctx)
+	if err != nil {
+		return err
+	}
+	defer copier.Close()
+
+	return copier.CopyFrom(ctx, remotePath, writer)
+}
+
+// Close releases the Shell of the FileCopier
+func (f *FileCopier) Close() error {
+	return f.shell.Close()
+}
+
+// CopyTo copies the content of reader to the file remotePath on the remote host,
+// creating its parent directories when needed. The SHA-256 of the file written
+// on the remote host is checked against the one of the data read.
+func (f *FileCopier) CopyTo(ctx context.Context, reader io.Reader, remotePath string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	total := readerSize(reader)
+	digest := sha256.New()
+	buf := make([]byte, copyChunkSize(f.shell.client.Parameters.EnvelopeSize))
+
+	var remoteSum string
+	input := func(stdin io.Writer) error {
+		var copied int64
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			n, err := io.ReadFull(reader, buf)
+			if n > 0 {
+				digest.Write(buf[:n])
+				if _, err := io.WriteString(stdin, base64.StdEncoding.EncodeToString(buf[:n])+"\r\n"); err != nil {
+					return err
+				}
+				copied += int64(n)
+				f.progress(remotePath, copied, total)
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	output := func(line string) error {
+		if sum, ok := strings.CutPrefix(line, "sha256:"); ok {
+			remoteSum = sum
+		}
+		return nil
+	}
+
+	if err := f.run(ctx, fmt.Sprintf(uploadScript, psString(remotePath)), input, output); err != nil {
+		return fmt.Errorf("copying to %s: %w", remotePath, err)
+	}
+	return verifyChecksum(remotePath, digest, remoteSum)
+}
+
+// CopyFrom copies the content of the file remotePath on the remote host to writer.
+// The SHA-256 of the data written is checked against the one of the remote file.
+func (f *FileCopier) CopyFrom(ctx context.Context, remotePath string, writer io.Writer) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	digest := sha256.New()
+	chunkSize := copyChunkSize(f.shell.client.Parameters.EnvelopeSize)
+
+	var remoteSum string
+	var copied int64
+	total := int64(-1)
+	output := func(line string) error {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "size:"):
+			size, err := strconv.ParseInt(strings.TrimPrefix(line, "size:"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid file size %q", line)
+			}
+			total = size
+		case strings.HasPrefix(line, "sha256:"):
+			remoteSum = strings.TrimPrefix(line, "sha256:")
+		default:
+			data, err := base64.StdEncoding.DecodeString(line)
+			if err != nil {
+				return fmt.Errorf("unexpected output %q", line)
+			}
+			if _, err := writer.Write(data); err != nil {
+				return err
+			}
+			digest.Write(data)
+			copied += int64(len(data))
+			f.progress(remotePath, copied, total)
+		}
+		return nil
+	}
+
+	if err := f.run(ctx, fmt.Sprintf(downloadScript, psString(remotePath), chunkSize), nil, output); err != nil {
+		return fmt.Errorf("copying from %s: %w", remotePath, err)
+	}
+	return verifyChecksum(remotePath, digest, remoteSum)
+}
+
+// CopyDirTo copies the directory tree localDir to remoteDir on the remote host.
+// Only directories and regular files are copied, symbolic links are skipped.
+func (f *FileCopier) CopyDirTo(ctx context.Context, localDir, remoteDir string) error {
+	return filepath.WalkDir(localDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return err
+		}
+		remotePath := remoteDir
+		if rel != "." {
+			remotePath = strings.TrimRight(remoteDir, `\/`) + `\` + strings.ReplaceAll(filepath.ToSlash(rel), "/", `\`)
+		}
+
+		if entry.IsDir() {
+			return f.mkdir(ctx, remotePath)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return f.CopyTo(ctx, file, remotePath)
+	})
+}
+
+// CopyDirFrom copies the directory tree remoteDir on the remote host to localDir
+func (f *FileCopier) CopyDirFrom(ctx context.Context, remoteDir, localDir string) error {
+	type entry struct {
+		dir  bool
+		name string
+	}
+
+	var entries []entry
+	output := func(line string) error {
+		if line == "" {
+			return nil
+		}
+		kind, encoded, ok := strings.Cut(line, ":")
+		name, err := base64.StdEncoding.DecodeString(encoded)
+		if !ok || err != nil || kind != "d" && kind != "f" {
+			return fmt.Errorf("unexpected output %q", line)
+		}
+		entries = append(entries, entry{dir: kind == "d", name: string(name)})
+		return nil
+	}
+
+	f.mutex.Lock()
+	err := f.run(ctx, fmt.Sprintf(listScript, psString(remoteDir)), nil, output)
+	f.mutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("listing %s: %w", remoteDir, err)
+	}
+
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		rel := filepath.FromSlash(strings.ReplaceAll(e.name, `\`, "/"))
+		if !filepath.IsLocal(rel) {
+			return fmt.Errorf("refusing to copy %s outside of %s", e.name, localDir)
+		}
+		localPath := filepath.Join(localDir, rel)
+
+		if e.dir {
+			if err := os.MkdirAll(localPath, 0o755); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := f.copyFileFrom(ctx, strings.TrimRight(remoteDir, `\/`)+`\`+e.name, localPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileCopier) copyFileFrom(ctx context.Context, remotePath, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+
+	err = f.CopyFrom(ctx, remotePath, file)
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func (f *FileCopier) mkdir(ctx context.Context, remotePath string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.run(ctx, fmt.Sprintf(mkdirScript, psString(remotePath)), nil, nil); err != nil {
+		return fmt.Errorf("creating %s: %w", remotePath, err)
+	}
+	return nil
+}
+
+func (f *FileCopier) progress(remotePath string, copied, total int64) {
+	if f.Progress != nil {
+		f.Progress(CopyProgress{RemotePath: remotePath, Copied: copied, Total: total})
+	}
+}
+
+// run executes the PowerShell script in the Shell of the FileCopier. The input function,
+// when not nil, writes the stdin of the script, and output is called for every line it prints.
+// An error is returned if the script fails, along with the message of its error record.
+func (f *FileCopier) run(ctx context.Context, script string, input func(io.Writer) error, output func(string) error) error {
+	cmd, err := f.shell.ExecuteWithContext(ctx, Powershell(script))
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(&stderr, cmd.Stderr)
+	}()
+
+	inputErr := make(chan error, 1)
+	go func() {
+		if input != nil {
+			if err := input(cmd.Stdin); err != nil {
+				inputErr <- err
+				_ = cmd.Close()
+				return
+			}
+		}
+		inputErr <- cmd.Stdin.Close()
+	}()
+
+	chunkSize := copyChunkSize(f.shell.client.Parameters.EnvelopeSize)
+	scanner := bufio.NewScanner(cmd.Stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*chunkSize+1024)
+
+	var outputErr error
+	for scanner.Scan() {
+		if output == nil {
+			continue
+		}
+		if outputErr = output(strings.TrimRight(scanner.Text(), "\r")); outputErr != nil {
+			_ = cmd.Close()
+			break
+		}
+	}
+	if outputErr == nil {
+		outputErr = scanner.Err()
+	}
+	// let the command terminate even if we stopped reading its output
+	_, _ = io.Copy(io.Discard, cmd.Stdout)
+
+	cmd.Wait()
+	wg.Wait()
+
+	// the script failing usually causes the input to fail, so report its own error first
+	inErr := <-inputErr
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if cmd.ExitCode() != 0 {
+		message := strings.TrimSpace(stderr.String())
+		if records, err := clixml.ParseErrorStream(stderr.Bytes()); err == nil && len(records) > 0 {
+			message = records[0].Message
+		}
+		return fmt.Errorf("remote script failed with exit code %d: %s", cmd.ExitCode(), message)
+	}
+	if inErr != nil {
+		return inErr
+	}
+	return outputErr
+}
+
+// copyChunkSize returns how many bytes of a file fit in a single Send request,
+// once base64 encoded twice: as a line for the script, then as stdin by WinRM
+func copyChunkSize(envelopeSize int) int {
+	line := (envelopeSize - copyLineOverhead) / 4 * 3
+	size := (line - 2) / 4 * 3
+	if size < 48 {
+		return 48
+	}
+	return size
+}
+
+// psString returns a PowerShell expression evaluating to s, encoded so
+// that no character of s can be interpreted by PowerShell
+func psString(s string) string {
+	return fmt.Sprintf("[Text.Encoding]::UTF8.GetString([Convert]::FromBase64String('%s'))", base64.StdEncoding.EncodeToString([]byte(s)))
+}
+
+func verifyChecksum(remotePath string, digest hash.Hash, remoteSum string) error {
+	local := hex.EncodeToString(digest.Sum(nil))
+	if !strings.EqualFold(local, remoteSum) {
+		return &ChecksumError{RemotePath: remotePath, Local: local, Remote: strings.ToLower(remoteSum)}
+	}
+	return nil
+}
+
+// readerSize returns the number of bytes left in reader, or -1 when it can't be known
+func readerSize(reader io.Reader) int64 {
+	switch r := reader.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case *os.File:
+		info, err := r.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			return -1
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		return info.Size() - offset
+	}
+	return -1
+}
@@ -0,0 +1,333 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+	"golang.org/x/text/encoding/unicode"
+	. "gopkg.in/check.v1"
+)
+
+var copyPathRegexp = regexp.MustCompile(`FromBase64String\('([^']*)'\)`)
+
+// copyFakeServer emulates the scripts run by a FileCopier against an in-memory file system
+type copyFakeServer struct {
+	mutex    sync.Mutex
+	files    map[string][]byte
+	dirs     map[string]bool
+	shells   int
+	commands int
+	corrupt  bool
+
+	script string
+	path   string
+	stdin  bytes.Buffer
+	output string
+	errors string
+	exit   int
+	ready  bool
+}
+
+func (f *copyFakeServer) receiveResponse() string {
+	var streams string
+	if f.output != "" {
+		streams += `<rsp:Stream Name="stdout" CommandId="1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4">` + base64.StdEncoding.EncodeToString([]byte(f.output)) + `</rsp:Stream>`
+	}
+	if f.errors != "" {
+		streams += `<rsp:Stream Name="stderr" CommandId="1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4">` + base64.StdEncoding.EncodeToString([]byte(f.errors)) + `</rsp:Stream>`
+	}
+	return `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><s:Header><a:Action>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse</a:Action></s:Header><s:Body><rsp:ReceiveResponse>` +
+		streams +
+		fmt.Sprintf(`<rsp:CommandState CommandId="1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4" State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"><rsp:ExitCode>%d</rsp:ExitCode></rsp:CommandState>`, f.exit) +
+		`</rsp:ReceiveResponse></s:Body></s:Envelope>`
+}
+
+func (f *copyFakeServer) fail(message string) {
+	f.errors = "#< CLIXML\r\n" + `<Objs Version="1.1.0.1" xmlns="http://schemas.microsoft.com/powershell/2004/04"><S S="Error">` + message + `_x000D__x000A_</S></Objs>`
+	f.exit = 1
+	f.ready = true
+}
+
+func (f *copyFakeServer) startCommand(c *C, body string) {
+	doc, err := xmltree.ParseXML(strings.NewReader(body))
+	c.Assert(err, IsNil)
+	command, err := first(doc, "//rsp:Command")
+	c.Assert(err, IsNil)
+
+	encoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(command, "powershell.exe -EncodedCommand "))
+	c.Assert(err, IsNil)
+	script, err := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().Bytes(encoded)
+	c.Assert(err, IsNil)
+
+	matches := copyPathRegexp.FindStringSubmatch(string(script))
+	c.Assert(matches, NotNil)
+	path, err := base64.StdEncoding.DecodeString(matches[1])
+	c.Assert(err, IsNil)
+
+	f.commands++
+	f.script = string(script)
+	f.path = string(path)
+	f.stdin.Reset()
+	f.output, f.errors, f.exit, f.ready = "", "", 0, false
+
+	switch {
+	case strings.Contains(f.script, "OpenRead"):
+		data, ok := f.files[f.path]
+		if !ok {
+			f.fail("Could not find file '" + f.path + "'.")
+			return
+		}
+		f.output = fmt.Sprintf("size:%d\r\n", len(data))
+		chunkSize := 0
+		fmt.Sscanf(f.script[strings.Index(f.script, "New-Object byte[] "):], "New-Object byte[] %d", &chunkSize)
+		for len(data) > 0 {
+			n := min(chunkSize, len(data))
+			f.output += base64.StdEncoding.EncodeToString(data[:n]) + "\r\n"
+			data = data[n:]
+		}
+		f.output += "sha256:" + f.checksum(f.files[f.path]) + "\r\n"
+		f.ready = true
+	case strings.Contains(f.script, "Get-ChildItem"):
+		var names []string
+		prefix := f.path + `\`
+		for name := range f.dirs {
+			if strings.HasPrefix(name, prefix) && name != prefix {
+				names = append(names, "d:"+base64.StdEncoding.EncodeToString([]byte(strings.TrimPrefix(name, prefix))))
+			}
+		}
+		for name := range f.files {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, "f:"+base64.StdEncoding.EncodeToString([]byte(strings.TrimPrefix(name, prefix))))
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			f.output += name + "\r\n"
+		}
+		f.ready = true
+	case strings.Contains(f.script, "ReadLine"):
+		// the upload completes once stdin is closed
+	default:
+		f.dirs[f.path] = true
+		f.ready = true
+	}
+}
+
+func (f *copyFakeServer) endUpload() {
+	var data []byte
+	for _, line := range strings.Split(f.stdin.String(), "\r\n") {
+		chunk, _ := base64.StdEncoding.DecodeString(line)
+		data = append(data, chunk...)
+	}
+	f.files[f.path] = data
+	if f.corrupt {
+		data = append(data, '!')
+	}
+	f.output = "sha256:" + f.checksum(data) + "\r\n"
+	f.ready = true
+}
+
+func (f *copyFakeServer) checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func runCopyFakeServer(c *C, fake *copyFakeServer) (*httptest.Server, *Client) {
+	if fake.files == nil {
+		fake.files = make(map[string][]byte)
+	}
+	fake.dirs = make(map[string]bool)
+
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fake.mutex.Lock()
+		defer fake.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/soap+xml")
+		b, err := io.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		body := string(b)
+
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			fake.shells++
+			fmt.Fprintln(w, createShellResponse)
+		case strings.Contains(body, "shell/Command"):
+			fake.startCommand(c, body)
+			fmt.Fprintln(w, executeCommandResponse)
+		case strings.Contains(body, "shell/Send"):
+			doc, err := xmltree.ParseXML(strings.NewReader(body))
+			c.Assert(err, IsNil)
+			stdin, err := first(doc, "//rsp:Stream[@Name='stdin']")
+			c.Assert(err, IsNil)
+			content, _ := base64.StdEncoding.DecodeString(stdin)
+			fake.stdin.Write(content)
+			if end, _ := any(doc, "//rsp:Stream[@End='true']"); end && strings.Contains(fake.script, "ReadLine") {
+				fake.endUpload()
+			}
+		case strings.Contains(body, "shell/Receive"):
+			if !fake.ready {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintln(w, operationTimeoutResponse)
+				return
+			}
+			fmt.Fprintln(w, fake.receiveResponse())
+		default:
+			fmt.Fprintln(w, doneCommandExitCode0Response)
+		}
+	}))
+	c.Assert(err, IsNil)
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	client.Parameters.EnvelopeSize = 2000
+
+	return ts, client
+}
+
+func (s *WinRMSuite) TestCopyChunkSize(c *C) {
+	c.Assert(copyChunkSize(2000), Equals, 561)
+	c.Assert(copyChunkSize(153600), Equals, 85836)
+	c.Assert(copyChunkSize(1000), Equals, 48)
+
+	// a chunk fits in a single Send request
+	for _, envelopeSize := range []int{2000, 8192, 153600, 512000} {
+		line := base64.StdEncoding.EncodedLen(copyChunkSize(envelopeSize)) + 2
+		c.Assert(base64.StdEncoding.EncodedLen(line) <= envelopeSize-copyLineOverhead, Equals, true)
+	}
+}
+
+func (s *WinRMSuite) TestCopyToAndFrom(c *C) {
+	fake := &copyFakeServer{}
+	ts, client := runCopyFakeServer(c, fake)
+	defer ts.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 300)
+	copier, err := client.NewFileCopier(context.Background())
+	c.Assert(err, IsNil)
+
+	var progress []CopyProgress
+	copier.Progress = func(p CopyProgress) { progress = append(progress, p) }
+
+	c.Assert(copier.CopyTo(context.Background(), bytes.NewReader(data), `C:\Temp\it's here.bin`), IsNil)
+	c.Assert(fake.files[`C:\Temp\it's here.bin`], DeepEquals, data)
+	c.Assert(progress, HasLen, 9)
+	c.Assert(progress[0], Equals, CopyProgress{RemotePath: `C:\Temp\it's here.bin`, Copied: 561, Total: 4800})
+	c.Assert(progress[8].Copied, Equals, int64(4800))
+
+	progress = nil
+	var downloaded bytes.Buffer
+	c.Assert(copier.CopyFrom(context.Background(), `C:\Temp\it's here.bin`, &downloaded), IsNil)
+	c.Assert(downloaded.Bytes(), DeepEquals, data)
+	c.Assert(progress, HasLen, 9)
+	c.Assert(progress[8], Equals, CopyProgress{RemotePath: `C:\Temp\it's here.bin`, Copied: 4800, Total: 4800})
+
+	c.Assert(copier.Close(), IsNil)
+	c.Assert(fake.shells, Equals, 1)
+	c.Assert(fake.commands, Equals, 2)
+}
+
+func (s *WinRMSuite) TestClientCopyTo(c *C) {
+	fake := &copyFakeServer{}
+	ts, client := runCopyFakeServer(c, fake)
+	defer ts.Close()
+
+	// the size of a pipe isn't known in advance
+	reader, writer := io.Pipe()
+	go func() {
+		_, _ = writer.Write([]byte("hello"))
+		writer.Close()
+	}()
+
+	c.Assert(client.CopyTo(context.Background(), reader, `C:\hello.txt`), IsNil)
+	c.Assert(string(fake.files[`C:\hello.txt`]), Equals, "hello")
+	c.Assert(readerSize(reader), Equals, int64(-1))
+}
+
+func (s *WinRMSuite) TestCopyToChecksumMismatch(c *C) {
+	fake := &copyFakeServer{corrupt: true}
+	ts, client := runCopyFakeServer(c, fake)
+	defer ts.Close()
+
+	err := client.CopyTo(context.Background(), strings.NewReader("hello"), `C:\hello.txt`)
+	var checksumErr *ChecksumError
+	c.Assert(errors.As(err, &checksumErr), Equals, true)
+	c.Assert(checksumErr.RemotePath, Equals, `C:\hello.txt`)
+	c.Assert(checksumErr.Local, Equals, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+}
+
+func (s *WinRMSuite) TestClientCopyFromMissingFile(c *C) {
+	fake := &copyFakeServer{}
+	ts, client := runCopyFakeServer(c, fake)
+	defer ts.Close()
+
+	var downloaded bytes.Buffer
+	err := client.CopyFrom(context.Background(), `C:\missing.txt`, &downloaded)
+	c.Assert(err, ErrorMatches, `copying from C:\\missing.txt: remote script failed with exit code 1: Could not find file 'C:\\missing.txt'.`)
+}
+
+func (s *WinRMSuite) TestCopyDirectoryTree(c *C) {
+	fake := &copyFakeServer{}
+	ts, client := runCopyFakeServer(c, fake)
+	defer ts.Close()
+
+	local := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(local, "sub", "empty"), 0o755), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(local, "root.txt"), []byte("root"), 0o644), IsNil)
+	c.Assert(os.WriteFile(filepath.Join(local, "sub", "nested.txt"), []byte("nested"), 0o644), IsNil)
+
+	copier, err := client.NewFileCopier(context.Background())
+	c.Assert(err, IsNil)
+	defer copier.Close()
+
+	c.Assert(copier.CopyDirTo(context.Background(), local, `C:\app\`), IsNil)
+	c.Assert(fake.files, DeepEquals, map[string][]byte{
+		`C:\app\root.txt`:       []byte("root"),
+		`C:\app\sub\nested.txt`: []byte("nested"),
+	})
+	c.Assert(fake.dirs, DeepEquals, map[string]bool{`C:\app\`: true, `C:\app\sub`: true, `C:\app\sub\empty`: true})
+
+	back := filepath.Join(c.MkDir(), "back")
+	c.Assert(copier.CopyDirFrom(context.Background(), `C:\app`, back), IsNil)
+
+	content, err := os.ReadFile(filepath.Join(back, "sub", "nested.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "nested")
+	content, err = os.ReadFile(filepath.Join(back, "root.txt"))
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "root")
+	info, err := os.Stat(filepath.Join(back, "sub", "empty"))
+	c.Assert(err, IsNil)
+	c.Assert(info.IsDir(), Equals, true)
+
+	c.Assert(fake.shells, Equals, 1)
+}
+
+func (s *WinRMSuite) TestCopyDirFromRefusesEscapingPaths(c *C) {
+	fake := &copyFakeServer{}
+	ts, client := runCopyFakeServer(c, fake)
+	defer ts.Close()
+	fake.files[`C:\app\..\evil.txt`] = []byte("evil")
+
+	copier, err := client.NewFileCopier(context.Background())
+	c.Assert(err, IsNil)
+	defer copier.Close()
+
+	err = copier.CopyDirFrom(context.Background(), `C:\app`, c.MkDir())
+	c.Assert(err, ErrorMatches, `refusing to copy \.\.\\evil.txt outside of .*`)
+}
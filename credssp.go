@@ -0,0 +1,466 @@
+package winrm
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bodgit/ntlmssp"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"golang.org/x/text/encoding/unicode"
+)
+
+/*
+CredSSP (MS-CSSP) authenticates the user with NTLM or Kerberos inside a TLS session with the
+server, whose records are exchanged in the CredSSP authorization headers, then delegates the
+credentials of the user to the server in that session, so that the commands can authenticate
+to other hosts, such as to access file shares.
+Once authenticated, the TLS session encrypts the messages.
+
+based on the python code from https://github.com/jborean93/requests-credssp
+*/
+
+const (
+	credsspVersion = 6
+	// credsspPasswordCreds is the credType of TSCredentials holding TSPasswordCreds
+	credsspPasswordCreds = 1
+	// tlsRecordHeaderLength is the length of the header of a TLS record
+	tlsRecordHeaderLength = 5
+	// tlsMaxPlaintext is the length of the largest message a TLS record encrypts
+	tlsMaxPlaintext = 16384
+)
+
+var (
+	credsspClientBinding = []byte("CredSSP Client-To-Server Binding Hash\x00")
+	credsspServerBinding = []byte("CredSSP Server-To-Client Binding Hash\x00")
+)
+
+// tsRequest is the TSRequest message of CredSSP
+type tsRequest struct {
+	Version     int         `asn1:"explicit,tag:0"`
+	NegoTokens  []negoToken `asn1:"explicit,optional,tag:1"`
+	AuthInfo    []byte      `asn1:"explicit,optional,tag:2"`
+	PubKeyAuth  []byte      `asn1:"explicit,optional,tag:3"`
+	ErrorCode   int32       `asn1:"explicit,optional,tag:4"`
+	ClientNonce []byte      `asn1:"explicit,optional,tag:5"`
+}
+
+type negoToken struct {
+	Token []byte `asn1:"explicit,tag:0"`
+}
+
+type tsCredentials struct {
+	CredType    int    `asn1:"explicit,tag:0"`
+	Credentials []byte `asn1:"explicit,tag:1"`
+}
+
+type tsPasswordCreds struct {
+	DomainName []byte `asn1:"explicit,tag:0"`
+	UserName   []byte `asn1:"explicit,tag:1"`
+	Password   []byte `asn1:"explicit,tag:2"`
+}
+
+// credsspAuthenticator is the security package authenticating the user inside CredSSP
+type credsspAuthenticator interface {
+	// step returns the token answering token, the token of the server or nil to start,
+	// and whether the security context is established
+	step(token []byte) ([]byte, bool, error)
+	wrap(message []byte) ([]byte, error)
+	unwrap(token []byte) ([]byte, error)
+}
+
+// credsspNTLM authenticates with NTLM inside CredSSP
+type credsspNTLM struct {
+	client *ntlmssp.Client
+}
+
+func (a *credsspNTLM) step(token []byte) ([]byte, bool, error) {
+	b, err := a.client.Authenticate(token, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	return b, a.client.Complete(), nil
+}
+
+func (a *credsspNTLM) wrap(message []byte) ([]byte, error) {
+	session := a.client.SecuritySession()
+	if session == nil {
+		return nil, errors.New("NTLM session doesn't seal messages")
+	}
+	sealedMessage, signature, err := session.Wrap(message)
+	if err != nil {
+		return nil, err
+	}
+	return append(signature, sealedMessage...), nil
+}
+
+func (a *credsspNTLM) unwrap(token []byte) ([]byte, error) {
+	session := a.client.SecuritySession()
+	if session == nil {
+		return nil, errors.New("NTLM session doesn't seal messages")
+	}
+	if len(token) < 16 {
+		return nil, errors.New("NTLM sealed message too short")
+	}
+	return session.Unwrap(token[16:], token[:16])
+}
+
+// credsspKerberos authenticates with Kerberos inside CredSSP
+type credsspKerberos struct {
+	client  *client.Client
+	spn     string
+	context *kerberosContext
+}
+
+func (a *credsspKerberos) step(token []byte) ([]byte, bool, error) {
+	if a.context == nil {
+		context, b, err := newKerberosInitiator(a.client, a.spn, nil)
+		if err != nil {
+			return nil, false, err
+		}
+		a.context = context
+		return b, false, nil
+	}
+	if err := a.context.complete(token); err != nil {
+		return nil, false, err
+	}
+	return nil, true, nil
+}
+
+func (a *credsspKerberos) wrap(message []byte) ([]byte, error) {
+	header, data, err := a.context.wrap(message)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, data...), nil
+}
+
+func (a *credsspKerberos) unwrap(token []byte) ([]byte, error) {
+	return a.context.unwrap(token, nil)
+}
+
+// credsspConn is the connection of the TLS session of CredSSP, keeping the records written
+// until they are exchanged for the records of the server
+type credsspConn struct {
+	in, out bytes.Buffer
+	// exchange sends the records written to the server, returning the records it sent back,
+	// nil once the session is established, the records being carried by the messages then
+	exchange func(out []byte) ([]byte, error)
+}
+
+func (c *credsspConn) Read(b []byte) (int, error) {
+	if c.in.Len() == 0 {
+		if c.exchange == nil {
+			return 0, errors.New("no TLS record to read")
+		}
+		if err := c.flush(); err != nil {
+			return 0, err
+		}
+		if c.in.Len() == 0 {
+			return 0, errors.New("no CredSSP token in the response")
+		}
+	}
+	return c.in.Read(b)
+}
+
+// flush exchanges the records written
+func (c *credsspConn) flush() error {
+	out := append([]byte(nil), c.out.Bytes()...)
+	c.out.Reset()
+	in, err := c.exchange(out)
+	if err != nil {
+		return err
+	}
+	c.in.Write(in)
+	return nil
+}
+
+func (c *credsspConn) Write(b []byte) (int, error)        { return c.out.Write(b) }
+func (c *credsspConn) Close() error                       { return nil }
+func (c *credsspConn) LocalAddr() net.Addr                { return nil }
+func (c *credsspConn) RemoteAddr() net.Addr               { return nil }
+func (c *credsspConn) SetDeadline(t time.Time) error      { return nil }
+func (c *credsspConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *credsspConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// credsspContext is the TLS session of CredSSP, encrypting the messages once the user
+// authenticated
+type credsspContext struct {
+	mutex sync.Mutex
+	conn  *credsspConn
+	tls   *tls.Conn
+}
+
+// newCredSSPContext authenticates the user with auth inside a TLS session whose records are
+// exchanged with exchange, delegating credentials, the TSCredentials of the user, to the server
+func newCredSSPContext(exchange func([]byte) ([]byte, error), auth credsspAuthenticator, credentials []byte) (*credsspContext, error) {
+	conn := &credsspConn{exchange: exchange}
+	//nolint:gosec // the server is authenticated by binding its public key to the authentication
+	tlsConn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify:          true,
+		MinVersion:                  tls.VersionTLS12,
+		MaxVersion:                  tls.VersionTLS12,
+		DynamicRecordSizingDisabled: true,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("credssp TLS handshake: %w", err)
+	}
+	publicKey, err := subjectPublicKey(tlsConn.ConnectionState().PeerCertificates[0])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	token, complete, err := auth.step(nil)
+	if err != nil {
+		return nil, err
+	}
+	request := tsRequest{Version: credsspVersion, NegoTokens: []negoToken{{Token: token}}}
+	var response tsRequest
+	for {
+		if complete {
+			version := min(credsspVersion, response.Version)
+			if version >= 5 {
+				request.ClientNonce = nonce
+			}
+			if request.PubKeyAuth, err = auth.wrap(credsspPublicKeyBinding(version, credsspClientBinding, nonce, publicKey)); err != nil {
+				return nil, err
+			}
+		}
+		if err := writeTSRequest(tlsConn, request); err != nil {
+			return nil, err
+		}
+		if response, err = readTSRequest(tlsConn); err != nil {
+			return nil, err
+		}
+		if complete {
+			break
+		}
+
+		var serverToken []byte
+		if len(response.NegoTokens) > 0 {
+			serverToken = response.NegoTokens[0].Token
+		}
+		if token, complete, err = auth.step(serverToken); err != nil {
+			return nil, err
+		}
+		request = tsRequest{Version: credsspVersion}
+		if token != nil {
+			request.NegoTokens = []negoToken{{Token: token}}
+		}
+	}
+
+	// the server proves it is the one of the TLS session
+	serverPublicKey, err := auth.unwrap(response.PubKeyAuth)
+	if err != nil {
+		return nil, fmt.Errorf("credssp server authentication: %w", err)
+	}
+	version := min(credsspVersion, response.Version)
+	if !bytes.Equal(serverPublicKey, credsspPublicKeyBinding(version, credsspServerBinding, nonce, publicKey)) {
+		return nil, errors.New("credssp server authentication failed: public key doesn't match the TLS session")
+	}
+
+	authInfo, err := auth.wrap(credentials)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTSRequest(tlsConn, tsRequest{Version: credsspVersion, AuthInfo: authInfo}); err != nil {
+		return nil, err
+	}
+	if err := conn.flush(); err != nil {
+		return nil, err
+	}
+	conn.exchange = nil
+
+	return &credsspContext{conn: conn, tls: tlsConn}, nil
+}
+
+// credsspPublicKeyBinding returns what pubKeyAuth seals for the public key of the server,
+// with the binding constant of the sender
+func credsspPublicKeyBinding(version int, binding, nonce, publicKey []byte) []byte {
+	if version >= 5 {
+		hash := sha256.New()
+		hash.Write(binding)
+		hash.Write(nonce)
+		hash.Write(publicKey)
+		return hash.Sum(nil)
+	}
+	if bytes.Equal(binding, credsspServerBinding) {
+		// the server sends the public key incremented by 1
+		key := append([]byte(nil), publicKey...)
+		key[0]++
+		return key
+	}
+	return publicKey
+}
+
+// subjectPublicKey returns the subjectPublicKey of the SubjectPublicKeyInfo of cert
+func subjectPublicKey(cert *x509.Certificate) ([]byte, error) {
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &info); err != nil {
+		return nil, fmt.Errorf("credssp server certificate: %w", err)
+	}
+	return info.PublicKey.Bytes, nil
+}
+
+// credsspCredentials returns the TSCredentials delegating the password of the user
+func credsspCredentials(domain, username, password string) ([]byte, error) {
+	encoder := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()
+	var creds tsPasswordCreds
+	var err error
+	if creds.DomainName, err = encoder.Bytes([]byte(domain)); err != nil {
+		return nil, err
+	}
+	if creds.UserName, err = encoder.Bytes([]byte(username)); err != nil {
+		return nil, err
+	}
+	if creds.Password, err = encoder.Bytes([]byte(password)); err != nil {
+		return nil, err
+	}
+	b, err := asn1.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(tsCredentials{CredType: credsspPasswordCreds, Credentials: b})
+}
+
+func writeTSRequest(w io.Writer, request tsRequest) error {
+	b, err := asn1.Marshal(request)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func readTSRequest(r io.Reader) (tsRequest, error) {
+	var request tsRequest
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return request, fmt.Errorf("reading credssp TSRequest: %w", err)
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lengthBytes := make([]byte, length&0x7f)
+		if len(lengthBytes) > 4 {
+			return request, errors.New("invalid credssp TSRequest length")
+		}
+		if _, err := io.ReadFull(r, lengthBytes); err != nil {
+			return request, fmt.Errorf("reading credssp TSRequest: %w", err)
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+		header = append(header, lengthBytes...)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return request, fmt.Errorf("reading credssp TSRequest: %w", err)
+	}
+	if _, err := asn1.Unmarshal(append(header, b...), &request); err != nil {
+		return request, fmt.Errorf("invalid credssp TSRequest: %w", err)
+	}
+	if request.ErrorCode != 0 {
+		return request, fmt.Errorf("credssp authentication failed with status 0x%08x", uint32(request.ErrorCode))
+	}
+	return request, nil
+}
+
+// wrap encrypts message in the TLS records of the session, returning them with the length
+// of the trailer of the records
+func (c *credsspContext) wrap(message []byte) ([]byte, int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, err := c.tls.Write(message); err != nil {
+		return nil, 0, err
+	}
+	records := append([]byte(nil), c.conn.out.Bytes()...)
+	c.conn.out.Reset()
+
+	cipherSuite := tls.CipherSuiteName(c.tls.ConnectionState().CipherSuite)
+	return records, getCredSSPTrailerLength(len(message), cipherSuite), nil
+}
+
+// unwrap decrypts the message of records, the TLS records sent by the server
+func (c *credsspContext) unwrap(records []byte) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var message []byte
+	b := make([]byte, tlsMaxPlaintext)
+	for len(records) > 0 {
+		if len(records) < tlsRecordHeaderLength {
+			return nil, errors.New("credssp encrypted message too short")
+		}
+		length := tlsRecordHeaderLength + int(binary.BigEndian.Uint16(records[3:5]))
+		if length > len(records) {
+			return nil, errors.New("credssp encrypted message too short")
+		}
+		c.conn.in.Write(records[:length])
+		records = records[length:]
+
+		for c.conn.in.Len() > 0 {
+			n, err := c.tls.Read(b)
+			if err != nil {
+				c.conn.in.Reset()
+				return nil, fmt.Errorf("decrypting credssp message: %w", err)
+			}
+			message = append(message, b[:n]...)
+		}
+	}
+	return message, nil
+}
+
+// getCredSSPTrailerLength returns the length of the trailer of the TLS records encrypting
+// a message of messageLength with cipherSuite, as named by crypto/tls
+func getCredSSPTrailerLength(messageLength int, cipherSuite string) int {
+	if strings.Contains(cipherSuite, "_GCM_") || strings.Contains(cipherSuite, "CHACHA20_POLY1305") {
+		return 16
+	}
+
+	var hashLength int
+	switch cipherSuite[strings.LastIndex(cipherSuite, "_")+1:] {
+	case "MD5":
+		hashLength = 16
+	case "SHA":
+		hashLength = 20
+	case "SHA256":
+		hashLength = 32
+	case "SHA384":
+		hashLength = 48
+	}
+
+	prePadLength := messageLength + hashLength
+	paddingLength := 0
+	switch {
+	case strings.Contains(cipherSuite, "RC4"):
+		paddingLength = 0
+	case strings.Contains(cipherSuite, "3DES"):
+		paddingLength = 8 - (prePadLength % 8)
+	default:
+		// AES is a 128 bit block cipher
+		paddingLength = 16 - (prePadLength % 16)
+	}
+
+	return (prePadLength + paddingLength) - messageLength
+}
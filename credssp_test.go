@@ -0,0 +1,608 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/md4"
+	"golang.org/x/text/encoding/unicode"
+	. "gopkg.in/check.v1"
+)
+
+// credsspAcceptor is the security package of the server authenticating the user inside CredSSP
+type credsspAcceptor interface {
+	// accept returns the token answering token, nil once the user is authenticated
+	accept(token []byte) ([]byte, error)
+	wrap(message []byte) ([]byte, error)
+	unwrap(token []byte) ([]byte, error)
+}
+
+// credsspEndpoint stands in for a WinRM service only accepting CredSSP encrypted messages,
+// authenticating testUser with NTLM, or with Kerberos when kdc is set, and answering the
+// messages with the response of the first action found in the decrypted request
+type credsspEndpoint struct {
+	kdc       *testKDC
+	version   int
+	cert      tls.Certificate
+	responses map[string]string
+
+	mutex sync.Mutex
+	// handshakes are the authentications in progress, contexts the sessions established, by
+	// remote address
+	handshakes  map[string]*credsspHandshake
+	contexts    map[string]*credsspContext
+	credentials []tsPasswordCreds
+	requests    []string
+	// rawRequests are the bodies received on the wire, contentTypes their content types
+	rawRequests  []string
+	contentTypes []string
+}
+
+// credsspHandshake carries the tokens of the requests to the goroutine authenticating them,
+// and the tokens it answers, closing out when done
+type credsspHandshake struct {
+	in, out chan []byte
+}
+
+func (k *credsspEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if scheme, value, _ := strings.Cut(r.Header.Get("Authorization"), " "); scheme == "CredSSP" {
+		token, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		k.mutex.Lock()
+		handshake, started := k.handshakes[r.RemoteAddr]
+		if !started {
+			handshake = &credsspHandshake{in: make(chan []byte), out: make(chan []byte)}
+			k.handshakes[r.RemoteAddr] = handshake
+			delete(k.contexts, r.RemoteAddr)
+			go k.authenticate(r.RemoteAddr, handshake, token)
+		}
+		k.mutex.Unlock()
+		if started {
+			handshake.in <- token
+		}
+
+		response, ok := <-handshake.out
+		if !ok {
+			k.mutex.Lock()
+			delete(k.handshakes, r.RemoteAddr)
+			authenticated := k.contexts[r.RemoteAddr] != nil
+			k.mutex.Unlock()
+			if !authenticated {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "CredSSP "+base64.StdEncoding.EncodeToString(response))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	k.mutex.Lock()
+	context := k.contexts[r.RemoteAddr]
+	k.rawRequests = append(k.rawRequests, string(body))
+	k.contentTypes = append(k.contentTypes, r.Header.Get("Content-Type"))
+	k.mutex.Unlock()
+	if context == nil || !strings.Contains(r.Header.Get("Content-Type"), `protocol="application/HTTP-CredSSP-session-encrypted"`) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	session := &Encryption{protocol: "credssp", protocolString: []byte("application/HTTP-CredSSP-session-encrypted"), credsspContext: context}
+	request, err := session.decryptResponse(&http.Response{Body: io.NopCloser(bytes.NewReader(body))}, "")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	k.mutex.Lock()
+	k.requests = append(k.requests, string(request))
+	k.mutex.Unlock()
+
+	encrypted, err := session.encryptMessage([]byte(actionResponse(k.responses, request)), "")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", `multipart/encrypted;protocol="application/HTTP-CredSSP-session-encrypted";boundary="Encrypted Boundary"`)
+	_, _ = w.Write(append(encrypted, mimeBoundary+"--\r\n"...))
+}
+
+// authenticate accepts the CredSSP authentication starting with token on the connection of
+// remoteAddr, keeping the credentials delegated
+func (k *credsspEndpoint) authenticate(remoteAddr string, handshake *credsspHandshake, token []byte) {
+	defer close(handshake.out)
+
+	conn := &credsspConn{exchange: func(out []byte) ([]byte, error) {
+		handshake.out <- out
+		in, ok := <-handshake.in
+		if !ok {
+			return nil, io.EOF
+		}
+		return in, nil
+	}}
+	conn.in.Write(token)
+	tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{k.cert}, MaxVersion: tls.VersionTLS12})
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+	publicKey, err := subjectPublicKey(k.cert.Leaf)
+	if err != nil {
+		return
+	}
+
+	var acceptor credsspAcceptor = &ntlmAcceptor{}
+	if k.kdc != nil {
+		acceptor = &kerberosAcceptor{kdc: k.kdc}
+	}
+	for {
+		request, err := readTSRequest(tlsConn)
+		if err != nil {
+			return
+		}
+		if len(request.NegoTokens) > 0 {
+			token, err := acceptor.accept(request.NegoTokens[0].Token)
+			if err != nil {
+				// STATUS_LOGON_FAILURE, answered without waiting for another request
+				_ = writeTSRequest(tlsConn, tsRequest{Version: k.version, ErrorCode: -1073741715})
+				handshake.out <- conn.out.Bytes()
+				return
+			}
+			if len(request.PubKeyAuth) == 0 {
+				if err := writeTSRequest(tlsConn, tsRequest{Version: k.version, NegoTokens: []negoToken{{Token: token}}}); err != nil {
+					return
+				}
+				continue
+			}
+		}
+
+		clientPublicKey, err := acceptor.unwrap(request.PubKeyAuth)
+		if err != nil || !bytes.Equal(clientPublicKey, credsspPublicKeyBinding(k.version, credsspClientBinding, request.ClientNonce, publicKey)) {
+			return
+		}
+		pubKeyAuth, err := acceptor.wrap(credsspPublicKeyBinding(k.version, credsspServerBinding, request.ClientNonce, publicKey))
+		if err != nil {
+			return
+		}
+		if err := writeTSRequest(tlsConn, tsRequest{Version: k.version, PubKeyAuth: pubKeyAuth}); err != nil {
+			return
+		}
+		break
+	}
+
+	request, err := readTSRequest(tlsConn)
+	if err != nil {
+		return
+	}
+	b, err := acceptor.unwrap(request.AuthInfo)
+	if err != nil {
+		return
+	}
+	var credentials tsCredentials
+	if _, err := asn1.Unmarshal(b, &credentials); err != nil {
+		return
+	}
+	var passwordCreds tsPasswordCreds
+	if _, err := asn1.Unmarshal(credentials.Credentials, &passwordCreds); err != nil {
+		return
+	}
+
+	conn.exchange = nil
+	k.mutex.Lock()
+	k.credentials = append(k.credentials, passwordCreds)
+	k.contexts[remoteAddr] = &credsspContext{conn: conn, tls: tlsConn}
+	k.mutex.Unlock()
+}
+
+// kerberosAcceptor accepts the Kerberos authentication of a user of kdc
+type kerberosAcceptor struct {
+	kdc     *testKDC
+	context *kerberosContext
+}
+
+func (a *kerberosAcceptor) accept(token []byte) ([]byte, error) {
+	token, context, err := acceptKerberos(a.kdc, token, nil)
+	a.context = context
+	return token, err
+}
+
+func (a *kerberosAcceptor) wrap(message []byte) ([]byte, error) {
+	header, data, err := a.context.wrap(message)
+	return append(header, data...), err
+}
+
+func (a *kerberosAcceptor) unwrap(token []byte) ([]byte, error) {
+	return a.context.unwrap(token, nil)
+}
+
+// ntlmAcceptor accepts the NTLMv2 authentication of any user whose password is testPassword,
+// sealing messages as MS-NLMP does with extended session security and key exchange
+type ntlmAcceptor struct {
+	// channelBindings, when set, is the hash of the channel bindings the authentication
+	// must be bound to, as servers requiring channel binding tokens check
+	channelBindings []byte
+
+	serverChallenge []byte
+
+	incomingSigningKey, outgoingSigningKey []byte
+	incomingHandle, outgoingHandle         *rc4.Cipher
+	incomingSeqNum, outgoingSeqNum         uint32
+}
+
+const (
+	ntlmNegotiateTargetInfo = 0x00800000
+	ntlmNegotiateVersion    = 0x02000000
+	ntlmNegotiateKeyExch    = 0x40000000
+)
+
+func (a *ntlmAcceptor) accept(token []byte) ([]byte, error) {
+	if len(token) < 16 || !bytes.HasPrefix(token, []byte("NTLMSSP\x00")) {
+		return nil, errors.New("not a NTLM message")
+	}
+	switch binary.LittleEndian.Uint32(token[8:12]) {
+	case 1:
+		return a.challenge(binary.LittleEndian.Uint32(token[12:16]))
+	case 3:
+		return nil, a.authenticate(token)
+	}
+	return nil, errors.New("unexpected NTLM message")
+}
+
+func (a *ntlmAcceptor) challenge(flags uint32) ([]byte, error) {
+	a.serverChallenge = make([]byte, 8)
+	if _, err := rand.Read(a.serverChallenge); err != nil {
+		return nil, err
+	}
+	domain, err := utf16le("EXAMPLE")
+	if err != nil {
+		return nil, err
+	}
+	// MsvAvNbDomainName then MsvAvEOL
+	targetInfo := binary.LittleEndian.AppendUint16([]byte{2, 0}, uint16(len(domain)))
+	targetInfo = append(append(targetInfo, domain...), 0, 0, 0, 0)
+
+	b := make([]byte, 56)
+	copy(b, "NTLMSSP\x00")
+	binary.LittleEndian.PutUint32(b[8:12], 2)
+	binary.LittleEndian.PutUint32(b[20:24], flags&^ntlmNegotiateVersion|ntlmNegotiateTargetInfo)
+	copy(b[24:32], a.serverChallenge)
+	binary.LittleEndian.PutUint16(b[40:42], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(b[42:44], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(b[44:48], uint32(len(b)))
+	return append(b, targetInfo...), nil
+}
+
+func (a *ntlmAcceptor) authenticate(token []byte) error {
+	if len(token) < 64 {
+		return errors.New("NTLM authenticate message too short")
+	}
+	field := func(offset int) []byte {
+		length := int(binary.LittleEndian.Uint16(token[offset : offset+2]))
+		start := int(binary.LittleEndian.Uint32(token[offset+4 : offset+8]))
+		if start+length > len(token) {
+			return nil
+		}
+		return token[start : start+length]
+	}
+	ntChallengeResponse, domain, user, encryptedRandomSessionKey := field(20), field(28), field(36), field(52)
+	flags := binary.LittleEndian.Uint32(token[60:64])
+	if len(ntChallengeResponse) < 16 {
+		return errors.New("not a NTLMv2 response")
+	}
+
+	password, err := utf16le(testPassword)
+	if err != nil {
+		return err
+	}
+	upperUser, err := utf16le(strings.ToUpper(fromUTF16le(user)))
+	if err != nil {
+		return err
+	}
+	passwordHash := md4.New()
+	passwordHash.Write(password)
+	ntowf := hmacMD5(passwordHash.Sum(nil), append(upperUser, domain...))
+	ntProofStr := hmacMD5(ntowf, append(append([]byte(nil), a.serverChallenge...), ntChallengeResponse[16:]...))
+	if !hmac.Equal(ntProofStr, ntChallengeResponse[:16]) {
+		return errors.New("wrong password")
+	}
+
+	if a.channelBindings != nil && !bytes.Equal(ntlmAvPair(ntChallengeResponse[16:], 0x000a), a.channelBindings) {
+		return errors.New("channel bindings mismatch")
+	}
+
+	exportedSessionKey := hmacMD5(ntowf, ntProofStr)
+	if flags&ntlmNegotiateKeyExch != 0 {
+		handle, err := rc4.NewCipher(exportedSessionKey)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, len(encryptedRandomSessionKey))
+		handle.XORKeyStream(key, encryptedRandomSessionKey)
+		exportedSessionKey = key
+	}
+
+	magic := func(constant string) []byte {
+		return md5Sum(append(append([]byte(nil), exportedSessionKey...), constant+"\x00"...))
+	}
+	a.incomingSigningKey = magic("session key to client-to-server signing key magic constant")
+	a.outgoingSigningKey = magic("session key to server-to-client signing key magic constant")
+	if a.incomingHandle, err = rc4.NewCipher(magic("session key to client-to-server sealing key magic constant")); err != nil {
+		return err
+	}
+	a.outgoingHandle, err = rc4.NewCipher(magic("session key to server-to-client sealing key magic constant"))
+	return err
+}
+
+// ntlmAvPair returns the value of the AV pair id of the NTLMv2 client challenge b
+func ntlmAvPair(b []byte, id uint16) []byte {
+	// RespType, HiRespType, Reserved1, Reserved2, TimeStamp, ChallengeFromClient, Reserved3
+	for b = b[min(len(b), 28):]; len(b) >= 4; {
+		avID, length := binary.LittleEndian.Uint16(b[:2]), int(binary.LittleEndian.Uint16(b[2:4]))
+		if avID == 0 || len(b) < 4+length {
+			break
+		}
+		if avID == id {
+			return b[4 : 4+length]
+		}
+		b = b[4+length:]
+	}
+	return nil
+}
+
+func (a *ntlmAcceptor) wrap(message []byte) ([]byte, error) {
+	sealed := make([]byte, len(message))
+	a.outgoingHandle.XORKeyStream(sealed, message)
+	signature := a.signature(message, a.outgoingSigningKey, a.outgoingHandle, a.outgoingSeqNum)
+	a.outgoingSeqNum++
+	return append(signature, sealed...), nil
+}
+
+func (a *ntlmAcceptor) unwrap(token []byte) ([]byte, error) {
+	if len(token) < 16 {
+		return nil, errors.New("NTLM sealed message too short")
+	}
+	message := make([]byte, len(token)-16)
+	a.incomingHandle.XORKeyStream(message, token[16:])
+	expected := a.signature(message, a.incomingSigningKey, a.incomingHandle, a.incomingSeqNum)
+	if !bytes.Equal(expected, token[:16]) {
+		return nil, errors.New("NTLM signature doesn't match")
+	}
+	a.incomingSeqNum++
+	return message, nil
+}
+
+func (a *ntlmAcceptor) signature(message, signingKey []byte, handle *rc4.Cipher, seqNum uint32) []byte {
+	seq := binary.LittleEndian.AppendUint32(nil, seqNum)
+	checksum := make([]byte, 8)
+	handle.XORKeyStream(checksum, hmacMD5(signingKey, append(seq, message...))[:8])
+	signature := binary.LittleEndian.AppendUint32(nil, 1)
+	return append(append(signature, checksum...), seq...)
+}
+
+func hmacMD5(key, message []byte) []byte {
+	mac := hmac.New(md5.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+func md5Sum(b []byte) []byte {
+	sum := md5.Sum(b)
+	return sum[:]
+}
+
+func utf16le(s string) ([]byte, error) {
+	return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder().Bytes([]byte(s))
+}
+
+func fromUTF16le(b []byte) string {
+	s, _ := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewDecoder().Bytes(b)
+	return string(s)
+}
+
+// testCertificate returns a self-signed certificate, such as for the TLS session of CredSSP
+func testCertificate(c *C) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "winrm.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	leaf, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func runCredSSPEndpoint(c *C, kdc *testKDC, responses map[string]string) *credsspEndpoint {
+	return &credsspEndpoint{
+		kdc:        kdc,
+		version:    credsspVersion,
+		cert:       testCertificate(c),
+		responses:  responses,
+		handshakes: make(map[string]*credsspHandshake),
+		contexts:   make(map[string]*credsspContext),
+	}
+}
+
+func credsspClient(c *C, endpoint http.Handler, username, password string, transporter func() Transporter) (*Client, func()) {
+	ts, host, port, err := StartTestServer(endpoint)
+	c.Assert(err, IsNil)
+
+	params := *DefaultParameters
+	params.TransportDecorator = transporter
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), username, password, &params)
+	c.Assert(err, IsNil)
+	return client, ts.Close
+}
+
+func credsspNTLMTransporter() Transporter {
+	encryption, _ := NewEncryption("credssp")
+	return encryption
+}
+
+func (s *WinRMSuite) TestCredSSPEncryption(c *C) {
+	endpoint := runCredSSPEndpoint(c, nil, map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	client, stop := credsspClient(c, endpoint, `EXAMPLE\`+testUser, testPassword, credsspNTLMTransporter)
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		config, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+		c.Assert(err, IsNil)
+		c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+	}
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Assert(endpoint.requests, HasLen, 2)
+	c.Check(endpoint.requests[0], Contains, ResourceURIConfig)
+	for i, raw := range endpoint.rawRequests {
+		c.Check(strings.Contains(raw, ResourceURIConfig), Equals, false)
+		c.Check(raw, Contains, "\tContent-Type: application/HTTP-CredSSP-session-encrypted\r\n")
+		c.Check(endpoint.contentTypes[i], Equals, `multipart/encrypted;protocol="application/HTTP-CredSSP-session-encrypted";boundary="Encrypted Boundary"`)
+	}
+
+	// the session is reused, the credentials being delegated once
+	c.Assert(endpoint.credentials, HasLen, 1)
+	c.Check(fromUTF16le(endpoint.credentials[0].DomainName), Equals, "EXAMPLE")
+	c.Check(fromUTF16le(endpoint.credentials[0].UserName), Equals, testUser)
+	c.Check(fromUTF16le(endpoint.credentials[0].Password), Equals, testPassword)
+}
+
+func (s *WinRMSuite) TestCredSSPEncryptionKerberos(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	endpoint := runCredSSPEndpoint(c, kdc, map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	client, stop := credsspClient(c, endpoint, testUser, testPassword, func() Transporter {
+		return NewKerberosCredSSPEncryption(&Settings{
+			WinRMUsername: testUser,
+			WinRMPassword: testPassword,
+			KrbRealm:      testRealm,
+			KrbConfig:     kdc.KrbConf,
+			KrbSpn:        testSPN,
+		})
+	})
+	defer stop()
+
+	config, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Assert(endpoint.credentials, HasLen, 1)
+	c.Check(fromUTF16le(endpoint.credentials[0].DomainName), Equals, testRealm)
+	c.Check(fromUTF16le(endpoint.credentials[0].UserName), Equals, testUser)
+	c.Check(fromUTF16le(endpoint.credentials[0].Password), Equals, testPassword)
+}
+
+func (s *WinRMSuite) TestCredSSPEncryptionVersion2(c *C) {
+	endpoint := runCredSSPEndpoint(c, nil, map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	// before version 5 the public key of the server is sealed as is
+	endpoint.version = 2
+	client, stop := credsspClient(c, endpoint, testUser, testPassword, credsspNTLMTransporter)
+	defer stop()
+
+	_, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+	c.Assert(err, IsNil)
+}
+
+func (s *WinRMSuite) TestCredSSPEncryptionWrongPassword(c *C) {
+	endpoint := runCredSSPEndpoint(c, nil, nil)
+	client, stop := credsspClient(c, endpoint, testUser, "wrong", credsspNTLMTransporter)
+	defer stop()
+
+	_, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+	c.Assert(err, ErrorMatches, "credssp authentication failed with status 0xc000006d")
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Check(endpoint.credentials, HasLen, 0)
+}
+
+func (s *WinRMSuite) TestCredSSPEncryptionChunks(c *C) {
+	// the messages larger than 16KB are encrypted in chunks of 16KB
+	padding := "<!-- " + strings.Repeat("x", 40000) + " -->"
+	endpoint := runCredSSPEndpoint(c, nil, map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Put": strings.Replace(getConfigResponse, "<cfg:MaxEnvelopeSizekb>", padding+"<cfg:MaxEnvelopeSizekb>", 1),
+	})
+	client, stop := credsspClient(c, endpoint, testUser, testPassword, credsspNTLMTransporter)
+	defer stop()
+
+	config, err := client.Put(context.Background(), ResourceURIConfig, nil, nil, padding)
+	c.Assert(err, IsNil)
+	c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Assert(endpoint.requests, HasLen, 1)
+	c.Check(endpoint.requests[0], Contains, padding)
+	c.Check(endpoint.contentTypes[0], Equals, `multipart/x-multi-encrypted;protocol="application/HTTP-CredSSP-session-encrypted";boundary="Encrypted Boundary"`)
+	c.Check(strings.Count(endpoint.rawRequests[0], "Length=16384\r\n"), Equals, 2)
+	c.Check(strings.Count(endpoint.rawRequests[0], "\tContent-Type: application/octet-stream\r\n"), Equals, len(endpoint.requests[0])/16384+1)
+}
+
+func (s *WinRMSuite) TestCredSSPTSRequest(c *C) {
+	var b bytes.Buffer
+	request := tsRequest{Version: credsspVersion, NegoTokens: []negoToken{{Token: bytes.Repeat([]byte("t"), 300)}}}
+	c.Assert(writeTSRequest(&b, request), IsNil)
+	b.WriteString("next")
+
+	read, err := readTSRequest(&b)
+	c.Assert(err, IsNil)
+	c.Check(read.Version, Equals, credsspVersion)
+	c.Check(read.NegoTokens, DeepEquals, request.NegoTokens)
+	c.Check(read.PubKeyAuth, IsNil)
+	c.Check(b.String(), Equals, "next")
+}
+
+func (s *WinRMSuite) TestGetCredSSPTrailerLength(c *C) {
+	for _, t := range []struct {
+		cipherSuite string
+		length      int
+	}{
+		{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", 16},
+		{"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", 16},
+		{"TLS_RSA_WITH_AES_128_CBC_SHA", 28},
+		{"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256", 44},
+		{"TLS_RSA_WITH_3DES_EDE_CBC_SHA", 28},
+		{"TLS_RSA_WITH_RC4_128_SHA", 20},
+	} {
+		c.Check(getCredSSPTrailerLength(100, t.cipherSuite), Equals, t.length, Commentf(t.cipherSuite))
+	}
+	c.Check(getCredSSPTrailerLength(96, "TLS_RSA_WITH_AES_128_CBC_SHA"), Equals, 32)
+}
@@ -2,6 +2,8 @@ package winrm
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -10,21 +12,59 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bodgit/ntlmssp"
 	ntlmhttp "github.com/bodgit/ntlmssp/http"
+	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/masterzen/winrm/soap"
 )
 
+// Defaults of the pool of the sessions of an Encryption
+const (
+	DefaultEncryptionMaxIdleSessions = 4
+	DefaultEncryptionSessionIdleTTL  = 90 * time.Second
+)
+
 type Encryption struct {
+	// MaxIdleSessions bounds the number of idle sessions kept by server and user, the least
+	// recently used ones being closed, DefaultEncryptionMaxIdleSessions when zero
+	MaxIdleSessions int
+	// SessionIdleTTL is the time after which an idle session is closed,
+	// DefaultEncryptionSessionIdleTTL when zero
+	SessionIdleTTL time.Duration
+
 	ntlm           *ClientNTLM
 	protocol       string
 	protocolString []byte
 	httpClient     *http.Client
 	ntlmClient     *ntlmssp.Client
 	ntlmhttp       *ntlmhttp.Client
+
+	kerberos        *ClientKerberos
+	kerberosContext *kerberosContext
+
+	credsspContext *credsspContext
+
+	// transport is the one of the endpoint, each session having its own connections
+	transport *http.Transport
+	// sessions are the idle sessions established with the servers of the clients, by
+	// sessionKey, which the posts reuse, the most recently used last
+	mutex    sync.Mutex
+	sessions map[string][]idleSession
+	// reaper closes the expired sessions while there are idle sessions
+	reaper *time.Timer
 }
 
+type idleSession struct {
+	session  *Encryption
+	released time.Time
+}
+
+// errUnauthorized is returned when the server rejects the security context of a session
+var errUnauthorized = errors.New("http error 401")
+
 const (
 	sixTenKB       = 16384
 	mimeBoundary   = "--Encrypted Boundary"
@@ -45,7 +85,9 @@ When using Encryption, there are three options available
     protocol: The protocol string used for the particular auth protocol
 
     The auth protocol used, will determine the wrapping and unwrapping method plus
-    the protocol string to use. Currently only NTLM is supported
+    the protocol string to use. Currently NTLM, Kerberos and CredSSP are supported,
+    Kerberos with NewKerberosEncryption as it needs the settings of ClientKerberos.
+    CredSSP authenticates with NTLM, or with Kerberos with NewKerberosCredSSPEncryption
 
     based on the python code from https://pypi.org/project/pywinrm/
 
@@ -63,49 +105,289 @@ func NewEncryption(protocol string) (*Encryption, error) {
 	case "ntlm":
 		encryption.protocolString = []byte("application/HTTP-SPNEGO-session-encrypted")
 		return encryption, nil
-		/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
-		case "credssp":
-			encryption.protocolString = []byte("application/HTTP-CredSSP-session-encrypted")
-		case "kerberos": // kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
-			encryption.protocolString = []byte("application/HTTP-SPNEGO-session-encrypted")
-		*/
+	case "credssp":
+		encryption.protocolString = []byte("application/HTTP-CredSSP-session-encrypted")
+		return encryption, nil
+	case "kerberos":
+		return nil, errors.New("Encryption for protocol 'kerberos' needs the Kerberos settings of NewKerberosEncryption")
 	}
 
 	return nil, fmt.Errorf("Encryption for protocol '%s' not supported", protocol)
 }
 
+// NewKerberosEncryption returns the transport authenticating with Kerberos as ClientKerberos
+// does with settings, and encrypting the messages with the Kerberos session, for the servers
+// which don't allow unencrypted messages over HTTP. Only the AES encryption types are supported.
+func NewKerberosEncryption(settings *Settings) *Encryption {
+	return &Encryption{
+		kerberos:       NewClientKerberos(settings),
+		protocol:       "kerberos",
+		protocolString: []byte("application/HTTP-SPNEGO-session-encrypted"),
+	}
+}
+
+// NewKerberosCredSSPEncryption returns the transport authenticating with CredSSP, Kerberos
+// authenticating the user as ClientKerberos does with settings, and encrypting the messages
+// with the CredSSP session. The password of the user is delegated to the server.
+func NewKerberosCredSSPEncryption(settings *Settings) *Encryption {
+	return &Encryption{
+		kerberos:       NewClientKerberos(settings),
+		protocol:       "credssp",
+		protocolString: []byte("application/HTTP-CredSSP-session-encrypted"),
+	}
+}
+
 func (e *Encryption) Transport(endpoint *Endpoint) error {
-	e.httpClient = &http.Client{}
-	return e.ntlm.Transport(endpoint)
+	var request clientRequest
+	switch {
+	case e.kerberos != nil:
+		request = e.kerberos.clientRequest
+	case e.ntlm != nil:
+		request = e.ntlm.clientRequest
+	}
+	if err := request.Transport(endpoint); err != nil {
+		return err
+	}
+	e.transport, _ = request.transport.(*http.Transport)
+	e.httpClient = &http.Client{Transport: request.transport}
+
+	if e.protocol == "ntlm" {
+		// the messages are posted unencrypted by ClientNTLM when the encryption fails
+		return e.ntlm.Transport(endpoint)
+	}
+	return nil
 }
 
 func (e *Encryption) Post(client *Client, message *soap.SoapMessage) (string, error) {
-	var userName, domain string
-	if strings.Contains(client.username, "@") {
-		parts := strings.Split(client.username, "@")
-		domain = parts[1]
-		userName = parts[0]
-	} else if strings.Contains(client.username, "\\") {
-		parts := strings.Split(client.username, "\\")
-		domain = parts[0]
-		userName = parts[1]
+	return e.PostWithContext(context.Background(), client, message)
+}
+
+// PostWithContext encrypts and posts message, canceling the requests with ctx.
+// The sessions established with the server are kept for the following posts, each on a
+// connection of its own so that concurrent posts don't wait for each other, and established
+// again when the server rejects them, such as after closing their connection.
+func (e *Encryption) PostWithContext(ctx context.Context, client *Client, message *soap.SoapMessage) (string, error) {
+	session, err := e.session(ctx, client)
+	if err != nil {
+		// unlike NTLM, there is no falling back to unencrypted messages
+		if e.protocol == "ntlm" {
+			return e.ntlm.PostWithContext(ctx, client, message)
+		}
+		return "", err
+	}
+
+	body, err := session.prepareEncryptedRequest(ctx, client.url, []byte(message.String()))
+	if errors.Is(err, errUnauthorized) {
+		session.close()
+		if session, err = e.newSession(ctx, client); err != nil {
+			return "", err
+		}
+		body, err = session.prepareEncryptedRequest(ctx, client.url, []byte(message.String()))
+	}
+
+	// after any other error, the sequence numbers of the session may be out of sync
+	var fault *WSManFault
+	if err == nil || errors.As(err, &fault) {
+		e.release(client, session)
 	} else {
-		userName = client.username
+		session.close()
+	}
+	return body, err
+}
+
+func sessionKey(client *Client) string {
+	return client.url + " " + client.username
+}
+
+// session returns an idle session established with the server of client, or a new one
+func (e *Encryption) session(ctx context.Context, client *Client) (*Encryption, error) {
+	e.mutex.Lock()
+	expired, _ := e.expired(time.Now())
+	var session *Encryption
+	key := sessionKey(client)
+	if idle := e.sessions[key]; len(idle) > 0 {
+		session = idle[len(idle)-1].session
+		if len(idle) == 1 {
+			delete(e.sessions, key)
+		} else {
+			e.sessions[key] = idle[:len(idle)-1]
+		}
+	}
+	e.mutex.Unlock()
+
+	closeSessions(expired)
+	if session != nil {
+		return session, nil
+	}
+	return e.newSession(ctx, client)
+}
+
+// release keeps session, established with the server of client, for the following posts,
+// closing the least recently used sessions beyond MaxIdleSessions
+func (e *Encryption) release(client *Client, session *Encryption) {
+	e.mutex.Lock()
+	if e.sessions == nil {
+		e.sessions = make(map[string][]idleSession)
+	}
+	key := sessionKey(client)
+	idle := append(e.sessions[key], idleSession{session: session, released: time.Now()})
+	var evicted []*Encryption
+	if n := len(idle) - e.maxIdleSessions(); n > 0 {
+		for _, pooled := range idle[:n] {
+			evicted = append(evicted, pooled.session)
+		}
+		idle = append([]idleSession(nil), idle[n:]...)
+	}
+	e.sessions[key] = idle
+	if e.reaper == nil {
+		e.reaper = time.AfterFunc(e.sessionIdleTTL(), e.reap)
+	}
+	e.mutex.Unlock()
+
+	closeSessions(evicted)
+}
+
+// reap closes the expired sessions, and runs again once the oldest idle session expires
+func (e *Encryption) reap() {
+	e.mutex.Lock()
+	expired, oldest := e.expired(time.Now())
+	e.reaper = nil
+	if !oldest.IsZero() {
+		e.reaper = time.AfterFunc(time.Until(oldest.Add(e.sessionIdleTTL())), e.reap)
+	}
+	e.mutex.Unlock()
+
+	closeSessions(expired)
+}
+
+// expired removes the sessions idle for SessionIdleTTL at now from the pool, returning them
+// and the time the oldest of the others was released, zero when there is none
+func (e *Encryption) expired(now time.Time) ([]*Encryption, time.Time) {
+	var expired []*Encryption
+	var oldest time.Time
+	for key, idle := range e.sessions {
+		kept := idle[:0]
+		for _, pooled := range idle {
+			if now.Sub(pooled.released) >= e.sessionIdleTTL() {
+				expired = append(expired, pooled.session)
+				continue
+			}
+			if oldest.IsZero() || pooled.released.Before(oldest) {
+				oldest = pooled.released
+			}
+			kept = append(kept, pooled)
+		}
+		if len(kept) == 0 {
+			delete(e.sessions, key)
+		} else {
+			e.sessions[key] = kept
+		}
 	}
+	return expired, oldest
+}
+
+func (e *Encryption) maxIdleSessions() int {
+	if e.MaxIdleSessions <= 0 {
+		return DefaultEncryptionMaxIdleSessions
+	}
+	return e.MaxIdleSessions
+}
+
+func (e *Encryption) sessionIdleTTL() time.Duration {
+	if e.SessionIdleTTL <= 0 {
+		return DefaultEncryptionSessionIdleTTL
+	}
+	return e.SessionIdleTTL
+}
+
+// Close closes the idle sessions and the idle connections of the encryption. The sessions of
+// the posts in progress are kept once they are done, the encryption remaining usable.
+func (e *Encryption) Close() error {
+	e.mutex.Lock()
+	var sessions []*Encryption
+	for _, idle := range e.sessions {
+		for _, pooled := range idle {
+			sessions = append(sessions, pooled.session)
+		}
+	}
+	e.sessions = nil
+	if e.reaper != nil {
+		e.reaper.Stop()
+		e.reaper = nil
+	}
+	e.mutex.Unlock()
+
+	closeSessions(sessions)
+	if e.httpClient != nil {
+		e.httpClient.CloseIdleConnections()
+	}
+	return nil
+}
 
+func closeSessions(sessions []*Encryption) {
+	for _, session := range sessions {
+		session.close()
+	}
+}
+
+// newSession establishes a session with the server of client, on a connection of its own
+func (e *Encryption) newSession(ctx context.Context, client *Client) (*Encryption, error) {
+	transport := e.transport
+	if transport == nil {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	session := &Encryption{
+		ntlm:           e.ntlm,
+		protocol:       e.protocol,
+		protocolString: e.protocolString,
+		httpClient:     &http.Client{Transport: transport.Clone()},
+		kerberos:       e.kerberos,
+	}
+	if err := session.authenticate(ctx, client); err != nil {
+		session.close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// authenticate establishes the security context of the session with the server of client
+func (e *Encryption) authenticate(ctx context.Context, client *Client) error {
+	switch e.protocol {
+	case "kerberos":
+		return e.prepareRequest(ctx, client.url)
+	case "credssp":
+		return e.authenticateCredSSP(ctx, client)
+	}
+
+	userName, domain := splitUsername(client.username)
 	e.ntlmClient, _ = ntlmssp.NewClient(ntlmssp.SetUserInfo(userName, client.password), ntlmssp.SetDomain(domain), ntlmssp.SetVersion(ntlmssp.DefaultVersion()))
-	e.ntlmhttp, _ = ntlmhttp.NewClient(e.httpClient, e.ntlmClient)
+	e.ntlmhttp, _ = ntlmhttp.NewClient(e.httpClient, e.ntlmClient, ntlmhttp.SendCBT(true))
+	return e.prepareRequest(ctx, client.url)
+}
 
-	var err error
-	if err = e.PrepareRequest(client, client.url); err == nil {
-		return e.PrepareEncryptedRequest(client, client.url, []byte(message.String()))
-	} else {
-		return e.ntlm.Post(client, message)
+// close closes the connections of the session, whose transport is its own
+func (e *Encryption) close() {
+	e.httpClient.CloseIdleConnections()
+}
+
+// splitUsername returns the user and the domain of username, such as user@domain or domain\\user
+func splitUsername(username string) (string, string) {
+	if strings.Contains(username, "@") {
+		parts := strings.Split(username, "@")
+		return parts[0], parts[1]
+	} else if strings.Contains(username, "\\") {
+		parts := strings.Split(username, "\\")
+		return parts[1], parts[0]
 	}
+	return username, ""
 }
 
 func (e *Encryption) PrepareRequest(client *Client, endpoint string) error {
-	req, err := http.NewRequest("POST", endpoint, nil)
+	return e.prepareRequest(context.Background(), endpoint)
+}
+
+func (e *Encryption) prepareRequest(ctx context.Context, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
 	if err != nil {
 		return err
 	}
@@ -115,6 +397,10 @@ func (e *Encryption) PrepareRequest(client *Client, endpoint string) error {
 	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
 	req.Header.Set("Connection", "Keep-Alive")
 
+	if e.protocol == "kerberos" {
+		return e.authenticateKerberos(req)
+	}
+
 	resp, err := e.ntlmhttp.Do(req)
 	if err != nil {
 		return fmt.Errorf("unknown error %w", err)
@@ -135,6 +421,186 @@ func (e *Encryption) PrepareRequest(client *Client, endpoint string) error {
 	return nil
 }
 
+// authenticateKerberos sends req, the empty request of prepareRequest, with the AP-REQ of a
+// new Kerberos security context, which the AP-REP of the server completes
+func (e *Encryption) authenticateKerberos(req *http.Request) error {
+	kerberosClient, release, err := e.kerberos.kerberosClient()
+	if err != nil {
+		return err
+	}
+	defer release()
+	spn := e.kerberos.SPN
+	if spn == "" {
+		spn = "HTTP/" + req.URL.Hostname()
+	}
+	bindings := e.kerberos.channelBindings()
+	resp, kerberosContext, err := e.exchangeKerberos(req, kerberosClient, spn, bindings)
+	if err != nil {
+		return err
+	}
+	// the certificate of the server is only known once connected, so the authentication
+	// may go without the bindings the server requires, or with the ones of a previous
+	// certificate of the server, and is made again bound to the current connection
+	if current := channelBindings(resp.TLS); !sameChannelBindings(bindings, current) {
+		bindings = current
+		e.kerberos.setChannelBindings(bindings)
+		if resp.StatusCode == http.StatusUnauthorized {
+			if resp, kerberosContext, err = e.exchangeKerberos(req, kerberosClient, spn, bindings); err != nil {
+				return err
+			}
+		}
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("http error %d", resp.StatusCode)
+	}
+
+	var responseToken []byte
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		scheme, value, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "Negotiate") || strings.EqualFold(scheme, "Kerberos") {
+			if responseToken, err = base64.StdEncoding.DecodeString(strings.TrimSpace(value)); err != nil {
+				return fmt.Errorf("invalid %s header: %w", scheme, err)
+			}
+			break
+		}
+	}
+	if responseToken == nil {
+		return errors.New("kerberos mutual authentication failed: no token in the response")
+	}
+	if err := kerberosContext.complete(responseToken); err != nil {
+		return err
+	}
+
+	e.kerberosContext = kerberosContext
+	return nil
+}
+
+// exchangeKerberos sends req with the AP-REQ of a new Kerberos security context bound to the
+// channel of bindings, returning the response, whose body is consumed, with the context
+func (e *Encryption) exchangeKerberos(req *http.Request, kerberosClient *client.Client, spn string, bindings *ntlmssp.ChannelBindings) (*http.Response, *kerberosContext, error) {
+	kerberosContext, token, err := newKerberosInitiator(kerberosClient, spn, bindings)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unknown error %w", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		return nil, nil, fmt.Errorf("read response body: %w", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return nil, nil, fmt.Errorf("close request body: %w", err)
+	}
+	return resp, kerberosContext, nil
+}
+
+// authenticateCredSSP establishes the CredSSP context with the server of client, delegating
+// the credentials of the user
+func (e *Encryption) authenticateCredSSP(ctx context.Context, client *Client) error {
+	var auth credsspAuthenticator
+	var credentials []byte
+	if e.kerberos != nil {
+		if e.kerberos.Password == "" {
+			return errors.New("credssp delegates the password of the user, which is not set")
+		}
+		kerberosClient, release, err := e.kerberos.kerberosClient()
+		if err != nil {
+			return err
+		}
+		defer release()
+		spn := e.kerberos.SPN
+		if spn == "" {
+			u, err := url.Parse(client.url)
+			if err != nil {
+				return err
+			}
+			spn = "HTTP/" + u.Hostname()
+		}
+		auth = &credsspKerberos{client: kerberosClient, spn: spn}
+		if credentials, err = credsspCredentials(e.kerberos.Realm, e.kerberos.Username, e.kerberos.Password); err != nil {
+			return err
+		}
+	} else {
+		userName, domain := splitUsername(client.username)
+		ntlmClient, err := ntlmssp.NewClient(ntlmssp.SetUserInfo(userName, client.password), ntlmssp.SetDomain(domain), ntlmssp.SetVersion(ntlmssp.DefaultVersion()))
+		if err != nil {
+			return err
+		}
+		auth = &credsspNTLM{client: ntlmClient}
+		if credentials, err = credsspCredentials(domain, userName, client.password); err != nil {
+			return err
+		}
+	}
+
+	exchange := func(token []byte) ([]byte, error) {
+		return e.exchangeCredSSP(ctx, client.url, token)
+	}
+	credsspContext, err := newCredSSPContext(exchange, auth, credentials)
+	if err != nil {
+		return err
+	}
+	e.credsspContext = credsspContext
+	return nil
+}
+
+// exchangeCredSSP sends token, the TLS records of the CredSSP authentication, in an empty
+// request, returning the records of the response
+func (e *Encryption) exchangeCredSSP(ctx context.Context, endpoint string, token []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", "WinRM client")
+	req.Header.Set("Content-Length", "0")
+	req.Header.Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	req.Header.Set("Connection", "Keep-Alive")
+	req.Header.Set("Authorization", "CredSSP "+base64.StdEncoding.EncodeToString(token))
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		return nil, fmt.Errorf("read response body: %w", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		return nil, fmt.Errorf("close request body: %w", err)
+	}
+
+	var responseToken []byte
+	for _, header := range resp.Header.Values("WWW-Authenticate") {
+		scheme, value, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "CredSSP") && strings.TrimSpace(value) != "" {
+			if responseToken, err = base64.StdEncoding.DecodeString(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid CredSSP header: %w", err)
+			}
+			break
+		}
+	}
+
+	switch {
+	case resp.StatusCode == 200:
+		return responseToken, nil
+	case resp.StatusCode == 401 && responseToken != nil:
+		return responseToken, nil
+	case resp.StatusCode == 401:
+		return nil, errors.New("credssp authentication rejected: http error 401")
+	}
+	return nil, fmt.Errorf("http error %d", resp.StatusCode)
+}
+
+// do sends req over the connection the security context was established on
+func (e *Encryption) do(req *http.Request) (*http.Response, error) {
+	if e.protocol != "ntlm" {
+		return e.httpClient.Do(req)
+	}
+	return e.ntlmhttp.Do(req)
+}
+
 /*
 Creates a prepared request to send to the server with an encrypted message
 and correct headers
@@ -144,6 +610,10 @@ and correct headers
 :return: A prepared request that has an decrypted message
 */
 func (e *Encryption) PrepareEncryptedRequest(client *Client, endpoint string, message []byte) (string, error) {
+	return e.prepareEncryptedRequest(context.Background(), endpoint, message)
+}
+
+func (e *Encryption) prepareEncryptedRequest(ctx context.Context, endpoint string, message []byte) (string, error) {
 	url, err := url.Parse(endpoint)
 	if err != nil {
 		return "", err
@@ -158,21 +628,27 @@ func (e *Encryption) PrepareEncryptedRequest(client *Client, endpoint string, me
 		encrypted_message = []byte{}
 		message_chunks := [][]byte{}
 		for i := 0; i < len(message); i += sixTenKB {
-			message_chunks = append(message_chunks, message[i:i+sixTenKB])
+			message_chunks = append(message_chunks, message[i:min(i+sixTenKB, len(message))])
 		}
 		for _, message_chunk := range message_chunks {
-			encrypted_chunk := e.encryptMessage(message_chunk, host)
+			encrypted_chunk, err := e.encryptMessage(message_chunk, host)
+			if err != nil {
+				return "", err
+			}
 			encrypted_message = append(encrypted_message, encrypted_chunk...)
 		}
 	} else {
 		content_type = "multipart/encrypted"
-		encrypted_message = e.encryptMessage(message, host)
+		encrypted_message, err = e.encryptMessage(message, host)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	encrypted_message = append(encrypted_message, []byte(mimeBoundary)...)
 	encrypted_message = append(encrypted_message, []byte("--\r\n")...)
 
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(encrypted_message))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(encrypted_message))
 	if err != nil {
 		return "", err
 	}
@@ -182,14 +658,24 @@ func (e *Encryption) PrepareEncryptedRequest(client *Client, endpoint string, me
 	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(encrypted_message)))
 	req.Header.Set("Content-Type", fmt.Sprintf(`%s;protocol="%s";boundary="Encrypted Boundary"`, content_type, e.protocolString))
 
-	resp, err := e.ntlmhttp.Do(req)
+	resp, err := e.do(req)
 	if err != nil {
 		return "", fmt.Errorf("unknown error %w", err)
 	}
 
 	body, err := e.ParseEncryptedResponse(resp)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("%w: %s", errUnauthorized, body)
+	}
+	if resp.StatusCode != 200 {
+		return "", responseError(resp.StatusCode, string(body))
+	}
 
-	return string(body), err
+	return string(body), nil
 }
 
 /*
@@ -211,8 +697,11 @@ func (e *Encryption) ParseEncryptedResponse(response *http.Response) ([]byte, er
 	return body, nil
 }
 
-func (e *Encryption) encryptMessage(message []byte, host string) []byte {
-	encryptedStream, _ := e.buildMessage(message, host)
+func (e *Encryption) encryptMessage(message []byte, host string) ([]byte, error) {
+	encryptedStream, err := e.buildMessage(message, host)
+	if err != nil {
+		return nil, err
+	}
 
 	messagePayload := bytes.Join([][]byte{
 		[]byte(mimeBoundary),
@@ -225,7 +714,7 @@ func (e *Encryption) encryptMessage(message []byte, host string) []byte {
 		encryptedStream,
 	}, []byte{})
 
-	return messagePayload
+	return messagePayload, nil
 }
 
 func deleteEmpty(b [][]byte) [][]byte {
@@ -282,12 +771,10 @@ func (e *Encryption) decryptMessage(encryptedData []byte, host string) ([]byte,
 	switch e.protocol {
 	case "ntlm":
 		return e.decryptNtlmMessage(encryptedData, host)
-		/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
-		case "credssp":
-			return e.decryptCredsspMessage(encryptedData, host)
-		case "kerberos":
-			return e.decryptKerberosMessage(encryptedData, host)
-		*/
+	case "kerberos":
+		return e.decryptKerberosMessage(encryptedData, host)
+	case "credssp":
+		return e.decryptCredsspMessage(encryptedData, host)
 	default:
 		return nil, errors.New("Encryption for protocol " + e.protocol + " not supported")
 	}
@@ -305,48 +792,42 @@ func (e *Encryption) decryptNtlmMessage(encryptedData []byte, host string) ([]by
 	return message, nil
 }
 
-/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
-func (e *Encryption) decryptCredsspMessage(encryptedData []byte, host string) ([]byte, error) {
-	// // TODO
-	// encryptedMessage := encryptedData[4:]
-
-	// credsspContext, ok := e.session.Auth.Contexts()[host]
-	// if !ok {
-	// 	return nil, fmt.Errorf("credssp context not found for host: %s", host)
-	// }
+func (e *Encryption) decryptKerberosMessage(encryptedData []byte, host string) ([]byte, error) {
+	if e.kerberosContext == nil {
+		return nil, errors.New("kerberos security context not established")
+	}
+	if len(encryptedData) < 4 {
+		return nil, errors.New("kerberos encrypted message too short")
+	}
+	signatureLength := int(binary.LittleEndian.Uint32(encryptedData[:4]))
+	if signatureLength > len(encryptedData)-4 {
+		return nil, errors.New("kerberos encrypted message too short")
+	}
+	signature := encryptedData[4 : signatureLength+4]
+	encryptedMessage := encryptedData[signatureLength+4:]
 
-	// message, err := credsspContext.Unwrap(encryptedMessage)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// return message, nil
+	return e.kerberosContext.unwrap(signature, encryptedMessage)
 }
 
-func (enc *Encryption) decryptKerberosMessage(encryptedData []byte, host string) ([]byte, error) {
-	// //TODO
-	// signatureLength := binary.LittleEndian.Uint32(encryptedData[0:4])
-	// signature := encryptedData[4 : 4+signatureLength]
-	// encryptedMessage := encryptedData[4+signatureLength:]
-
-	// message, err := enc.session.Auth.UnwrapWinrm(host, encryptedMessage, signature)
-	// if err != nil {
-	// 	return nil, err
-	// }
-
-	// return message, nil
+func (e *Encryption) decryptCredsspMessage(encryptedData []byte, host string) ([]byte, error) {
+	if e.credsspContext == nil {
+		return nil, errors.New("credssp security context not established")
+	}
+	if len(encryptedData) < 4 {
+		return nil, errors.New("credssp encrypted message too short")
+	}
+	// the length of the trailer of the records precedes them
+	return e.credsspContext.unwrap(encryptedData[4:])
 }
-*/
 
 func (e *Encryption) buildMessage(encryptedData []byte, host string) ([]byte, error) {
 	switch e.protocol {
 	case "ntlm":
 		return e.buildNTLMMessage(encryptedData, host)
-		/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
-		case "credssp":
-			return e.buildCredSSPMessage(encryptedData, host)
-		case "kerberos":
-			return e.buildKerberosMessage(encryptedData, host)
-		*/
+	case "kerberos":
+		return e.buildKerberosMessage(encryptedData, host)
+	case "credssp":
+		return e.buildCredSSPMessage(encryptedData, host)
 	default:
 		return nil, errors.New("Encryption for protocol " + e.protocol + " not supported")
 	}
@@ -372,67 +853,41 @@ func (enc *Encryption) buildNTLMMessage(message []byte, host string) ([]byte, er
 	return buf.Bytes(), nil
 }
 
-/* credssp and kerberos is currently unimplemented, leave holder for future to keep in sync with python implementation
-func (e *Encryption) buildCredSSPMessage(message []byte, host string) ([]byte, error) {
-	// //TODO
-	// context := e.session.Auth.Contexts[host]
-	// sealedMessage := context.Wrap(message)
-
-	// cipherNegotiated := context.TLSConnection.ConnectionState().CipherSuite.Name
-	// trailerLength := e.getCredSSPTrailerLength(len(message), cipherNegotiated)
-
-	// trailer := make([]byte, 4)
-	// binary.LittleEndian.PutUint32(trailer, uint32(trailerLength))
-
-	// return append(trailer, sealedMessage...), nil
-}
-
 func (e *Encryption) buildKerberosMessage(message []byte, host string) ([]byte, error) {
-	// //TODO
-	// sealedMessage, signature := e.session.Auth.WrapWinrm(host, message)
-
-	// signatureLength := make([]byte, 4)
-	// binary.LittleEndian.PutUint32(signatureLength, uint32(len(signature)))
+	if e.kerberosContext == nil {
+		return nil, errors.New("kerberos security context not established")
+	}
+	signature, sealedMessage, err := e.kerberosContext.wrap(message)
+	if err != nil {
+		return nil, err
+	}
 
-	// return append(append(signatureLength, signature...), sealedMessage...), nil
-}
+	buf := new(bytes.Buffer)
+	if err = binary.Write(buf, binary.LittleEndian, uint32(len(signature))); err != nil {
+		return nil, err
+	}
 
-func (e *Encryption) getCredSSPTrailerLength(messageLength int, cipherSuite string) int {
-	var trailerLength int
+	buf.Write(signature)
+	buf.Write(sealedMessage)
 
-	if match, _ := regexp.MatchString("^.*-GCM-[\\w\\d]*$", cipherSuite); match {
-		trailerLength = 16
-	} else {
-		hashAlgorithm := cipherSuite[strings.LastIndex(cipherSuite, "-")+1:]
-		var hashLength int
-
-		if hashAlgorithm == "MD5" {
-			hashLength = 16
-		} else if hashAlgorithm == "SHA" {
-			hashLength = 20
-		} else if hashAlgorithm == "SHA256" {
-			hashLength = 32
-		} else if hashAlgorithm == "SHA384" {
-			hashLength = 48
-		} else {
-			hashLength = 0
-		}
+	return buf.Bytes(), nil
+}
 
-		prePadLength := messageLength + hashLength
-		paddingLength := 0
+func (e *Encryption) buildCredSSPMessage(message []byte, host string) ([]byte, error) {
+	if e.credsspContext == nil {
+		return nil, errors.New("credssp security context not established")
+	}
+	sealedMessage, trailerLength, err := e.credsspContext.wrap(message)
+	if err != nil {
+		return nil, err
+	}
 
-		if strings.Contains(cipherSuite, "RC4") {
-			paddingLength = 0
-		} else if strings.Contains(cipherSuite, "DES") || strings.Contains(cipherSuite, "3DES") {
-			paddingLength = 8 - (prePadLength % 8)
+	buf := new(bytes.Buffer)
+	if err = binary.Write(buf, binary.LittleEndian, uint32(trailerLength)); err != nil {
+		return nil, err
+	}
 
-		} else {
-			// AES is a 128 bit block cipher
-			paddingLength = 16 - (prePadLength % 16)
-		}
+	buf.Write(sealedMessage)
 
-		trailerLength = (prePadLength + paddingLength) - messageLength
-	}
-	return trailerLength
+	return buf.Bytes(), nil
 }
-*/
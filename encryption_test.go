@@ -0,0 +1,602 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+	. "gopkg.in/check.v1"
+)
+
+// kerberosEndpoint stands in for a WinRM service only accepting Kerberos encrypted messages,
+// answering them with the response of the first action found in the decrypted request
+type kerberosEndpoint struct {
+	kdc       *testKDC
+	responses map[string]string
+	// channelBindings, when set, is the hash of the channel bindings required
+	channelBindings []byte
+
+	mutex sync.Mutex
+	// contexts are the security contexts of the connections, by remote address
+	contexts map[string]*kerberosContext
+	requests []string
+	// rawRequests are the bodies received on the wire
+	rawRequests []string
+}
+
+func (k *kerberosEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		token, err := k.accept(r.RemoteAddr, strings.TrimPrefix(authorization, "Negotiate "))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+		if len(body) == 0 {
+			return
+		}
+	}
+
+	k.mutex.Lock()
+	acceptor := k.contexts[r.RemoteAddr]
+	k.rawRequests = append(k.rawRequests, string(body))
+	k.mutex.Unlock()
+	if acceptor == nil || !strings.Contains(r.Header.Get("Content-Type"), `protocol="application/HTTP-SPNEGO-session-encrypted"`) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	session := &Encryption{protocol: "kerberos", protocolString: []byte("application/HTTP-SPNEGO-session-encrypted"), kerberosContext: acceptor}
+	request, err := session.decryptResponse(&http.Response{Body: io.NopCloser(bytes.NewReader(body))}, "")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	k.mutex.Lock()
+	k.requests = append(k.requests, string(request))
+	k.mutex.Unlock()
+
+	encrypted, err := session.encryptMessage([]byte(actionResponse(k.responses, request)), "")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", `multipart/encrypted;protocol="application/HTTP-SPNEGO-session-encrypted";boundary="Encrypted Boundary"`)
+	_, _ = w.Write(append(encrypted, mimeBoundary+"--\r\n"...))
+}
+
+// accept accepts the AP-REQ of the authorization header, returning the SPNEGO token of the
+// AP-REP establishing the acceptor context of the connection
+func (k *kerberosEndpoint) accept(remoteAddr, authorization string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(authorization)
+	if err != nil {
+		return nil, err
+	}
+	token, acceptor, err := acceptKerberos(k.kdc, b, k.channelBindings)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mutex.Lock()
+	k.contexts[remoteAddr] = acceptor
+	k.mutex.Unlock()
+	return token, nil
+}
+
+// acceptKerberos accepts the AP-REQ of the SPNEGO token b for a service of kdc, bound to the
+// channel bindings of the hash channelBindings when not nil, returning the SPNEGO token of
+// the AP-REP with the acceptor context it establishes
+func acceptKerberos(kdc *testKDC, b []byte, channelBindings []byte) ([]byte, *kerberosContext, error) {
+	var token spnego.SPNEGOToken
+	if err := token.Unmarshal(b); err != nil {
+		return nil, nil, err
+	}
+	var mechToken spnego.KRB5Token
+	if err := mechToken.Unmarshal(token.NegTokenInit.MechTokenBytes); err != nil {
+		return nil, nil, err
+	}
+	apReq := mechToken.APReq
+	if err := apReq.Ticket.DecryptEncPart(kdc.keytab, nil); err != nil {
+		return nil, nil, err
+	}
+	sessionKey := apReq.Ticket.DecryptedEncPart.Key
+	if err := apReq.DecryptAuthenticator(sessionKey); err != nil {
+		return nil, nil, err
+	}
+	if checksum := apReq.Authenticator.Cksum.Checksum; channelBindings != nil && (len(checksum) < 20 || !bytes.Equal(checksum[4:20], channelBindings)) {
+		return nil, nil, errors.New("channel bindings mismatch")
+	}
+	e, err := crypto.GetEtype(sessionKey.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	subkey, err := types.GenerateEncryptionKey(e)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	part, err := asn1.Marshal(messages.EncAPRepPart{
+		CTime:          apReq.Authenticator.CTime,
+		Cusec:          apReq.Authenticator.Cusec,
+		Subkey:         subkey,
+		SequenceNumber: 42,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	encPart, err := crypto.GetEncryptedData(asn1tools.AddASNAppTag(part, asnAppTag.EncAPRepPart), sessionKey, keyusage.AP_REP_ENCPART, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	apRep, err := asn1.Marshal(messages.APRep{PVNO: 5, MsgType: msgtype.KRB_AP_REP, EncPart: encPart})
+	if err != nil {
+		return nil, nil, err
+	}
+	oid, _ := asn1.Marshal(gssapi.OIDKRB5.OID())
+	mech := asn1tools.AddASNAppTag(append(append(oid, 0x02, 0x00), asn1tools.AddASNAppTag(apRep, asnAppTag.APREP)...), 0)
+
+	resp := spnego.NegTokenResp{NegState: asn1.Enumerated(spnego.NegStateAcceptCompleted), SupportedMech: gssapi.OIDKRB5.OID(), ResponseToken: mech}
+	respToken, err := resp.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+	return respToken, &kerberosContext{key: subkey, etype: e, acceptorSubkey: true, sendSeq: 42}, nil
+}
+
+// actionResponse returns the response of the first action of responses found in request
+func actionResponse(responses map[string]string, request []byte) string {
+	for action, response := range responses {
+		if strings.Contains(string(request), action+"<") {
+			return response
+		}
+	}
+	return ""
+}
+
+func runKerberosEndpoint(c *C, responses map[string]string) (*Client, *kerberosEndpoint, func()) {
+	kdc := startTestKDC(c)
+	endpoint := &kerberosEndpoint{kdc: kdc, responses: responses, contexts: make(map[string]*kerberosContext)}
+	ts, host, port, err := StartTestServer(endpoint)
+	c.Assert(err, IsNil)
+
+	params := *DefaultParameters
+	params.TransportDecorator = func() Transporter {
+		return NewKerberosEncryption(&Settings{
+			WinRMUsername: testUser,
+			WinRMPassword: testPassword,
+			KrbRealm:      testRealm,
+			KrbConfig:     kdc.KrbConf,
+			KrbSpn:        testSPN,
+		})
+	}
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), testUser, testPassword, &params)
+	c.Assert(err, IsNil)
+
+	return client, endpoint, func() {
+		ts.Close()
+		kdc.Close()
+	}
+}
+
+func (s *WinRMSuite) TestKerberosEncryption(c *C) {
+	client, endpoint, stop := runKerberosEndpoint(c, map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		config, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+		c.Assert(err, IsNil)
+		c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+	}
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Assert(endpoint.requests, HasLen, 2)
+	c.Check(endpoint.requests[0], Contains, ResourceURIConfig)
+	for _, raw := range endpoint.rawRequests {
+		c.Check(strings.Contains(raw, ResourceURIConfig), Equals, false)
+		c.Check(raw, Contains, "\tContent-Type: application/HTTP-SPNEGO-session-encrypted\r\n")
+		c.Check(raw, Contains, fmt.Sprintf("Length=%d\r\n", len(endpoint.requests[0])))
+	}
+}
+
+func (s *WinRMSuite) TestKerberosEncryptionWrongPassword(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+
+	encryption := NewKerberosEncryption(&Settings{
+		WinRMUsername: testUser,
+		WinRMPassword: "wrong",
+		KrbRealm:      testRealm,
+		KrbConfig:     kdc.KrbConf,
+		KrbSpn:        testSPN,
+	})
+	c.Assert(encryption.Transport(NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)), IsNil)
+	_, err := encryption.PostWithContext(context.Background(), &Client{url: "http://localhost:5985/wsman"}, NewIdentifyRequest())
+	c.Assert(err, ErrorMatches, "kerberos login: .*")
+}
+
+func (s *WinRMSuite) TestNewEncryptionKerberos(c *C) {
+	_, err := NewEncryption("kerberos")
+	c.Assert(err, ErrorMatches, ".*NewKerberosEncryption")
+	_, err = NewEncryption("basic")
+	c.Assert(err, ErrorMatches, "Encryption for protocol 'basic' not supported")
+}
+
+// ntlmEndpoint stands in for a WinRM service only accepting NTLM encrypted messages,
+// answering them with the response of the first action found in the decrypted request
+type ntlmEndpoint struct {
+	responses map[string]string
+	// channelBindings, when set, is the hash of the channel bindings required
+	channelBindings []byte
+
+	mutex sync.Mutex
+	// pending are the authentications in progress, acceptors the security contexts
+	// established, by remote address
+	pending         map[string]*ntlmAcceptor
+	acceptors       map[string]*ntlmAcceptor
+	authentications int
+	requests        []string
+}
+
+func newNTLMEndpoint(responses map[string]string) *ntlmEndpoint {
+	return &ntlmEndpoint{
+		responses: responses,
+		pending:   make(map[string]*ntlmAcceptor),
+		acceptors: make(map[string]*ntlmAcceptor),
+	}
+}
+
+func (k *ntlmEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if scheme, value, _ := strings.Cut(r.Header.Get("Authorization"), " "); scheme == "Negotiate" {
+		token, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		acceptor := k.pending[r.RemoteAddr]
+		if acceptor == nil {
+			acceptor = &ntlmAcceptor{channelBindings: k.channelBindings}
+		}
+		response, err := acceptor.accept(token)
+		if err != nil {
+			delete(k.pending, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if response != nil {
+			k.pending[r.RemoteAddr] = acceptor
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(response))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		delete(k.pending, r.RemoteAddr)
+		k.acceptors[r.RemoteAddr] = acceptor
+		k.authentications++
+		return
+	}
+
+	acceptor := k.acceptors[r.RemoteAddr]
+	if acceptor == nil {
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// the sealed message follows the headers of the last part, read as is since it may
+	// hold anything, such as lines which would pass for MIME headers
+	octetStream := []byte("\tContent-Type: application/octet-stream\r\n")
+	start := bytes.Index(body, octetStream)
+	end := len(body) - len(mimeBoundary+"--\r\n")
+	if start < 0 || end < start+len(octetStream)+4 || !bytes.HasSuffix(body, []byte(mimeBoundary+"--\r\n")) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	data := body[start+len(octetStream) : end]
+	request, err := acceptor.unwrap(data[4:])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	k.requests = append(k.requests, string(request))
+
+	response := actionResponse(k.responses, request)
+	sealed, err := acceptor.wrap([]byte(response))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", `multipart/encrypted;protocol="application/HTTP-SPNEGO-session-encrypted";boundary="Encrypted Boundary"`)
+	fmt.Fprintf(w, "%[1]s\r\n\tContent-Type: application/HTTP-SPNEGO-session-encrypted\r\n"+
+		"\tOriginalContent: type=application/soap+xml;charset=UTF-8;Length=%[2]d\r\n"+
+		"%[1]s\r\n\tContent-Type: application/octet-stream\r\n%[3]s%[1]s--\r\n",
+		mimeBoundary, len(response), append(binary.LittleEndian.AppendUint32(nil, 16), sealed...))
+}
+
+// forget drops the security contexts, as the server does when closing the connections
+func (k *ntlmEndpoint) forget() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.acceptors = make(map[string]*ntlmAcceptor)
+}
+
+func runNTLMEndpoint(c *C, endpoint *ntlmEndpoint) (*Client, func()) {
+	ts, host, port, err := StartTestServer(endpoint)
+	c.Assert(err, IsNil)
+
+	params := *DefaultParameters
+	params.TransportDecorator = func() Transporter {
+		encryption, _ := NewEncryption("ntlm")
+		return encryption
+	}
+	client, err := NewClientWithParameters(NewEndpoint(host, port, false, false, nil, nil, nil, 0), testUser, testPassword, &params)
+	c.Assert(err, IsNil)
+	return client, ts.Close
+}
+
+func (s *WinRMSuite) TestNTLMEncryptionReusesSession(c *C) {
+	endpoint := newNTLMEndpoint(map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	client, stop := runNTLMEndpoint(c, endpoint)
+	defer stop()
+
+	for i := 0; i < 3; i++ {
+		config, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+		c.Assert(err, IsNil)
+		c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+	}
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Check(endpoint.requests, HasLen, 3)
+	c.Check(endpoint.authentications, Equals, 1)
+}
+
+func (s *WinRMSuite) TestNTLMEncryptionReauthenticates(c *C) {
+	endpoint := newNTLMEndpoint(map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	client, stop := runNTLMEndpoint(c, endpoint)
+	defer stop()
+
+	_, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+	c.Assert(err, IsNil)
+	endpoint.forget()
+	config, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Check(endpoint.requests, HasLen, 2)
+	c.Check(endpoint.authentications, Equals, 2)
+}
+
+func (s *WinRMSuite) TestNTLMEncryptionConcurrent(c *C) {
+	endpoint := newNTLMEndpoint(map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	client, stop := runNTLMEndpoint(c, endpoint)
+	defer stop()
+
+	const workers = 8
+	// the sessions of all the workers are kept
+	client.http.(*Encryption).MaxIdleSessions = workers
+	errs := make(chan error, workers*3)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				_, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Check(err, IsNil)
+	}
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Check(endpoint.requests, HasLen, workers*3)
+	c.Check(endpoint.authentications <= workers, Equals, true)
+}
+
+func (s *WinRMSuite) TestNTLMEncryptionClose(c *C) {
+	endpoint := newNTLMEndpoint(map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	client, stop := runNTLMEndpoint(c, endpoint)
+	defer stop()
+	encryption := client.http.(*Encryption)
+
+	_, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(encryption.Close(), IsNil)
+	c.Check(encryption.sessions, HasLen, 0)
+
+	// the encryption is still usable, establishing a new session
+	_, err = client.Get(context.Background(), ResourceURIConfig, nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(encryption.Close(), IsNil)
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Check(endpoint.authentications, Equals, 2)
+}
+
+// closeRecorder is the transport of a session, counting the times it is closed
+type closeRecorder struct {
+	mutex  sync.Mutex
+	closed int
+}
+
+func (r *closeRecorder) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("not connected")
+}
+
+func (r *closeRecorder) CloseIdleConnections() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed++
+}
+
+func (r *closeRecorder) closes() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+func recordedSession() (*Encryption, *closeRecorder) {
+	recorder := &closeRecorder{}
+	return &Encryption{httpClient: &http.Client{Transport: recorder}}, recorder
+}
+
+func (s *WinRMSuite) TestEncryptionMaxIdleSessions(c *C) {
+	encryption := &Encryption{MaxIdleSessions: 2}
+	client := &Client{url: "http://localhost:5985/wsman", username: testUser}
+
+	var recorders []*closeRecorder
+	for i := 0; i < 3; i++ {
+		session, recorder := recordedSession()
+		recorders = append(recorders, recorder)
+		encryption.release(client, session)
+	}
+	defer encryption.Close()
+
+	// the least recently used session is closed
+	c.Check(recorders[0].closes(), Equals, 1)
+	c.Check(recorders[1].closes(), Equals, 0)
+	c.Check(recorders[2].closes(), Equals, 0)
+
+	session, err := encryption.session(context.Background(), client)
+	c.Assert(err, IsNil)
+	c.Check(session.httpClient.Transport, Equals, recorders[2])
+
+	c.Assert(encryption.Close(), IsNil)
+	c.Check(recorders[1].closes(), Equals, 1)
+	c.Check(recorders[2].closes(), Equals, 0)
+}
+
+func (s *WinRMSuite) TestEncryptionSessionIdleTTL(c *C) {
+	encryption := &Encryption{SessionIdleTTL: 20 * time.Millisecond}
+	client := &Client{url: "http://localhost:5985/wsman", username: testUser}
+	session, recorder := recordedSession()
+	encryption.release(client, session)
+	defer encryption.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for recorder.closes() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(recorder.closes(), Equals, 1)
+
+	encryption.mutex.Lock()
+	defer encryption.mutex.Unlock()
+	c.Check(encryption.sessions, HasLen, 0)
+	c.Check(encryption.reaper, IsNil)
+}
+
+func (s *WinRMSuite) TestNTLMEncryptionChannelBinding(c *C) {
+	endpoint := newNTLMEndpoint(map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	})
+	ts := httptest.NewTLSServer(endpoint)
+	defer ts.Close()
+	endpoint.channelBindings = testChannelBindings(ts.Certificate())
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	params := *DefaultParameters
+	params.TransportDecorator = func() Transporter {
+		encryption, _ := NewEncryption("ntlm")
+		return encryption
+	}
+	client, err := NewClientWithParameters(NewEndpoint(host, port, true, true, nil, nil, nil, 0), testUser, testPassword, &params)
+	c.Assert(err, IsNil)
+
+	config, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+	c.Assert(err, IsNil)
+	c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Check(endpoint.requests, HasLen, 1)
+	c.Check(endpoint.authentications, Equals, 1)
+}
+
+func (s *WinRMSuite) TestKerberosEncryptionChannelBinding(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	endpoint := &kerberosEndpoint{kdc: kdc, responses: map[string]string{
+		"http://schemas.xmlsoap.org/ws/2004/09/transfer/Get": getConfigResponse,
+	}, contexts: make(map[string]*kerberosContext)}
+	ts := httptest.NewTLSServer(endpoint)
+	defer ts.Close()
+	endpoint.channelBindings = testChannelBindings(ts.Certificate())
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	params := *DefaultParameters
+	params.TransportDecorator = func() Transporter {
+		return NewKerberosEncryption(&Settings{
+			WinRMUsername: testUser,
+			WinRMPassword: testPassword,
+			KrbRealm:      testRealm,
+			KrbConfig:     kdc.KrbConf,
+			KrbSpn:        testSPN,
+		})
+	}
+	client, err := NewClientWithParameters(NewEndpoint(host, port, true, true, nil, nil, nil, 0), testUser, testPassword, &params)
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 2; i++ {
+		config, err := client.Get(context.Background(), ResourceURIConfig, nil, nil)
+		c.Assert(err, IsNil)
+		c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+	}
+	endpoint.mutex.Lock()
+	defer endpoint.mutex.Unlock()
+	c.Check(endpoint.requests, HasLen, 2)
+}
@@ -0,0 +1,178 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+)
+
+// enumerationMaxElements is the number of items asked for in each Enumerate or Pull request,
+// the server returning fewer of them when they don't fit in an envelope
+const enumerationMaxElements = 32000
+
+// Filter dialects understood by the Windows WS-Management service
+const (
+	DialectWQL      = "http://schemas.microsoft.com/wbem/wsman/1/WQL"
+	DialectSelector = "http://schemas.dmtf.org/wbem/wsman/1/wsman/SelectorFilter"
+)
+
+// Filter restricts the instances returned by an enumeration
+type Filter struct {
+	Dialect string
+	Query   string
+}
+
+// WQLFilter returns a filter selecting instances with a WQL query, such as
+// "SELECT * FROM Win32_Service WHERE State = 'Running'". The resource URI of the
+// enumeration must then be the wildcard URI of the WMI namespace, e.g.
+// http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*
+func WQLFilter(query string) *Filter {
+	return &Filter{Dialect: DialectWQL, Query: query}
+}
+
+// Enumeration iterates over the instances returned by a WS-Enumeration, pulling
+// them from the server as they are consumed:
+//
+//	enumeration, err := client.Enumerate(ctx, uri, nil)
+//	if err != nil {
+//		return err
+//	}
+//	defer enumeration.Close()
+//	for enumeration.Next() {
+//		item := enumeration.Item()
+//		...
+//	}
+//	return enumeration.Err()
+type Enumeration struct {
+	ctx         context.Context
+	client      *Client
+	resourceURI string
+	context     string
+	items       []*Instance
+	item        *Instance
+	end         bool
+	err         error
+}
+
+// Enumerate starts the enumeration of the instances of resourceURI, such as
+// http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service, restricted
+// to those matching filter when it is not nil. Canceling the context stops the enumeration.
+func (c *Client) Enumerate(ctx context.Context, resourceURI string, filter *Filter) (*Enumeration, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := NewEnumerateRequest(c.url, resourceURI, filter, &c.Parameters)
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	enumerationContext, items, end, err := ParseEnumerateResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Enumeration{
+		ctx:         ctx,
+		client:      c,
+		resourceURI: resourceURI,
+		context:     enumerationContext,
+		items:       items,
+		end:         end,
+	}, nil
+}
+
+// EnumerateAll returns all the instances of resourceURI matching filter, see Enumerate
+func (c *Client) EnumerateAll(ctx context.Context, resourceURI string, filter *Filter) ([]*Instance, error) {
+	enumeration, err := c.Enumerate(ctx, resourceURI, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer enumeration.Close()
+
+	var items []*Instance
+	for enumeration.Next() {
+		items = append(items, enumeration.Item())
+	}
+	return items, enumeration.Err()
+}
+
+// Next advances to the next instance, pulling more of them from the server when needed.
+// It returns false at the end of the enumeration or on error, see Err.
+func (e *Enumeration) Next() bool {
+	for len(e.items) == 0 {
+		if e.end || e.err != nil {
+			e.item = nil
+			return false
+		}
+		e.pull()
+	}
+
+	e.item, e.items = e.items[0], e.items[1:]
+	return true
+}
+
+func (e *Enumeration) pull() {
+	if e.err = e.ctx.Err(); e.err != nil {
+		return
+	}
+
+	request := NewPullRequest(e.client.url, e.resourceURI, e.context, &e.client.Parameters)
+	defer request.Free()
+
+	response, err := e.client.sendRequestWithContext(e.ctx, request)
+	if err != nil {
+		e.err = err
+		return
+	}
+
+	enumerationContext, items, end, err := ParseEnumerateResponse(response)
+	if err != nil {
+		e.err = err
+		return
+	}
+	if enumerationContext == "" && !end {
+		e.err = errors.New("pull response has no enumeration context")
+		return
+	}
+
+	e.context, e.items, e.end = enumerationContext, items, end
+}
+
+// Item returns the current instance
+func (e *Enumeration) Item() *Instance {
+	return e.item
+}
+
+// Err returns the error which stopped the enumeration, if any
+func (e *Enumeration) Err() error {
+	return e.err
+}
+
+// Close releases the enumeration on the server when it didn't reach its end, canceling the
+// request with the context of the enumeration. It is safe to call Close several times.
+func (e *Enumeration) Close() error {
+	return e.CloseWithContext(e.ctx)
+}
+
+// CloseWithContext releases the enumeration on the server when it didn't reach its end,
+// canceling the request with ctx. When ctx is done, the enumeration can still be released
+// with another context, such as after the cancellation of the context of the enumeration.
+func (e *Enumeration) CloseWithContext(ctx context.Context) error {
+	if e.end || e.context == "" {
+		return nil
+	}
+	e.items = nil
+
+	request := NewReleaseRequest(e.client.url, e.resourceURI, e.context, &e.client.Parameters)
+	defer request.Free()
+
+	_, err := e.client.sendRequestWithContext(ctx, request)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	e.end = true
+	return err
+}
@@ -0,0 +1,185 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+const (
+	enumerateServicesResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xml:lang="en-US"><s:Header><a:Action>http://schemas.xmlsoap.org/ws/2004/09/enumeration/EnumerateResponse</a:Action><a:MessageID>uuid:5C8B1E0F-3A7D-4B2E-9F61-8D4C2A0B7E13</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:0E4F6A2B-9C1D-4E8F-A3B5-7D2C6E9F1A04</a:RelatesTo></s:Header><s:Body><n:EnumerateResponse><n:EnumerationContext>uuid:D1B6E3F0-7A2C-4E5B-8C9D-0F1A2B3C4D5E</n:EnumerationContext><w:Items>` +
+		`<p:Win32_Service xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service" xmlns:cim="http://schemas.dmtf.org/wbem/wscim/1/common" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="p:Win32_Service_Type" xml:lang="en-US"><p:AcceptPause>false</p:AcceptPause><p:DisplayName>Windows Remote Management (WS-Management)</p:DisplayName><p:InstallDate xsi:nil="true"/><p:Name>WinRM</p:Name><p:ProcessId>1234</p:ProcessId><p:StartMode>Auto</p:StartMode><p:State>Running</p:State></p:Win32_Service>` +
+		`<p:Win32_Service xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service" xmlns:cim="http://schemas.dmtf.org/wbem/wscim/1/common" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="p:Win32_Service_Type" xml:lang="en-US"><p:AcceptPause>false</p:AcceptPause><p:DisplayName>Windows Update</p:DisplayName><p:InstallDate xsi:nil="true"/><p:Name>wuauserv</p:Name><p:ProcessId>0</p:ProcessId><p:StartMode>Manual</p:StartMode><p:State>Stopped</p:State></p:Win32_Service>` +
+		`</w:Items></n:EnumerateResponse></s:Body></s:Envelope>`
+
+	pullServicesResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xml:lang="en-US"><s:Header><a:Action>http://schemas.xmlsoap.org/ws/2004/09/enumeration/PullResponse</a:Action><a:MessageID>uuid:7F3A9C1E-2B4D-4F6A-8E0C-1D3B5A7C9E2F</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:4A6C8E0F-1B3D-4F5A-9C7E-2D4F6A8C0E1B</a:RelatesTo></s:Header><s:Body><n:PullResponse><n:Items>` +
+		`<p:Win32_Service xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service" xmlns:cim="http://schemas.dmtf.org/wbem/wscim/1/common" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="p:Win32_Service_Type" xml:lang="en-US"><p:AcceptPause>true</p:AcceptPause><p:DisplayName>Workstation</p:DisplayName><p:InstallDate xsi:nil="true"/><p:Name>LanmanWorkstation</p:Name><p:ProcessId>1620</p:ProcessId><p:StartMode>Auto</p:StartMode><p:State>Running</p:State></p:Win32_Service>` +
+		`</n:Items><n:EndOfSequence/></n:PullResponse></s:Body></s:Envelope>`
+
+	enumerateOperatingSystemResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xml:lang="en-US"><s:Header><a:Action>http://schemas.xmlsoap.org/ws/2004/09/enumeration/EnumerateResponse</a:Action><a:MessageID>uuid:2E4A6C8F-0B1D-4E3F-9A5C-7E9B1D3F5A7C</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:8C0E2A4B-6D1F-4A3C-9E5B-7F1D3B5E7A9C</a:RelatesTo></s:Header><s:Body><n:EnumerateResponse><n:EnumerationContext></n:EnumerationContext><w:Items>` +
+		`<p:Win32_OperatingSystem xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_OperatingSystem" xmlns:cim="http://schemas.dmtf.org/wbem/wscim/1/common" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="p:Win32_OperatingSystem_Type" xml:lang="en-US"><p:Caption>Microsoft Windows Server 2022 Datacenter</p:Caption><p:LastBootUpTime><cim:Datetime>2024-03-01T08:15:30.5+00:00</cim:Datetime></p:LastBootUpTime><p:MUILanguages>en-US</p:MUILanguages><p:MUILanguages>fr-FR</p:MUILanguages><p:Version>10.0.20348</p:Version></p:Win32_OperatingSystem>` +
+		`</w:Items><w:EndOfSequence/></n:EnumerateResponse></s:Body></s:Envelope>`
+
+	invalidEnumerationContextResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xml:lang="en-US"><s:Header><a:Action>http://schemas.dmtf.org/wbem/wsman/1/wsman/fault</a:Action><a:MessageID>uuid:3B5D7F9A-1C2E-4A6B-8D0F-2E4A6C8B0D1F</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To></s:Header><s:Body><s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>n:InvalidEnumerationContext</s:Value></s:Subcode></s:Code><s:Reason><s:Text xml:lang="en-US">The enumeration context supplied in the message is not valid.</s:Text></s:Reason></s:Fault></s:Body></s:Envelope>`
+)
+
+func runEnumerateFakeServer(c *C, responses map[string]string) (*Client, func() []string, func()) {
+	var mutex sync.Mutex
+	var actions []string
+
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		body := string(b)
+
+		w.Header().Set("Content-Type", "application/soap+xml")
+		for _, action := range []string{"Enumerate", "Pull", "Release"} {
+			if !strings.Contains(body, "http://schemas.xmlsoap.org/ws/2004/09/enumeration/"+action) {
+				continue
+			}
+			mutex.Lock()
+			actions = append(actions, action)
+			mutex.Unlock()
+
+			response, ok := responses[action]
+			if !ok || strings.Contains(response, "<s:Fault>") {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			fmt.Fprintln(w, response)
+			return
+		}
+		c.Errorf("unexpected request %s", body)
+	}))
+	c.Assert(err, IsNil)
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	return client, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return actions
+	}, ts.Close
+}
+
+func (s *WinRMSuite) TestEnumerateAll(c *C) {
+	client, actions, stop := runEnumerateFakeServer(c, map[string]string{
+		"Enumerate": enumerateServicesResponse,
+		"Pull":      pullServicesResponse,
+	})
+	defer stop()
+
+	items, err := client.EnumerateAll(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service", nil)
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 3)
+
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Get("Name"))
+	}
+	c.Assert(names, DeepEquals, []string{"WinRM", "wuauserv", "LanmanWorkstation"})
+
+	c.Assert(items[0].XMLName.Local, Equals, "Win32_Service")
+	c.Assert(items[0].XMLName.Space, Equals, "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service")
+	c.Assert(items[0].Get("State"), Equals, "Running")
+	c.Assert(items[0].Values("InstallDate"), DeepEquals, []string{})
+	c.Assert(items[0].Values("Missing"), IsNil)
+
+	// the enumeration reached its end, there is nothing to release
+	c.Assert(actions(), DeepEquals, []string{"Enumerate", "Pull"})
+}
+
+func (s *WinRMSuite) TestEnumerateWithWQLFilter(c *C) {
+	client, _, stop := runEnumerateFakeServer(c, map[string]string{
+		"Enumerate": enumerateOperatingSystemResponse,
+	})
+	defer stop()
+
+	enumeration, err := client.Enumerate(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*", WQLFilter("SELECT * FROM Win32_OperatingSystem"))
+	c.Assert(err, IsNil)
+	defer enumeration.Close()
+
+	c.Assert(enumeration.Next(), Equals, true)
+	item := enumeration.Item()
+	c.Assert(item.Get("Caption"), Equals, "Microsoft Windows Server 2022 Datacenter")
+	c.Assert(item.Get("LastBootUpTime"), Equals, "2024-03-01T08:15:30.5+00:00")
+	c.Assert(item.Values("MUILanguages"), DeepEquals, []string{"en-US", "fr-FR"})
+
+	var os struct {
+		Caption      string   `xml:"Caption"`
+		Languages    []string `xml:"MUILanguages"`
+		LastBootTime string   `xml:"LastBootUpTime>Datetime"`
+	}
+	c.Assert(item.Unmarshal(&os), IsNil)
+	c.Assert(os.Caption, Equals, "Microsoft Windows Server 2022 Datacenter")
+	c.Assert(os.Languages, DeepEquals, []string{"en-US", "fr-FR"})
+	c.Assert(os.LastBootTime, Equals, "2024-03-01T08:15:30.5+00:00")
+
+	c.Assert(enumeration.Next(), Equals, false)
+	c.Assert(enumeration.Err(), IsNil)
+	c.Assert(enumeration.Item(), IsNil)
+}
+
+func (s *WinRMSuite) TestEnumerateCloseReleases(c *C) {
+	client, actions, stop := runEnumerateFakeServer(c, map[string]string{
+		"Enumerate": enumerateServicesResponse,
+		"Release":   "<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body/></s:Envelope>",
+	})
+	defer stop()
+
+	enumeration, err := client.Enumerate(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service", nil)
+	c.Assert(err, IsNil)
+	c.Assert(enumeration.Next(), Equals, true)
+	c.Assert(enumeration.Item().Get("Name"), Equals, "WinRM")
+
+	c.Assert(enumeration.Close(), IsNil)
+	c.Assert(enumeration.Close(), IsNil)
+	c.Assert(enumeration.Next(), Equals, false)
+	c.Assert(actions(), DeepEquals, []string{"Enumerate", "Release"})
+}
+
+func (s *WinRMSuite) TestEnumeratePullError(c *C) {
+	client, _, stop := runEnumerateFakeServer(c, map[string]string{
+		"Enumerate": enumerateServicesResponse,
+		"Pull":      invalidEnumerationContextResponse,
+		"Release":   "<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body/></s:Envelope>",
+	})
+	defer stop()
+
+	_, err := client.EnumerateAll(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service", nil)
+	var fault *WSManFault
+	c.Assert(errors.As(err, &fault), Equals, true)
+	c.Check(fault.Subcode, Equals, "n:InvalidEnumerationContext")
+	c.Check(fault.Timeout(), Equals, false)
+}
+
+func (s *WinRMSuite) TestEnumerateCanceled(c *C) {
+	client, actions, stop := runEnumerateFakeServer(c, map[string]string{
+		"Enumerate": enumerateServicesResponse,
+		"Pull":      pullServicesResponse,
+		"Release":   "<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body/></s:Envelope>",
+	})
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	enumeration, err := client.Enumerate(ctx, "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service", nil)
+	c.Assert(err, IsNil)
+
+	cancel()
+	c.Assert(enumeration.Next(), Equals, true)
+	c.Assert(enumeration.Next(), Equals, true)
+	c.Assert(enumeration.Next(), Equals, false)
+	c.Assert(enumeration.Err(), Equals, context.Canceled)
+	c.Assert(enumeration.Close(), Equals, context.Canceled)
+	c.Assert(actions(), DeepEquals, []string{"Enumerate"})
+	c.Assert(enumeration.CloseWithContext(context.Background()), IsNil)
+	c.Assert(enumeration.Close(), IsNil)
+	c.Assert(actions(), DeepEquals, []string{"Enumerate", "Release"})
+}
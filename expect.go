@@ -0,0 +1,157 @@
+package winrm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrExpectTimeout is the cause of an ExpectError when the output didn't match in time
+var ErrExpectTimeout = errors.New("timed out")
+
+// ExpectError is returned by Expect when the output of the command didn't match the pattern,
+// holding the transcript of the interaction for troubleshooting
+type ExpectError struct {
+	Pattern string
+	// Err is ErrExpectTimeout, io.EOF when the output ended, or the error which ended it
+	Err error
+	// Transcript is the output of the command and the input sent to it so far
+	Transcript string
+}
+
+func (e *ExpectError) Error() string {
+	return fmt.Sprintf("expecting %q: %s, transcript:\n%s", e.Pattern, e.Err, e.Transcript)
+}
+
+func (e *ExpectError) Unwrap() error {
+	return e.Err
+}
+
+// Expecter scripts the interaction with a command prompting on its input, such as an
+// installer, matching its output with patterns and sending it answers:
+//
+//	expecter := winrm.NewExpecter(command)
+//	if _, err := expecter.Expect(regexp.MustCompile(`Continue\? \[y/n\]`), time.Minute); err != nil {
+//		return err
+//	}
+//	err := expecter.Send("y\r\n")
+//
+// The stdout and stderr of the command are matched together, a pattern matching across
+// the chunks of output received.
+type Expecter struct {
+	command *Command
+
+	mutex sync.Mutex
+	// output is the output not consumed by a match yet
+	output     []byte
+	transcript bytes.Buffer
+	// changed is closed and replaced when the output changes
+	changed chan struct{}
+	open    int
+	err     error
+}
+
+// NewExpecter returns an Expecter of command, which reads the stdout and stderr of the command
+// from then on and discards its other output streams, as selected with Shell.SetOutputStreams
+func NewExpecter(command *Command) *Expecter {
+	e := &Expecter{
+		command: command,
+		changed: make(chan struct{}),
+		open:    2,
+	}
+	command.discardOtherStreams()
+	go e.read(command.Stdout)
+	go e.read(command.Stderr)
+	return e
+}
+
+func (e *Expecter) read(stream io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := stream.Read(buf)
+
+		e.mutex.Lock()
+		if n > 0 {
+			e.output = append(e.output, buf[:n]...)
+			e.transcript.Write(buf[:n])
+		}
+		if err != nil {
+			e.open--
+			if !errors.Is(err, io.EOF) && e.err == nil {
+				e.err = err
+			}
+		}
+		close(e.changed)
+		e.changed = make(chan struct{})
+		e.mutex.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Expect waits for the output of the command to match pattern within timeout, returning the
+// match and its submatches. The output up to the end of the match is consumed, the next
+// Expect matching the output which follows it.
+func (e *Expecter) Expect(pattern *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		e.mutex.Lock()
+		if loc := pattern.FindSubmatchIndex(e.output); loc != nil {
+			match := make([]string, len(loc)/2)
+			for i := range match {
+				if loc[2*i] >= 0 {
+					match[i] = string(e.output[loc[2*i]:loc[2*i+1]])
+				}
+			}
+			e.output = e.output[loc[1]:]
+			e.mutex.Unlock()
+			return match, nil
+		}
+		if e.open == 0 {
+			err := e.err
+			if err == nil {
+				err = io.EOF
+			}
+			e.mutex.Unlock()
+			return nil, e.fail(pattern, err)
+		}
+		changed := e.changed
+		e.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil, e.fail(pattern, ErrExpectTimeout)
+		}
+	}
+}
+
+func (e *Expecter) fail(pattern *regexp.Regexp, err error) error {
+	return &ExpectError{Pattern: pattern.String(), Err: err, Transcript: e.Transcript()}
+}
+
+// Send writes s to the input of the command as is, the line end, such as \r\n, being
+// part of s
+func (e *Expecter) Send(s string) error {
+	e.mutex.Lock()
+	e.transcript.WriteString(s)
+	e.mutex.Unlock()
+
+	_, err := e.command.Stdin.Write([]byte(s))
+	return err
+}
+
+// Transcript returns the output of the command and the input sent to it so far
+func (e *Expecter) Transcript() string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.transcript.String()
+}
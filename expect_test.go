@@ -0,0 +1,150 @@
+package winrm
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/masterzen/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+// stdoutResponse returns a Receive response holding output on stdout
+func stdoutResponse(output string) string {
+	return strings.Replace(singleOutputResponse, "VGhhdCdzIGFsbCBmb2xrcyEhIQ==", base64.StdEncoding.EncodeToString([]byte(output)), 1)
+}
+
+// runInstallerFakeRequester answers like an installer prompting on its input, whose output
+// is made of chunks and which runs until answered
+func runInstallerFakeRequester(c *C, client *Client, chunks ...string) chan string {
+	answers := make(chan string, 1)
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "shell/Command<"):
+			return executeCommandResponse, nil
+		case strings.Contains(body, "shell/Send<"):
+			data, err := base64.StdEncoding.DecodeString(regexp.MustCompile(`Name="stdin"[^>]*>([^<]*)<`).FindStringSubmatch(body)[1])
+			c.Assert(err, IsNil)
+			answers <- string(data)
+			return "", nil
+		case strings.Contains(body, "shell/Signal<"):
+			return "", nil
+		}
+		if len(chunks) > 0 {
+			chunk := chunks[0]
+			chunks = chunks[1:]
+			return stdoutResponse(chunk), nil
+		}
+		select {
+		case answer := <-answers:
+			if answer == "y\r\n" {
+				chunks = append(chunks, "Installed.\r\n")
+				return stdoutResponse("Installing...\r\n"), nil
+			}
+			return doneCommandResponse, nil
+		case <-time.After(20 * time.Millisecond):
+			fault, err := ParseFault(operationTimeoutResponse)
+			c.Assert(err, IsNil)
+			return "", fault
+		}
+	}
+	client.http = &r
+	return answers
+}
+
+func (s *WinRMSuite) TestExpect(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	runInstallerFakeRequester(c, client, "Install to C:\\Prog", "ram Files\\App? [y", "/n] ")
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	command, err := shell.ExecuteWithContext(context.Background(), "setup.exe")
+	c.Assert(err, IsNil)
+	defer command.Close()
+
+	expecter := NewExpecter(command)
+	match, err := expecter.Expect(regexp.MustCompile(`Install to (.*)\? \[y/n\] $`), time.Second)
+	c.Assert(err, IsNil)
+	c.Check(match, DeepEquals, []string{"Install to C:\\Program Files\\App? [y/n] ", "C:\\Program Files\\App"})
+
+	c.Assert(expecter.Send("y\r\n"), IsNil)
+	_, err = expecter.Expect(regexp.MustCompile(`Installed\.`), time.Second)
+	c.Assert(err, IsNil)
+	c.Check(expecter.Transcript(), Equals, "Install to C:\\Program Files\\App? [y/n] y\r\nInstalling...\r\nInstalled.\r\n")
+}
+
+func (s *WinRMSuite) TestExpectCustomStreams(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	runInstallerFakeRequester(c, client, "Continue? [y/n] ")
+	// the installer also writes to a progress stream
+	r := client.http.(*Requester)
+	post := r.http
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		response, err := post(client, message)
+		return strings.Replace(response, "</rsp:ReceiveResponse>", "<rsp:Stream Name=\"progress\" CommandId=\"1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4\">cHJvZ3Jlc3M=</rsp:Stream></rsp:ReceiveResponse>", 1), err
+	}
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	shell.SetOutputStreams("stdout", "stderr", "progress")
+	command, err := shell.ExecuteWithContext(context.Background(), "setup.exe")
+	c.Assert(err, IsNil)
+	defer command.Close()
+
+	expecter := NewExpecter(command)
+	_, err = expecter.Expect(regexp.MustCompile(`\[y/n\]`), time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(expecter.Send("y\r\n"), IsNil)
+	_, err = expecter.Expect(regexp.MustCompile(`Installed\.`), time.Second)
+	c.Assert(err, IsNil)
+}
+
+func (s *WinRMSuite) TestExpectTimeout(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	runInstallerFakeRequester(c, client, "Enter the license key: ")
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	command, err := shell.ExecuteWithContext(context.Background(), "setup.exe")
+	c.Assert(err, IsNil)
+	defer command.Close()
+
+	expecter := NewExpecter(command)
+	_, err = expecter.Expect(regexp.MustCompile(`\[y/n\]`), 100*time.Millisecond)
+	c.Assert(errors.Is(err, ErrExpectTimeout), Equals, true)
+	var expectErr *ExpectError
+	c.Assert(errors.As(err, &expectErr), Equals, true)
+	c.Check(expectErr.Pattern, Equals, `\[y/n\]`)
+	c.Check(expectErr.Transcript, Equals, "Enter the license key: ")
+	c.Check(err.Error(), Equals, "expecting \"\\\\[y/n\\\\]\": timed out, transcript:\nEnter the license key: ")
+}
+
+func (s *WinRMSuite) TestExpectEndOfOutput(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	runInstallerFakeRequester(c, client, "Continue? [y/n] ")
+
+	shell := &Shell{client: client, id: "67A74734-DD32-4F10-89DE-49A060483810"}
+	command, err := shell.ExecuteWithContext(context.Background(), "setup.exe")
+	c.Assert(err, IsNil)
+
+	expecter := NewExpecter(command)
+	_, err = expecter.Expect(regexp.MustCompile(`\[y/n\]`), time.Second)
+	c.Assert(err, IsNil)
+	c.Assert(expecter.Send("n\r\n"), IsNil)
+
+	_, err = expecter.Expect(regexp.MustCompile(`Installed`), time.Second)
+	c.Assert(errors.Is(err, io.EOF), Equals, true)
+	command.Wait()
+	c.Check(command.ExitCode(), Equals, 123)
+}
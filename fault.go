@@ -0,0 +1,229 @@
+package winrm
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/ChrisTrenkamp/goxpath/tree/xmltree"
+	"github.com/masterzen/winrm/soap"
+)
+
+// WSManFault is the SOAP fault returned by the WS-Management service when a request fails
+type WSManFault struct {
+	// Code is the SOAP fault code, e.g. s:Receiver
+	Code string
+	// Subcode is the WS-Management fault subcode, e.g. w:TimedOut
+	Subcode string
+	// SubcodeName is Subcode resolved against the namespaces declared by the response,
+	// independent of the prefix chosen by the service
+	SubcodeName xml.Name
+	// Reason is the human readable SOAP fault reason
+	Reason string
+	// FaultCode is the WS-Management error code, e.g. 2150858793 for an operation timeout
+	FaultCode uint32
+	// Machine is the host which raised the fault
+	Machine string
+	// Message is the WS-Management error message
+	Message string
+	// ProviderFault is the error reported by the provider of the resource, such as WMI, if any
+	ProviderFault *ProviderFault
+	// Detail is the WS-Management fault detail URI, such as faultDetailMaxEnvelopeSize, if any
+	Detail string
+}
+
+// Timeout reports whether the fault is an operation timeout, the service having had nothing
+// to return within the OperationTimeout of the request, such as no command output
+func (f *WSManFault) Timeout() bool {
+	return f.SubcodeName == subcodeTimedOut || f.FaultCode == faultCodeOperationTimeout
+}
+
+// subcodeTimedOut is the WS-Management fault subcode of an operation timeout
+var subcodeTimedOut = xml.Name{Space: soap.NS_WSMAN_DMTF, Local: "TimedOut"}
+
+// faultCodeOperationTimeout is the WS-Management error code of an operation timeout
+const faultCodeOperationTimeout = 2150858793
+
+// EnvelopeSizeExceeded reports whether the fault is the rejection of a request larger than
+// the MaxEnvelopeSize of the service
+func (f *WSManFault) EnvelopeSizeExceeded() bool {
+	return f.Detail == faultDetailMaxEnvelopeSize || (f.SubcodeName == subcodeEncodingLimit && f.Detail == "")
+}
+
+// subcodeEncodingLimit is the WS-Management fault subcode of a request exceeding a limit
+var subcodeEncodingLimit = xml.Name{Space: soap.NS_WSMAN_DMTF, Local: "EncodingLimit"}
+
+// faultDetailMaxEnvelopeSize is the fault detail of a request exceeding MaxEnvelopeSize
+const faultDetailMaxEnvelopeSize = "http://schemas.dmtf.org/wbem/wsman/1/wsman/faultDetail/MaxEnvelopeSize"
+
+// ProviderFault is the error reported by the plugin serving a resource
+type ProviderFault struct {
+	Provider string
+	Path     string
+	Message  string
+}
+
+func (f *WSManFault) Error() string {
+	message := f.Message
+	if message == "" {
+		message = f.Reason
+	}
+	if f.ProviderFault != nil && f.ProviderFault.Message != "" {
+		message = strings.TrimSpace(message + " " + f.ProviderFault.Message)
+	}
+
+	code := f.Subcode
+	if code == "" {
+		code = f.Code
+	}
+	if f.FaultCode != 0 {
+		return fmt.Sprintf("wsman fault %d (%s): %s", f.FaultCode, code, message)
+	}
+	return fmt.Sprintf("wsman fault (%s): %s", code, message)
+}
+
+// ParseFault returns the fault held in the SOAP response, or nil when the response isn't a fault
+func ParseFault(response string) (*WSManFault, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+
+	isFault, err := any(doc, "//env:Body/env:Fault")
+	if err != nil || !isFault {
+		return nil, err
+	}
+
+	fault := &WSManFault{}
+	for _, field := range []struct {
+		value *string
+		xpath string
+	}{
+		{&fault.Code, "//env:Fault/env:Code/env:Value"},
+		{&fault.Subcode, "//env:Fault/env:Code/env:Subcode/env:Value"},
+		{&fault.Reason, "//env:Fault/env:Reason/env:Text"},
+		{&fault.Detail, "//env:Fault/env:Detail/w:FaultDetail"},
+	} {
+		value, err := first(doc, field.xpath)
+		if err != nil {
+			return nil, err
+		}
+		*field.value = strings.TrimSpace(value)
+	}
+	subcodes, err := xPath(doc, "//env:Fault/env:Code/env:Subcode/env:Value")
+	if err != nil {
+		return nil, err
+	}
+	if len(subcodes) > 0 {
+		fault.SubcodeName = qname(subcodes[0], fault.Subcode)
+	}
+
+	nodes, err := xPath(doc, "//env:Fault/env:Detail/f:WSManFault")
+	if err != nil || len(nodes) == 0 {
+		return fault, err
+	}
+	detail := nodes[0]
+	if code := attribute(detail, "Code"); code != "" {
+		faultCode, err := strconv.ParseUint(code, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid WSManFault code %q: %w", code, err)
+		}
+		fault.FaultCode = uint32(faultCode)
+	}
+	fault.Machine = attribute(detail, "Machine")
+
+	messages, err := xPath(detail, "f:Message")
+	if err != nil || len(messages) == 0 {
+		return fault, err
+	}
+	fault.Message = strings.TrimSpace(text(messages[0]))
+
+	providers, err := xPath(messages[0], "f:ProviderFault")
+	if err != nil || len(providers) == 0 {
+		return fault, err
+	}
+	fault.ProviderFault = &ProviderFault{
+		Provider: attribute(providers[0], "provider"),
+		Path:     attribute(providers[0], "path"),
+		Message:  strings.Join(strings.Fields(providers[0].ResValue()), " "),
+	}
+
+	return fault, nil
+}
+
+// responseError returns the error for a response with a non 200 status code,
+// the fault held in its body if any
+func responseError(statusCode int, body string) error {
+	if fault, err := ParseFault(body); err == nil && fault != nil {
+		return fault
+	}
+	return fmt.Errorf("http error %d: %s", statusCode, body)
+}
+
+// isEnvelopeSizeExceeded reports whether err is the fault of a request exceeding MaxEnvelopeSize
+func isEnvelopeSizeExceeded(err error) bool {
+	var fault *WSManFault
+	return errors.As(err, &fault) && fault.EnvelopeSizeExceeded()
+}
+
+// isOperationTimeout reports whether err is an operation timeout fault
+func isOperationTimeout(err error) bool {
+	var fault *WSManFault
+	return errors.As(err, &fault) && fault.Timeout()
+}
+
+// attribute returns the value of the attribute named name of node, or an empty string
+func attribute(node tree.Node, name string) string {
+	elem, ok := node.(tree.Elem)
+	if !ok {
+		return ""
+	}
+	start, _ := elem.GetToken().(xml.StartElement)
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// qname resolves the prefix of the QName value held by node, such as the w:TimedOut
+// subcode of a fault, against the namespaces in scope of node
+func qname(node tree.Node, value string) xml.Name {
+	prefix, local, ok := strings.Cut(value, ":")
+	if !ok {
+		prefix, local = "", value
+	}
+	key := xml.Name{Space: "xmlns", Local: prefix}
+	if prefix == "" {
+		key = xml.Name{Local: "xmlns"}
+	}
+	elem, ok := node.(tree.Elem)
+	if !ok {
+		return xml.Name{Local: local}
+	}
+	for _, ns := range tree.BuildNS(elem) {
+		if ns.Name == key {
+			return xml.Name{Space: ns.Value, Local: local}
+		}
+	}
+	return xml.Name{Local: local}
+}
+
+// text returns the text directly held by node, leaving out the one of its child elements
+func text(node tree.Node) string {
+	elem, ok := node.(tree.Elem)
+	if !ok {
+		return node.ResValue()
+	}
+	var buf strings.Builder
+	for _, child := range elem.GetChildren() {
+		if child.GetNodeType() == tree.NtChd {
+			buf.WriteString(child.ResValue())
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,94 @@
+package winrm
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"github.com/masterzen/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestParseFault(c *C) {
+	fault, err := ParseFault(operationTimeoutResponse)
+	c.Assert(err, IsNil)
+	c.Assert(fault, NotNil)
+	c.Check(fault.Code, Equals, "s:Receiver")
+	c.Check(fault.Subcode, Equals, "w:TimedOut")
+	c.Check(fault.SubcodeName, Equals, xml.Name{Space: "http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd", Local: "TimedOut"})
+	c.Check(fault.FaultCode, Equals, uint32(2150858793))
+	c.Check(fault.Machine, Equals, "127.0.0.1")
+	c.Check(fault.Message, Equals, "The WS-Management service cannot complete the operation within the time specified in OperationTimeout.")
+	c.Check(fault.ProviderFault, IsNil)
+	c.Check(fault.Error(), Equals, "wsman fault 2150858793 (w:TimedOut): The WS-Management service cannot complete the operation within the time specified in OperationTimeout.")
+}
+
+func (s *WinRMSuite) TestParseFaultProvider(c *C) {
+	fault, err := ParseFault(invalidMethodResponse)
+	c.Assert(err, IsNil)
+	c.Assert(fault, NotNil)
+	c.Check(fault.Code, Equals, "s:Sender")
+	c.Check(fault.Reason, Equals, "The action is not supported by the service.")
+	c.Check(fault.Machine, Equals, "win-host")
+	c.Check(fault.Message, Equals, "")
+	c.Check(fault.ProviderFault, DeepEquals, &ProviderFault{
+		Provider: "WMI Provider",
+		Path:     `%systemroot%\system32\WsmWmiPl.dll`,
+		Message:  "The method was not found.",
+	})
+	c.Check(fault.Error(), Equals, "wsman fault 2150858765 (w:ActionNotSupported): The action is not supported by the service. The method was not found.")
+}
+
+func (s *WinRMSuite) TestParseFaultNoFault(c *C) {
+	fault, err := ParseFault(createShellResponse)
+	c.Assert(err, IsNil)
+	c.Check(fault, IsNil)
+}
+
+func (s *WinRMSuite) TestFaultTimeout(c *C) {
+	c.Check((&WSManFault{SubcodeName: subcodeTimedOut}).Timeout(), Equals, true)
+	c.Check((&WSManFault{FaultCode: 2150858793}).Timeout(), Equals, true)
+	c.Check((&WSManFault{Subcode: "w:TimedOut"}).Timeout(), Equals, false)
+	c.Check((&WSManFault{SubcodeName: xml.Name{Space: soap.NS_WSMAN_DMTF, Local: "InvalidSelectors"}}).Timeout(), Equals, false)
+
+	c.Check(isOperationTimeout(&ExecuteCommandError{Inner: &WSManFault{SubcodeName: subcodeTimedOut}}), Equals, true)
+	c.Check(isOperationTimeout(responseError(500, "garbage")), Equals, false)
+}
+
+func (s *WinRMSuite) TestParseFaultSubcodePrefix(c *C) {
+	// the service may bind the WS-Management namespace to any prefix
+	response := strings.NewReplacer(`xmlns:w=`, `xmlns:wsman=`, `w:TimedOut`, `wsman:TimedOut`).Replace(operationTimeoutResponse)
+	fault, err := ParseFault(response)
+	c.Assert(err, IsNil)
+	c.Check(fault.Subcode, Equals, "wsman:TimedOut")
+	c.Check(fault.SubcodeName, Equals, subcodeTimedOut)
+	fault.FaultCode = 0
+	c.Check(fault.Timeout(), Equals, true)
+
+	// a w prefix bound to another namespace isn't a WS-Management subcode
+	response = strings.Replace(operationTimeoutResponse, `<s:Subcode>`, `<s:Subcode xmlns:w="urn:other">`, 1)
+	fault, err = ParseFault(response)
+	c.Assert(err, IsNil)
+	c.Check(fault.SubcodeName, Equals, xml.Name{Space: "urn:other", Local: "TimedOut"})
+	fault.FaultCode = 0
+	c.Check(fault.Timeout(), Equals, false)
+}
+
+func (s *WinRMSuite) TestFaultEnvelopeSizeExceeded(c *C) {
+	fault, err := ParseFault(maxEnvelopeSizeResponse)
+	c.Assert(err, IsNil)
+	c.Check(fault.Detail, Equals, "http://schemas.dmtf.org/wbem/wsman/1/wsman/faultDetail/MaxEnvelopeSize")
+	c.Check(fault.EnvelopeSizeExceeded(), Equals, true)
+	c.Check((&WSManFault{SubcodeName: subcodeEncodingLimit}).EnvelopeSizeExceeded(), Equals, true)
+	c.Check((&WSManFault{SubcodeName: subcodeEncodingLimit, Detail: "http://schemas.dmtf.org/wbem/wsman/1/wsman/faultDetail/OptionLimit"}).EnvelopeSizeExceeded(), Equals, false)
+	c.Check((&WSManFault{Subcode: "w:EncodingLimit"}).EnvelopeSizeExceeded(), Equals, false)
+
+	// the service may bind the WS-Management namespace to any prefix
+	response := strings.NewReplacer(`xmlns:w=`, `xmlns:wsman=`, `w:EncodingLimit`, `wsman:EncodingLimit`, `<w:FaultDetail>http://schemas.dmtf.org/wbem/wsman/1/wsman/faultDetail/MaxEnvelopeSize</w:FaultDetail>`, ``).Replace(maxEnvelopeSizeResponse)
+	fault, err = ParseFault(response)
+	c.Assert(err, IsNil)
+	c.Check(fault.Detail, Equals, "")
+	c.Check(fault.EnvelopeSizeExceeded(), Equals, true)
+
+	c.Check(isEnvelopeSizeExceeded(responseError(500, maxEnvelopeSizeResponse)), Equals, true)
+	c.Check(isEnvelopeSizeExceeded(responseError(500, operationTimeoutResponse)), Equals, false)
+}
@@ -1,6 +1,7 @@
 package winrm
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -85,10 +86,14 @@ func (c *clientRequest) Transport(endpoint *Endpoint) error {
 
 // Post make post to the winrm soap service
 func (c clientRequest) Post(client *Client, request *soap.SoapMessage) (string, error) {
+	return c.PostWithContext(context.Background(), client, request)
+}
+
+// PostWithContext make post to the winrm soap service, canceling the request with ctx
+func (c clientRequest) PostWithContext(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
 	httpClient := &http.Client{Transport: c.transport}
 
-	//nolint:noctx
-	req, err := http.NewRequest("POST", client.url, strings.NewReader(request.String()))
+	req, err := http.NewRequestWithContext(ctx, "POST", client.url, strings.NewReader(request.String()))
 	if err != nil {
 		return "", fmt.Errorf("impossible to create http request %w", err)
 	}
@@ -104,15 +109,11 @@ func (c clientRequest) Post(client *Client, request *soap.SoapMessage) (string,
 		return "", fmt.Errorf("http response error: %d - %w", resp.StatusCode, err)
 	}
 
-	// if we have different 200 http status code
-	// we must replace the error
-	defer func() {
-		if resp.StatusCode != 200 {
-			body, err = "", fmt.Errorf("http error %d: %s", resp.StatusCode, body)
-		}
-	}()
+	if resp.StatusCode != 200 {
+		return "", responseError(resp.StatusCode, body)
+	}
 
-	return body, err
+	return body, nil
 }
 
 // NewClientWithDial NewClientWithDial
@@ -1,6 +1,7 @@
 package winrm
 
 import (
+	"errors"
 	"net/http"
 
 	"net"
@@ -82,3 +83,40 @@ func (s *WinRMSuite) TestHttpViaCustomDialerRequest(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(usedCustomDialer, Equals, true)
 }
+
+func (s *WinRMSuite) TestHttpFault(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(operationTimeoutResponse))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+
+	_, err = client.CreateShell()
+	var fault *WSManFault
+	c.Assert(errors.As(err, &fault), Equals, true)
+	c.Check(fault.FaultCode, Equals, uint32(2150858793))
+	c.Check(fault.Machine, Equals, "127.0.0.1")
+	c.Check(fault.Timeout(), Equals, true)
+}
+
+func (s *WinRMSuite) TestHttpErrorStatus(c *C) {
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte("not a soap message"))
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "test", "test")
+	c.Assert(err, IsNil)
+
+	shell, err := client.CreateShell()
+	c.Assert(shell, IsNil)
+	c.Assert(err, ErrorMatches, "http error 502: not a soap message")
+}
@@ -0,0 +1,83 @@
+package winrm
+
+import (
+	"encoding/xml"
+	"fmt"
+
+	"github.com/ChrisTrenkamp/goxpath"
+	"github.com/ChrisTrenkamp/goxpath/tree"
+	"github.com/masterzen/winrm/soap"
+)
+
+// Instance is a WS-Management resource instance, such as a WMI object.
+// Its properties are flattened to the text content of the child elements,
+// a property appearing several times being an array.
+type Instance struct {
+	// XMLName is the qualified name of the instance element, e.g. Win32_Service
+	XMLName xml.Name
+	// Properties maps the local name of each property to its values.
+	// A property set to xsi:nil is present but has no value.
+	Properties map[string][]string
+	// XML is the instance element, with its namespace declarations
+	XML string
+}
+
+// Get returns the first value of the named property, or an empty string
+func (i *Instance) Get(name string) string {
+	if values := i.Properties[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns all the values of the named property
+func (i *Instance) Values(name string) []string {
+	return i.Properties[name]
+}
+
+// Unmarshal decodes the instance XML into v, see xml.Unmarshal
+func (i *Instance) Unmarshal(v interface{}) error {
+	return xml.Unmarshal([]byte(i.XML), v)
+}
+
+func newInstance(node tree.Node) (*Instance, error) {
+	elem, ok := node.(tree.Elem)
+	if !ok {
+		return nil, fmt.Errorf("node %s is not an element", node.ResValue())
+	}
+
+	start, _ := elem.GetToken().(xml.StartElement)
+	instance := &Instance{
+		XMLName:    start.Name,
+		Properties: make(map[string][]string),
+	}
+
+	for _, child := range elem.GetChildren() {
+		if child.GetNodeType() != tree.NtElem {
+			continue
+		}
+		property, _ := child.GetToken().(xml.StartElement)
+		name := property.Name.Local
+
+		if isNil(property) {
+			if _, ok := instance.Properties[name]; !ok {
+				instance.Properties[name] = []string{}
+			}
+			continue
+		}
+		instance.Properties[name] = append(instance.Properties[name], child.ResValue())
+	}
+
+	var err error
+	instance.XML, err = goxpath.MarshalStr(node)
+	return instance, err
+}
+
+func isNil(element xml.StartElement) bool {
+	for _, attr := range element.Attr {
+		if attr.Name.Space == soap.NS_SCHEMA_INST && attr.Name.Local == "nil" {
+			return attr.Value == "true"
+		}
+	}
+	return false
+}
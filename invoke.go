@@ -0,0 +1,58 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// InvokeResult is the outcome of a method invocation
+type InvokeResult struct {
+	// ReturnValue is the ReturnValue output parameter, 0 usually meaning success for WMI methods
+	ReturnValue int64
+	// Output holds all the output parameters, including ReturnValue
+	Output *Instance
+}
+
+// Invoke invokes method on the instance of resourceURI identified by selectors, or on the
+// class itself for static methods, with the given input parameters:
+//
+//	result, err := client.Invoke(ctx, "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service",
+//		map[string]string{"Name": "Spooler"}, "StopService", nil)
+//
+// A fault returned by the service, such as an unknown method, is a *WSManFault.
+// The ReturnValue of the method isn't checked, its meaning depending on the method.
+func (c *Client) Invoke(ctx context.Context, resourceURI string, selectors map[string]string, method string, parameters map[string]interface{}) (*InvokeResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := NewInvokeRequest(c.url, resourceURI, selectors, method, parameters, &c.Parameters)
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	fault, err := ParseFault(response)
+	if err != nil {
+		return nil, err
+	}
+	if fault != nil {
+		return nil, fault
+	}
+
+	output, err := ParseInvokeResponse(response, method)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &InvokeResult{Output: output}
+	if value := output.Get("ReturnValue"); value != "" {
+		if result.ReturnValue, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid ReturnValue %q: %w", value, err)
+		}
+	}
+	return result, nil
+}
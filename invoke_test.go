@@ -0,0 +1,123 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+
+	"github.com/masterzen/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+var stopServiceResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">
+	<s:Header>
+		<a:Action>http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service/StopServiceResponse</a:Action>
+		<a:MessageID>uuid:1A2B3C4D-0000-4000-8000-000000000005</a:MessageID>
+		<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>
+		<a:RelatesTo>uuid:AAAAAAAA-0000-4000-8000-000000000005</a:RelatesTo>
+	</s:Header>
+	<s:Body>
+		<p:StopService_OUTPUT xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service">
+			<p:ReturnValue>5</p:ReturnValue>
+		</p:StopService_OUTPUT>
+	</s:Body>
+</s:Envelope>`
+
+var createProcessResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing">
+	<s:Header>
+		<a:Action>http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Process/CreateResponse</a:Action>
+		<a:MessageID>uuid:1A2B3C4D-0000-4000-8000-000000000006</a:MessageID>
+		<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>
+		<a:RelatesTo>uuid:AAAAAAAA-0000-4000-8000-000000000006</a:RelatesTo>
+	</s:Header>
+	<s:Body>
+		<p:Create_OUTPUT xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Process">
+			<p:ProcessId>4242</p:ProcessId>
+			<p:ReturnValue>0</p:ReturnValue>
+		</p:Create_OUTPUT>
+	</s:Body>
+</s:Envelope>`
+
+var invalidMethodResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">
+	<s:Header>
+		<a:Action>http://schemas.dmtf.org/wbem/wsman/1/wsman/fault</a:Action>
+		<a:MessageID>uuid:1A2B3C4D-0000-4000-8000-000000000007</a:MessageID>
+		<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>
+		<a:RelatesTo>uuid:AAAAAAAA-0000-4000-8000-000000000007</a:RelatesTo>
+	</s:Header>
+	<s:Body>
+		<s:Fault>
+			<s:Code>
+				<s:Value>s:Sender</s:Value>
+				<s:Subcode><s:Value>w:ActionNotSupported</s:Value></s:Subcode>
+			</s:Code>
+			<s:Reason><s:Text xml:lang="en-US">The action is not supported by the service. </s:Text></s:Reason>
+			<s:Detail>
+				<f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="2150858765" Machine="win-host">
+					<f:Message>
+						<f:ProviderFault provider="WMI Provider" path="%systemroot%\system32\WsmWmiPl.dll">
+							<f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="2150858765" Machine="win-host">
+								<f:Message>The method was not found.</f:Message>
+							</f:WSManFault>
+						</f:ProviderFault>
+					</f:Message>
+				</f:WSManFault>
+			</s:Detail>
+		</s:Fault>
+	</s:Body>
+</s:Envelope>`
+
+func newInvokeClient(c *C, handler func(*soap.SoapMessage) (string, error)) *Client {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		return handler(message)
+	}
+	client.http = &r
+	return client
+}
+
+func (s *WinRMSuite) TestInvoke(c *C) {
+	client := newInvokeClient(c, func(message *soap.SoapMessage) (string, error) {
+		c.Check(message.String(), Contains, "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service/StopService</a:Action>")
+		c.Check(message.String(), Contains, `<w:Selector Name="Name">Spooler</w:Selector>`)
+		c.Check(message.String(), Contains, `<p:StopService_INPUT xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service"/>`)
+		return stopServiceResponse, nil
+	})
+
+	result, err := client.Invoke(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service",
+		map[string]string{"Name": "Spooler"}, "StopService", nil)
+	c.Assert(err, IsNil)
+	c.Check(result.ReturnValue, Equals, int64(5))
+	c.Check(result.Output.XMLName.Local, Equals, "StopService_OUTPUT")
+}
+
+func (s *WinRMSuite) TestInvokeWithParameters(c *C) {
+	client := newInvokeClient(c, func(message *soap.SoapMessage) (string, error) {
+		c.Check(message.String(), Contains, "<p:CommandLine>notepad.exe &amp; exit</p:CommandLine>")
+		return createProcessResponse, nil
+	})
+
+	result, err := client.Invoke(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Process",
+		nil, "Create", map[string]interface{}{"CommandLine": "notepad.exe & exit"})
+	c.Assert(err, IsNil)
+	c.Check(result.ReturnValue, Equals, int64(0))
+	c.Check(result.Output.Get("ProcessId"), Equals, "4242")
+}
+
+func (s *WinRMSuite) TestInvokeFault(c *C) {
+	client := newInvokeClient(c, func(message *soap.SoapMessage) (string, error) {
+		return invalidMethodResponse, nil
+	})
+
+	_, err := client.Invoke(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service",
+		map[string]string{"Name": "Spooler"}, "Explode", nil)
+	var fault *WSManFault
+	c.Assert(errors.As(err, &fault), Equals, true)
+	c.Check(fault.Subcode, Equals, "w:ActionNotSupported")
+	c.Check(fault.FaultCode, Equals, uint32(2150858765))
+	c.Check(fault.ProviderFault.Provider, Equals, "WMI Provider")
+	c.Check(fault.ProviderFault.Message, Equals, "The method was not found.")
+}
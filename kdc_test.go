@@ -0,0 +1,229 @@
+package winrm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/iana/patype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+	. "gopkg.in/check.v1"
+)
+
+const (
+	testRealm    = "EXAMPLE.COM"
+	testUser     = "winrm"
+	testPassword = "v3r1S3cre7"
+	testSPN      = "HTTP/winrm.example.com"
+	testEtype    = etypeID.AES256_CTS_HMAC_SHA1_96
+)
+
+// testKDC stands in for the KDC of testRealm, issuing the tickets of testUser, whose password
+// is testPassword, for the services of its keytab over TCP, without checking much else
+type testKDC struct {
+	listener net.Listener
+	keytab   *keytab.Keytab
+	// KrbConf is the path of the krb5.conf of the realm of the KDC
+	KrbConf string
+
+	mutex sync.Mutex
+	// asExchanges and tgsExchanges count the tickets issued
+	asExchanges, tgsExchanges int
+}
+
+func startTestKDC(c *C) *testKDC {
+	kt := keytab.New()
+	now := time.Now()
+	for principal, password := range map[string]string{
+		testUser:              testPassword,
+		"krbtgt/" + testRealm: "krbtgt secret",
+		testSPN:               "service secret",
+	} {
+		c.Assert(kt.AddEntry(principal, testRealm, password, now, 1, testEtype), IsNil)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	krbConf := filepath.Join(c.MkDir(), "krb5.conf")
+	c.Assert(os.WriteFile(krbConf, []byte(fmt.Sprintf(`[libdefaults]
+  default_realm = %[1]s
+  dns_lookup_kdc = false
+  dns_lookup_realm = false
+  udp_preference_limit = 1
+  default_tkt_enctypes = aes256-cts-hmac-sha1-96
+  default_tgs_enctypes = aes256-cts-hmac-sha1-96
+  permitted_enctypes = aes256-cts-hmac-sha1-96
+
+[realms]
+  %[1]s = {
+    kdc = %[2]s
+  }
+`, testRealm, listener.Addr())), 0o600), IsNil)
+
+	kdc := &testKDC{listener: listener, keytab: kt, KrbConf: krbConf}
+	go kdc.serve()
+	return kdc
+}
+
+// exchanges returns the numbers of AS and TGS exchanges served
+func (k *testKDC) exchanges() (int, int) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.asExchanges, k.tgsExchanges
+}
+
+func (k *testKDC) Close() {
+	_ = k.listener.Close()
+}
+
+func (k *testKDC) serve() {
+	for {
+		conn, err := k.listener.Accept()
+		if err != nil {
+			return
+		}
+		go k.handle(conn)
+	}
+}
+
+func (k *testKDC) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return
+	}
+	request := make([]byte, length)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return
+	}
+
+	var response []byte
+	var err error
+	switch request[0] & 0x1f {
+	case 10:
+		response, err = k.asExchange(request)
+	case 12:
+		response, err = k.tgsExchange(request)
+	default:
+		err = fmt.Errorf("unexpected KDC request %x", request[0])
+	}
+	if err != nil {
+		return
+	}
+	k.mutex.Lock()
+	if request[0]&0x1f == 10 {
+		k.asExchanges++
+	} else {
+		k.tgsExchanges++
+	}
+	k.mutex.Unlock()
+
+	_ = binary.Write(conn, binary.BigEndian, uint32(len(response)))
+	_, _ = conn.Write(response)
+}
+
+// issue returns the reply to a request for a ticket of the client cname to the service sname,
+// the encrypted part of the reply being encrypted with key and usage
+func (k *testKDC) issue(msgType int, body messages.KDCReqBody, cname types.PrincipalName, key types.EncryptionKey, usage uint32) (messages.KDCRepFields, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	end := now.Add(10 * time.Hour)
+	ticketFlags := asn1.BitString{Bytes: make([]byte, 4), BitLength: 32}
+
+	ticket, sessionKey, err := messages.NewTicket(cname, testRealm, body.SName, testRealm, ticketFlags, k.keytab, testEtype, 1, now, now, end, end)
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+
+	part := messages.EncKDCRepPart{
+		Key:       sessionKey,
+		LastReqs:  []messages.LastReq{},
+		Nonce:     body.Nonce,
+		Flags:     ticketFlags,
+		AuthTime:  now,
+		StartTime: now,
+		EndTime:   end,
+		RenewTill: end,
+		SRealm:    testRealm,
+		SName:     body.SName,
+	}
+	b, err := part.Marshal()
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+	encPart, err := crypto.GetEncryptedData(b, key, usage, 1)
+	if err != nil {
+		return messages.KDCRepFields{}, err
+	}
+
+	return messages.KDCRepFields{
+		PVNO:    5,
+		MsgType: msgType,
+		CRealm:  testRealm,
+		CName:   cname,
+		Ticket:  ticket,
+		EncPart: encPart,
+	}, nil
+}
+
+func (k *testKDC) asExchange(request []byte) ([]byte, error) {
+	var req messages.ASReq
+	if err := req.Unmarshal(request); err != nil {
+		return nil, err
+	}
+	userKey, _, err := k.keytab.GetEncryptionKey(req.ReqBody.CName, testRealm, 0, testEtype)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := k.issue(msgtype.KRB_AS_REP, req.ReqBody, req.ReqBody.CName, userKey, keyusage.AS_REP_ENCPART)
+	if err != nil {
+		return nil, err
+	}
+	rep := messages.ASRep{KDCRepFields: fields}
+	return rep.Marshal()
+}
+
+func (k *testKDC) tgsExchange(request []byte) ([]byte, error) {
+	var req messages.TGSReq
+	if err := req.Unmarshal(request); err != nil {
+		return nil, err
+	}
+
+	var apReq messages.APReq
+	for _, pa := range req.PAData {
+		if pa.PADataType == patype.PA_TGS_REQ {
+			if err := apReq.Unmarshal(pa.PADataValue); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := apReq.Ticket.DecryptEncPart(k.keytab, nil); err != nil {
+		return nil, err
+	}
+	tgt := apReq.Ticket.DecryptedEncPart
+
+	cname := req.ReqBody.CName
+	if len(cname.NameString) == 0 {
+		cname = tgt.CName
+	}
+	fields, err := k.issue(msgtype.KRB_TGS_REP, req.ReqBody, cname, tgt.Key, keyusage.TGS_REP_ENCPART_SESSION_KEY)
+	if err != nil {
+		return nil, err
+	}
+	rep := messages.TGSRep{KDCRepFields: fields}
+	return rep.Marshal()
+}
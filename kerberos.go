@@ -1,18 +1,23 @@
 package winrm
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/masterzen/winrm/soap"
 
+	"github.com/bodgit/ntlmssp"
 	"github.com/jcmturner/gokrb5/v8/client"
 	"github.com/jcmturner/gokrb5/v8/config"
 	"github.com/jcmturner/gokrb5/v8/credentials"
-	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/keytab"
 )
 
 // Settings holds all the information necessary to configure the provider
@@ -27,6 +32,7 @@ type Settings struct {
 	KrbConfig            string
 	KrbSpn               string
 	KrbCCache            string
+	KrbKeytab            string
 	WinRMUseNTLM         bool
 	WinRMPassCredentials bool
 }
@@ -42,6 +48,27 @@ type ClientKerberos struct {
 	SPN       string
 	KrbConf   string
 	KrbCCache string
+	KrbKeytab string
+
+	mutex sync.Mutex
+	// bindings are the channel bindings of the TLS connections to the server, once known
+	bindings *ntlmssp.ChannelBindings
+	// krb5Client is the client created from the files as they were at krb5Files
+	krb5Client *sharedKerberosClient
+	krb5Files  kerberosFiles
+}
+
+// sharedKerberosClient is a gokrb5 client used by concurrent posts, destroyed once it was
+// replaced and the last of its users released it
+type sharedKerberosClient struct {
+	*client.Client
+	users    int
+	replaced bool
+}
+
+// kerberosFiles are the modification times of the files a Kerberos client is created from
+type kerberosFiles struct {
+	conf, ccache, keytab time.Time
 }
 
 func NewClientKerberos(settings *Settings) *ClientKerberos {
@@ -54,59 +81,150 @@ func NewClientKerberos(settings *Settings) *ClientKerberos {
 		Proto:     settings.WinRMProto,
 		KrbConf:   settings.KrbConfig,
 		KrbCCache: settings.KrbCCache,
+		KrbKeytab: settings.KrbKeytab,
 		SPN:       settings.KrbSpn,
 	}
 }
 
-func (c *ClientKerberos) Transport(endpoint *Endpoint) error {
-	return c.clientRequest.Transport(endpoint)
+// kerberosClient returns the gokrb5 client of c, created once and kept with its tickets for
+// the following posts. A client logging in with a password or a keytab renews its
+// ticket-granting ticket before it expires, unlike the one of a credentials cache, which
+// holds no credentials to log in again: the cache must be refreshed externally, such as
+// with kinit. The client is created again when krb5.conf or the file of the credentials
+// changes. It must be released once used, the previous one being destroyed when the posts
+// still using it release it.
+func (c *ClientKerberos) kerberosClient() (*client.Client, func(), error) {
+	var files kerberosFiles
+	var err error
+	if files.conf, err = modTime(c.KrbConf); err != nil {
+		return nil, nil, err
+	}
+	if files.ccache, err = modTime(c.KrbCCache); err != nil {
+		return nil, nil, fmt.Errorf("unable to read ccache file %s: %w", c.KrbCCache, err)
+	}
+	if files.keytab, err = modTime(c.KrbKeytab); err != nil {
+		return nil, nil, fmt.Errorf("unable to read keytab file %s: %w", c.KrbKeytab, err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.krb5Client == nil || c.krb5Files != files {
+		kerberosClient, err := c.newKerberosClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		if previous := c.krb5Client; previous != nil {
+			previous.replaced = true
+			c.destroyUnused(previous)
+		}
+		c.krb5Client, c.krb5Files = &sharedKerberosClient{Client: kerberosClient}, files
+	}
+
+	shared := c.krb5Client
+	shared.users++
+	return shared.Client, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		shared.users--
+		c.destroyUnused(shared)
+	}, nil
 }
 
-func (c *ClientKerberos) Post(clt *Client, request *soap.SoapMessage) (string, error) {
+// destroyUnused destroys shared once replaced and no longer used, stopping the renewal of
+// its tickets. It must be called with c.mutex held.
+func (c *ClientKerberos) destroyUnused(shared *sharedKerberosClient) {
+	if shared.replaced && shared.users == 0 {
+		shared.Destroy()
+	}
+}
+
+// modTime returns the modification time of the file name, the zero time without name
+func modTime(name string) (time.Time, error) {
+	if name == "" {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// newKerberosClient returns a gokrb5 client of the krb5.conf of c, logging in with the
+// credentials cache of c, else its keytab, else its password
+func (c *ClientKerberos) newKerberosClient() (*client.Client, error) {
 	cfg, err := config.Load(c.KrbConf)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// setup the kerberos client
-	var kerberosClient *client.Client
 	if len(c.KrbCCache) > 0 {
 		b, err := os.ReadFile(c.KrbCCache)
 		if err != nil {
-			return "", fmt.Errorf("unable to read ccache file %s: %w", c.KrbCCache, err)
+			return nil, fmt.Errorf("unable to read ccache file %s: %w", c.KrbCCache, err)
 		}
 
 		cc := new(credentials.CCache)
 		err = cc.Unmarshal(b)
 		if err != nil {
-			return "", fmt.Errorf("unable to parse ccache file %s: %w", c.KrbCCache, err)
+			return nil, fmt.Errorf("unable to parse ccache file %s: %w", c.KrbCCache, err)
 		}
-		kerberosClient, err = client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
+		kerberosClient, err := client.NewFromCCache(cc, cfg, client.DisablePAFXFAST(true))
 		if err != nil {
-			return "", fmt.Errorf("unable to create kerberos client from ccache: %w", err)
+			return nil, fmt.Errorf("unable to create kerberos client from ccache: %w", err)
 		}
-	} else {
-		kerberosClient = client.NewWithPassword(c.Username, c.Realm, c.Password, cfg,
-			client.DisablePAFXFAST(true), client.AssumePreAuthentication(true))
+		return kerberosClient, nil
 	}
+	if len(c.KrbKeytab) > 0 {
+		kt, err := keytab.Load(c.KrbKeytab)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse keytab file %s: %w", c.KrbKeytab, err)
+		}
+		return client.NewWithKeytab(c.Username, c.Realm, kt, cfg,
+			client.DisablePAFXFAST(true), client.AssumePreAuthentication(true)), nil
+	}
+	return client.NewWithPassword(c.Username, c.Realm, c.Password, cfg,
+		client.DisablePAFXFAST(true), client.AssumePreAuthentication(true)), nil
+}
 
-	//create an http request
-	winrmURL := fmt.Sprintf("%s://%s:%d/wsman", c.Proto, c.Hostname, c.Port)
-	//nolint:noctx
-	winRMRequest, _ := http.NewRequest("POST", winrmURL, strings.NewReader(request.String()))
-	winRMRequest.Header.Add("Content-Type", "application/soap+xml;charset=UTF-8")
+func (c *ClientKerberos) Transport(endpoint *Endpoint) error {
+	return c.clientRequest.Transport(endpoint)
+}
 
-	err = spnego.SetSPNEGOHeader(kerberosClient, winRMRequest, c.SPN)
+func (c *ClientKerberos) Post(clt *Client, request *soap.SoapMessage) (string, error) {
+	return c.PostWithContext(context.Background(), clt, request)
+}
+
+// PostWithContext authenticates with Kerberos and makes the post, canceling the request with ctx.
+// Over HTTPS, the authentication is bound to the certificate of the server, for the servers
+// requiring channel binding tokens (CbtHardeningLevel).
+func (c *ClientKerberos) PostWithContext(ctx context.Context, clt *Client, request *soap.SoapMessage) (string, error) {
+	kerberosClient, release, err := c.kerberosClient()
 	if err != nil {
-		return "", fmt.Errorf("unable to set SPNego Header: %w", err)
+		return "", err
 	}
+	defer release()
 
-	httpClient := &http.Client{Transport: c.transport}
-
-	resp, err := httpClient.Do(winRMRequest)
+	bindings := c.channelBindings()
+	resp, err := c.post(ctx, kerberosClient, request, bindings)
 	if err != nil {
 		return "", err
 	}
+
+	// the certificate of the server is only known once connected, so the authentication
+	// may go without the bindings the server requires, or with the ones of a previous
+	// certificate of the server, and is made again bound to the current connection
+	if current := channelBindings(resp.TLS); !sameChannelBindings(bindings, current) {
+		bindings = current
+		c.setChannelBindings(bindings)
+		if resp.StatusCode == http.StatusUnauthorized {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp, err = c.post(ctx, kerberosClient, request, bindings); err != nil {
+				return "", err
+			}
+		}
+	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
@@ -114,6 +232,8 @@ func (c *ClientKerberos) Post(clt *Client, request *soap.SoapMessage) (string, e
 		respBody, err := io.ReadAll(resp.Body)
 		if err != nil {
 			bodyMsg = fmt.Sprintf("Error retrieving the response's body: %s", err)
+		} else if fault, err := ParseFault(string(respBody)); err == nil && fault != nil {
+			return "", fault
 		} else {
 			bodyMsg = fmt.Sprintf("Response body:\n%s", string(respBody))
 		}
@@ -126,3 +246,38 @@ func (c *ClientKerberos) Post(clt *Client, request *soap.SoapMessage) (string, e
 	}
 	return string(body), err
 }
+
+// post posts request with the AP-REQ of kerberosClient bound to the channel of bindings
+func (c *ClientKerberos) post(ctx context.Context, kerberosClient *client.Client, request *soap.SoapMessage, bindings *ntlmssp.ChannelBindings) (*http.Response, error) {
+	//create an http request
+	winrmURL := fmt.Sprintf("%s://%s:%d/wsman", c.Proto, c.Hostname, c.Port)
+	winRMRequest, _ := http.NewRequestWithContext(ctx, "POST", winrmURL, strings.NewReader(request.String()))
+	winRMRequest.Header.Add("Content-Type", "application/soap+xml;charset=UTF-8")
+
+	spn := c.SPN
+	if spn == "" {
+		spn = "HTTP/" + winRMRequest.URL.Hostname()
+	}
+	_, token, err := newKerberosInitiator(kerberosClient, spn, bindings)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set SPNego Header: %w", err)
+	}
+	winRMRequest.Header.Set("Authorization", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+
+	httpClient := &http.Client{Transport: c.transport}
+	return httpClient.Do(winRMRequest)
+}
+
+// channelBindings returns the channel bindings of the last connection to the server, nil
+// until connected over HTTPS
+func (c *ClientKerberos) channelBindings() *ntlmssp.ChannelBindings {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.bindings
+}
+
+func (c *ClientKerberos) setChannelBindings(bindings *ntlmssp.ChannelBindings) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.bindings = bindings
+}
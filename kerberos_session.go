@@ -0,0 +1,278 @@
+package winrm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bodgit/ntlmssp"
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/crypto/etype"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// RFC 4121 wrap tokens
+const (
+	wrapTokenID            = 0x0504
+	wrapTokenHeaderLength  = 16
+	wrapFlagSentByAcceptor = 0x01
+	wrapFlagSealed         = 0x02
+	wrapFlagAcceptorSubkey = 0x04
+)
+
+// kerberosContext is the security context established with a service by a Kerberos AP
+// exchange, sealing and unsealing messages as GSS_Wrap does (RFC 4121), laid out as
+// Windows does for the session encrypted messages of WinRM:
+// a header of the token header, the confounder and the rotated trailer, followed by
+// the encrypted data as long as the message.
+// Only the AES encryption types are supported.
+type kerberosContext struct {
+	initiator bool
+	key       types.EncryptionKey
+	etype     etype.EType
+	// acceptorSubkey is set when key is the subkey of the AP-REP
+	acceptorSubkey bool
+
+	mutex   sync.Mutex
+	sendSeq uint64
+
+	// sessionKey and authenticator are the ones of the AP-REQ, to complete the context
+	sessionKey    types.EncryptionKey
+	authenticator types.Authenticator
+}
+
+// newKerberosInitiator starts a security context with the service spn, such as
+// HTTP/host.example.com, bound to the channel of bindings when not nil, returning it with
+// the SPNEGO token of its AP-REQ
+func newKerberosInitiator(cl *client.Client, spn string, bindings *ntlmssp.ChannelBindings) (*kerberosContext, []byte, error) {
+	if err := cl.AffirmLogin(); err != nil {
+		return nil, nil, fmt.Errorf("kerberos login: %w", err)
+	}
+	ticket, sessionKey, err := cl.GetServiceTicket(spn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting kerberos service ticket for %s: %w", spn, err)
+	}
+	e, err := wrapEtype(sessionKey.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	authenticator, err := types.NewAuthenticator(cl.Credentials.Domain(), cl.Credentials.CName())
+	if err != nil {
+		return nil, nil, err
+	}
+	authenticator.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum: gssapiChecksum(gssapi.ContextFlagMutual|gssapi.ContextFlagReplay|gssapi.ContextFlagSequence|
+			gssapi.ContextFlagConf|gssapi.ContextFlagInteg, bindings),
+	}
+	if err := authenticator.GenerateSeqNumberAndSubKey(sessionKey.KeyType, e.GetKeyByteSize()); err != nil {
+		return nil, nil, err
+	}
+
+	apReq, err := messages.NewAPReq(ticket, sessionKey, authenticator)
+	if err != nil {
+		return nil, nil, err
+	}
+	types.SetFlag(&apReq.APOptions, flags.APOptionMutualRequired)
+	b, err := apReq.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the KRB5 mech token of the AP-REQ, RFC 1964 section 1.1
+	oid, err := asn1.Marshal(gssapi.OIDKRB5.OID())
+	if err != nil {
+		return nil, nil, err
+	}
+	mechToken := asn1tools.AddASNAppTag(append(append(oid, 0x01, 0x00), b...), 0)
+
+	token := spnego.SPNEGOToken{
+		Init: true,
+		NegTokenInit: spnego.NegTokenInit{
+			MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+			MechTokenBytes: mechToken,
+		},
+	}
+	tb, err := token.Marshal()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &kerberosContext{
+		initiator:     true,
+		key:           authenticator.SubKey,
+		etype:         e,
+		sendSeq:       uint64(authenticator.SeqNumber),
+		sessionKey:    sessionKey,
+		authenticator: authenticator,
+	}, tb, nil
+}
+
+// gssapiChecksum returns the checksum of the authenticator of a GSS-API AP-REQ requesting
+// the context flags on the channel of bindings, RFC 4121 section 4.1.1
+func gssapiChecksum(contextFlags uint32, bindings *ntlmssp.ChannelBindings) []byte {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b[:4], 16)
+	copy(b[4:20], channelBindingsHash(bindings))
+	binary.LittleEndian.PutUint32(b[20:24], contextFlags)
+	return b
+}
+
+func wrapEtype(keyType int32) (etype.EType, error) {
+	if keyType != etypeID.AES128_CTS_HMAC_SHA1_96 && keyType != etypeID.AES256_CTS_HMAC_SHA1_96 {
+		return nil, fmt.Errorf("kerberos encryption type %d not supported for message encryption, only AES", keyType)
+	}
+	return crypto.GetEtype(keyType)
+}
+
+// complete completes the context with token, the SPNEGO or KRB5 token of the AP-REP sent by
+// the service, authenticating the service
+func (k *kerberosContext) complete(token []byte) error {
+	mechToken := token
+	if len(token) > 0 && token[0] == 0xa1 {
+		var resp spnego.NegTokenResp
+		if err := resp.Unmarshal(token); err != nil {
+			return fmt.Errorf("invalid SPNEGO response: %w", err)
+		}
+		if resp.State() == spnego.NegStateReject {
+			return errors.New("kerberos authentication rejected by the service")
+		}
+		mechToken = resp.ResponseToken
+	}
+
+	var krb5Token spnego.KRB5Token
+	if err := krb5Token.Unmarshal(mechToken); err != nil {
+		return err
+	}
+	if krb5Token.IsKRBError() {
+		return krb5Token.KRBError
+	}
+	if !krb5Token.IsAPRep() {
+		return errors.New("kerberos response is not an AP-REP")
+	}
+
+	b, err := crypto.DecryptEncPart(krb5Token.APRep.EncPart, k.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return fmt.Errorf("decrypting AP-REP: %w", err)
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(b); err != nil {
+		return err
+	}
+	// the time of the authenticator is encoded to the second, its microseconds being in Cusec
+	if !part.CTime.Equal(k.authenticator.CTime.Truncate(time.Second)) || part.Cusec != k.authenticator.Cusec {
+		return errors.New("kerberos mutual authentication failed: AP-REP doesn't match the AP-REQ")
+	}
+
+	if len(part.Subkey.KeyValue) > 0 {
+		e, err := wrapEtype(part.Subkey.KeyType)
+		if err != nil {
+			return err
+		}
+		k.key, k.etype, k.acceptorSubkey = part.Subkey, e, true
+	}
+	return nil
+}
+
+// wrap seals message, returning the header and the encrypted data
+func (k *kerberosContext) wrap(message []byte) ([]byte, []byte, error) {
+	k.mutex.Lock()
+	seq := k.sendSeq
+	k.sendSeq++
+	k.mutex.Unlock()
+
+	header := make([]byte, wrapTokenHeaderLength)
+	binary.BigEndian.PutUint16(header[0:2], wrapTokenID)
+	header[2] = wrapFlagSealed
+	if !k.initiator {
+		header[2] |= wrapFlagSentByAcceptor
+	}
+	if k.acceptorSubkey {
+		header[2] |= wrapFlagAcceptorSubkey
+	}
+	header[3] = 0xff
+	binary.BigEndian.PutUint64(header[8:16], seq)
+
+	// the header is encrypted after the message, with a RRC of 0 and without filler
+	plaintext := make([]byte, 0, len(message)+len(header))
+	plaintext = append(append(plaintext, message...), header...)
+	_, ciphertext, err := k.etype.EncryptMessage(k.key.KeyValue, plaintext, k.usage(k.initiator))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// rotating the encrypted header and checksum to the front leaves the encrypted message
+	// at the end, Windows expecting the data as long as the message
+	rrc := wrapTokenHeaderLength + k.etype.GetHMACBitLength()/8
+	binary.BigEndian.PutUint16(header[6:8], uint16(rrc))
+	token := append(header, rotateRight(ciphertext, rrc)...)
+
+	split := len(token) - len(message)
+	return token[:split], token[split:], nil
+}
+
+// unwrap unseals the message of header and data sealed by the peer
+func (k *kerberosContext) unwrap(header, data []byte) ([]byte, error) {
+	token := make([]byte, 0, len(header)+len(data))
+	token = append(append(token, header...), data...)
+	if len(token) < wrapTokenHeaderLength || binary.BigEndian.Uint16(token[0:2]) != wrapTokenID {
+		return nil, errors.New("invalid kerberos wrap token")
+	}
+	tokenFlags := token[2]
+	if tokenFlags&wrapFlagSealed == 0 {
+		return nil, errors.New("kerberos wrap token is not sealed")
+	}
+	if (tokenFlags&wrapFlagSentByAcceptor != 0) != k.initiator {
+		return nil, errors.New("kerberos wrap token sent in the wrong direction")
+	}
+
+	ec := int(binary.BigEndian.Uint16(token[4:6]))
+	rrc := int(binary.BigEndian.Uint16(token[6:8]))
+	ciphertext := token[wrapTokenHeaderLength:]
+	if len(ciphertext) == 0 {
+		return nil, errors.New("kerberos wrap token is empty")
+	}
+	// Windows rotates by the filler length too, see MS-KILE 3.4.5.4.1
+	ciphertext = rotateRight(ciphertext, len(ciphertext)-(rrc+ec)%len(ciphertext))
+
+	plaintext, err := k.etype.DecryptMessage(k.key.KeyValue, ciphertext, k.usage(!k.initiator))
+	if err != nil {
+		return nil, fmt.Errorf("unsealing kerberos wrap token: %w", err)
+	}
+	if len(plaintext) < ec+wrapTokenHeaderLength {
+		return nil, errors.New("kerberos wrap token too short")
+	}
+	encryptedHeader := plaintext[len(plaintext)-wrapTokenHeaderLength:]
+	if !bytes.Equal(encryptedHeader[0:6], token[0:6]) || !bytes.Equal(encryptedHeader[8:], token[8:16]) {
+		return nil, errors.New("kerberos wrap token header doesn't match its encrypted copy")
+	}
+	return plaintext[:len(plaintext)-ec-wrapTokenHeaderLength], nil
+}
+
+// usage returns the key usage of the tokens sealed by the initiator, or by the acceptor
+func (k *kerberosContext) usage(initiator bool) uint32 {
+	if initiator {
+		return keyusage.GSSAPI_INITIATOR_SEAL
+	}
+	return keyusage.GSSAPI_ACCEPTOR_SEAL
+}
+
+func rotateRight(b []byte, n int) []byte {
+	n %= len(b)
+	rotated := make([]byte, 0, len(b))
+	return append(append(rotated, b[len(b)-n:]...), b[:len(b)-n]...)
+}
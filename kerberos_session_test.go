@@ -0,0 +1,76 @@
+package winrm
+
+import (
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/types"
+	. "gopkg.in/check.v1"
+)
+
+func kerberosContexts(c *C) (*kerberosContext, *kerberosContext) {
+	e, err := crypto.GetEtype(etypeID.AES256_CTS_HMAC_SHA1_96)
+	c.Assert(err, IsNil)
+	key, err := types.GenerateEncryptionKey(e)
+	c.Assert(err, IsNil)
+	initiator := &kerberosContext{initiator: true, key: key, etype: e, acceptorSubkey: true, sendSeq: 7}
+	acceptor := &kerberosContext{key: key, etype: e, acceptorSubkey: true, sendSeq: 42}
+	return initiator, acceptor
+}
+
+func (s *WinRMSuite) TestKerberosWrap(c *C) {
+	initiator, acceptor := kerberosContexts(c)
+	message := []byte("<env:Envelope>message</env:Envelope>")
+
+	header, data, err := initiator.wrap(message)
+	c.Assert(err, IsNil)
+	c.Check(header, HasLen, 60)
+	c.Check(data, HasLen, len(message))
+	c.Check(header[2], Equals, byte(wrapFlagSealed|wrapFlagAcceptorSubkey))
+
+	unwrapped, err := acceptor.unwrap(header, data)
+	c.Assert(err, IsNil)
+	c.Check(string(unwrapped), Equals, string(message))
+
+	header, data, err = acceptor.wrap([]byte("reply"))
+	c.Assert(err, IsNil)
+	unwrapped, err = initiator.unwrap(header, data)
+	c.Assert(err, IsNil)
+	c.Check(string(unwrapped), Equals, "reply")
+}
+
+func (s *WinRMSuite) TestKerberosWrapSequence(c *C) {
+	initiator, acceptor := kerberosContexts(c)
+
+	first, _, err := initiator.wrap([]byte("a"))
+	c.Assert(err, IsNil)
+	second, _, err := initiator.wrap([]byte("b"))
+	c.Assert(err, IsNil)
+	c.Check(first[15], Equals, byte(7))
+	c.Check(second[15], Equals, byte(8))
+
+	// a token is only unwrapped by the peer
+	header, data, err := acceptor.wrap([]byte("reply"))
+	c.Assert(err, IsNil)
+	_, err = acceptor.unwrap(header, data)
+	c.Assert(err, ErrorMatches, ".*wrong direction")
+}
+
+func (s *WinRMSuite) TestKerberosUnwrapTampered(c *C) {
+	initiator, acceptor := kerberosContexts(c)
+
+	header, data, err := initiator.wrap([]byte("message"))
+	c.Assert(err, IsNil)
+	data[0] ^= 0xff
+	_, err = acceptor.unwrap(header, data)
+	c.Assert(err, ErrorMatches, "unsealing kerberos wrap token: .*")
+
+	_, err = acceptor.unwrap([]byte("header"), nil)
+	c.Assert(err, ErrorMatches, "invalid kerberos wrap token")
+}
+
+func (s *WinRMSuite) TestKerberosWrapEtype(c *C) {
+	_, err := wrapEtype(etypeID.RC4_HMAC)
+	c.Assert(err, ErrorMatches, "kerberos encryption type 23 not supported .*")
+	_, err = wrapEtype(etypeID.AES128_CTS_HMAC_SHA1_96)
+	c.Assert(err, IsNil)
+}
@@ -0,0 +1,286 @@
+package winrm
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+
+	. "gopkg.in/check.v1"
+)
+
+// negotiateServer stands in for a WinRM service authenticating each request with Kerberos,
+// answering the authenticated ones with response
+type negotiateServer struct {
+	kdc      *testKDC
+	response string
+	// channelBindings, when set, is the hash of the channel bindings required
+	channelBindings []byte
+
+	mutex           sync.Mutex
+	authentications int
+	rejections      int
+}
+
+func (s *negotiateServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	token, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Negotiate "))
+	if err == nil {
+		token, _, err = acceptKerberos(s.kdc, token, s.channelBindings)
+	}
+	if err != nil {
+		s.rejections++
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.authentications++
+	w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(token))
+	w.Header().Set("Content-Type", "application/soap+xml")
+	_, _ = w.Write([]byte(s.response))
+}
+
+// testSettings returns the settings of testUser authenticating with kdc
+func testSettings(kdc *testKDC) Settings {
+	return Settings{
+		WinRMUsername: testUser,
+		WinRMPassword: testPassword,
+		KrbRealm:      testRealm,
+		KrbConfig:     kdc.KrbConf,
+		KrbSpn:        testSPN,
+	}
+}
+
+// negotiateClient returns a client of the server ts authenticating with ClientKerberos
+// configured by settings
+func negotiateClient(c *C, ts *httptest.Server, settings Settings) *Client {
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+	https := strings.HasPrefix(ts.URL, "https:")
+	settings.WinRMHost, settings.WinRMPort, settings.WinRMProto = host, port, "http"
+	if https {
+		settings.WinRMProto = "https"
+	}
+
+	params := *DefaultParameters
+	params.TransportDecorator = func() Transporter { return NewClientKerberos(&settings) }
+	client, err := NewClientWithParameters(NewEndpoint(host, port, https, true, nil, nil, nil, 0), testUser, testPassword, &params)
+	c.Assert(err, IsNil)
+	return client
+}
+
+func (s *WinRMSuite) TestKerberosClientReused(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	server := &negotiateServer{kdc: kdc, response: response}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+	client := negotiateClient(c, ts, testSettings(kdc))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.CreateShell()
+		c.Assert(err, IsNil)
+	}
+
+	asExchanges, tgsExchanges := kdc.exchanges()
+	c.Check(asExchanges, Equals, 1)
+	c.Check(tgsExchanges, Equals, 1)
+	c.Check(server.authentications, Equals, 3)
+}
+
+func (s *WinRMSuite) TestKerberosClientReloadsConfig(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	ts := httptest.NewServer(&negotiateServer{kdc: kdc, response: response})
+	defer ts.Close()
+	client := negotiateClient(c, ts, testSettings(kdc))
+
+	_, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	modified := time.Now().Add(time.Minute)
+	c.Assert(os.Chtimes(kdc.KrbConf, modified, modified), IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+	_, err = client.CreateShell()
+	c.Assert(err, IsNil)
+
+	asExchanges, tgsExchanges := kdc.exchanges()
+	c.Check(asExchanges, Equals, 2)
+	c.Check(tgsExchanges, Equals, 2)
+}
+
+func (s *WinRMSuite) TestKerberosClientReloadsConfigConcurrently(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	ts := httptest.NewServer(&negotiateServer{kdc: kdc, response: response})
+	defer ts.Close()
+	client := negotiateClient(c, ts, testSettings(kdc))
+
+	const workers, posts = 4, 5
+	errs := make(chan error, workers*posts)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < posts; j++ {
+				_, err := client.CreateShell()
+				errs <- err
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	// krb5.conf keeps changing while posting, each post replacing the client of the others
+	for i := 1; ; i++ {
+		select {
+		case <-done:
+			close(errs)
+			for err := range errs {
+				c.Check(err, IsNil)
+			}
+			return
+		default:
+		}
+		modified := time.Now().Add(time.Duration(i) * time.Minute)
+		c.Assert(os.Chtimes(kdc.KrbConf, modified, modified), IsNil)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *WinRMSuite) TestKerberosClientDestroyedOnceReleased(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	settings := testSettings(kdc)
+	kerberos := NewClientKerberos(&settings)
+
+	first, release, err := kerberos.kerberosClient()
+	c.Assert(err, IsNil)
+	modified := time.Now().Add(time.Minute)
+	c.Assert(os.Chtimes(kdc.KrbConf, modified, modified), IsNil)
+	second, releaseSecond, err := kerberos.kerberosClient()
+	c.Assert(err, IsNil)
+	defer releaseSecond()
+	c.Assert(second, Not(Equals), first)
+
+	// the replaced client is kept until its last user is done with it
+	c.Check(first.Credentials.UserName(), Equals, testUser)
+	release()
+	c.Check(first.Credentials.UserName(), Equals, "")
+	c.Check(second.Credentials.UserName(), Equals, testUser)
+}
+
+func (s *WinRMSuite) TestKerberosKeytab(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	ts := httptest.NewServer(&negotiateServer{kdc: kdc, response: response})
+	defer ts.Close()
+
+	kt := keytab.New()
+	for _, e := range []int32{etypeID.AES128_CTS_HMAC_SHA1_96, etypeID.AES256_CTS_HMAC_SHA1_96} {
+		c.Assert(kt.AddEntry(testUser, testRealm, testPassword, time.Now(), 1, e), IsNil)
+	}
+	b, err := kt.Marshal()
+	c.Assert(err, IsNil)
+	settings := testSettings(kdc)
+	settings.WinRMPassword = ""
+	settings.KrbKeytab = filepath.Join(c.MkDir(), "winrm.keytab")
+	c.Assert(os.WriteFile(settings.KrbKeytab, b, 0o600), IsNil)
+	client := negotiateClient(c, ts, settings)
+
+	shell, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Check(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+}
+
+func (s *WinRMSuite) TestKerberosKeytabMissing(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	ts := httptest.NewServer(&negotiateServer{kdc: kdc, response: response})
+	defer ts.Close()
+
+	settings := testSettings(kdc)
+	settings.KrbKeytab = filepath.Join(c.MkDir(), "missing.keytab")
+	client := negotiateClient(c, ts, settings)
+
+	_, err := client.CreateShell()
+	c.Assert(err, ErrorMatches, "unable to read keytab file .*")
+}
+
+func (s *WinRMSuite) TestHttpsKerberosChannelBinding(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	server := &negotiateServer{kdc: kdc, response: response}
+	ts := httptest.NewTLSServer(server)
+	defer ts.Close()
+	server.channelBindings = testChannelBindings(ts.Certificate())
+	client := negotiateClient(c, ts, testSettings(kdc))
+
+	for i := 0; i < 2; i++ {
+		shell, err := client.CreateShell()
+		c.Assert(err, IsNil)
+		c.Check(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	c.Check(server.authentications, Equals, 2)
+	// only the first authentication goes without the bindings, unknown until connected
+	c.Check(server.rejections, Equals, 1)
+}
+
+func (s *WinRMSuite) TestHttpsKerberosChannelBindingRenewedCertificate(c *C) {
+	kdc := startTestKDC(c)
+	defer kdc.Close()
+	server := &negotiateServer{kdc: kdc, response: response}
+	ts := httptest.NewUnstartedServer(server)
+	var cert atomic.Pointer[tls.Certificate]
+	ts.TLS = &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return &tls.Config{Certificates: []tls.Certificate{*cert.Load()}}, nil
+	}}
+	first := testCertificate(c)
+	cert.Store(&first)
+	server.channelBindings = testChannelBindings(first.Leaf)
+	// every request makes a new connection, getting the current certificate
+	ts.Config.SetKeepAlivesEnabled(false)
+	ts.StartTLS()
+	defer ts.Close()
+	client := negotiateClient(c, ts, testSettings(kdc))
+
+	_, err := client.CreateShell()
+	c.Assert(err, IsNil)
+
+	// the server is given a new certificate, the bindings known by the client going stale
+	second := testCertificate(c)
+	cert.Store(&second)
+	server.mutex.Lock()
+	server.channelBindings = testChannelBindings(second.Leaf)
+	server.mutex.Unlock()
+
+	for i := 0; i < 2; i++ {
+		shell, err := client.CreateShell()
+		c.Assert(err, IsNil)
+		c.Check(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	c.Check(server.authentications, Equals, 3)
+	// the first authentications without bindings and with the stale ones are made again
+	c.Check(server.rejections, Equals, 2)
+}
@@ -0,0 +1,114 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/masterzen/winrm/clixml"
+)
+
+// ResourceURIConfig is the resource URI of the WinRM configuration, winrm/config
+const ResourceURIConfig = "http://schemas.microsoft.com/wbem/wsman/1/config"
+
+// Identity describes the WS-Management service, see Identify
+type Identity struct {
+	ProtocolVersion string
+	ProductVendor   string
+	// ProductVersion is the version of the OS and of the WS-Management stack,
+	// e.g. OS: 10.0.17763 SP: 0.0 Stack: 3.0
+	ProductVersion string
+}
+
+// StackVersion returns the version of the WS-Management stack, e.g. 3.0, or an empty string
+// when ProductVersion doesn't hold it
+func (i *Identity) StackVersion() string {
+	fields := strings.Fields(i.ProductVersion)
+	for j, field := range fields {
+		if field == "Stack:" && j+1 < len(fields) {
+			return fields[j+1]
+		}
+	}
+	return ""
+}
+
+// Identify asks the service to identify itself, which any user allowed to connect may do
+func (c *Client) Identify(ctx context.Context) (*Identity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := NewIdentifyRequest()
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseIdentifyResponse(response)
+}
+
+// NegotiateParameters sets the EnvelopeSize of the Parameters of this client to the
+// MaxEnvelopeSizekb the server is configured with, and lowers their Timeout to its
+// MaxTimeoutms, so that tuned servers take larger requests, such as the input of
+// commands and file copies, in fewer round trips.
+//
+// Reading winrm/config requires an administrator. When the server denies it, the
+// EnvelopeSize is only lowered to the default MaxEnvelopeSizekb of the version of its
+// WS-Management stack, as given by Identify, since the actual configuration is unknown.
+// The Parameters are left as is for an unknown version.
+//
+// It is not done unless asked, and must be called before the client is used by other goroutines.
+func (c *Client) NegotiateParameters(ctx context.Context) error {
+	config, err := c.Get(ctx, ResourceURIConfig, nil, nil)
+	if err == nil {
+		return c.applyConfig(config)
+	}
+	var fault *WSManFault
+	if !errors.As(err, &fault) {
+		return err
+	}
+
+	identity, err := c.Identify(ctx)
+	if err != nil {
+		return err
+	}
+	if size, ok := defaultEnvelopeSizes[identity.StackVersion()]; ok {
+		c.Parameters.EnvelopeSize = min(c.Parameters.EnvelopeSize, size)
+	}
+	return nil
+}
+
+// defaultEnvelopeSizes are the default MaxEnvelopeSizekb, in bytes, of the versions of the
+// WS-Management stack
+var defaultEnvelopeSizes = map[string]int{
+	"1.1": 150 * 1024,
+	"2.0": 500 * 1024,
+	"3.0": 500 * 1024,
+}
+
+func (c *Client) applyConfig(config *Instance) error {
+	if value := config.Get("MaxEnvelopeSizekb"); value != "" {
+		size, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("parsing MaxEnvelopeSizekb: %w", err)
+		}
+		c.Parameters.EnvelopeSize = size * 1024
+	}
+
+	if value := config.Get("MaxTimeoutms"); value != "" {
+		ms, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("parsing MaxTimeoutms: %w", err)
+		}
+		maxTimeout := time.Duration(ms) * time.Millisecond
+		if timeout, err := parseDuration(c.Parameters.Timeout); err != nil || timeout > maxTimeout {
+			c.Parameters.Timeout = clixml.FormatDuration(maxTimeout)
+		}
+	}
+	return nil
+}
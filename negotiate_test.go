@@ -0,0 +1,121 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/masterzen/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+var identifyResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope">
+	<s:Header/>
+	<s:Body>
+		<wsmid:IdentifyResponse xmlns:wsmid="http://schemas.dmtf.org/wbem/wsman/identity/1/wsmanidentity.xsd">
+			<wsmid:ProtocolVersion>http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd</wsmid:ProtocolVersion>
+			<wsmid:ProductVendor>Microsoft Corporation</wsmid:ProductVendor>
+			<wsmid:ProductVersion>OS: 10.0.17763 SP: 0.0 Stack: 3.0</wsmid:ProductVersion>
+		</wsmid:IdentifyResponse>
+	</s:Body>
+</s:Envelope>`
+
+var accessDeniedResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd"><s:Header><a:Action>http://schemas.dmtf.org/wbem/wsman/1/wsman/fault</a:Action><a:MessageID>uuid:5F3A1D2C-7B6E-4C1A-9E8D-2A4B6C8D0E1F</a:MessageID></s:Header><s:Body><s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>w:AccessDenied</s:Value></s:Subcode></s:Code><s:Reason><s:Text xml:lang="en-US">Access is denied. </s:Text></s:Reason><s:Detail><f:WSManFault xmlns:f="http://schemas.microsoft.com/wbem/wsman/1/wsmanfault" Code="5" Machine="127.0.0.1"><f:Message>Access is denied. </f:Message></f:WSManFault></s:Detail></s:Fault></s:Body></s:Envelope>`
+
+// negotiateRequester answers Get requests of winrm/config with config, a fault response,
+// and Identify requests with identifyResponse, recording the requests
+func negotiateRequester(c *C, client *Client, config string) func() []string {
+	var requests []string
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "transfer/Get<"):
+			c.Assert(body, Contains, ResourceURIConfig+"<")
+			requests = append(requests, "Get")
+			if fault, _ := ParseFault(config); fault != nil {
+				return "", fault
+			}
+			return config, nil
+		case strings.Contains(body, "Identify"):
+			requests = append(requests, "Identify")
+			return identifyResponse, nil
+		}
+		c.Errorf("unexpected request %s", body)
+		return "", nil
+	}
+	client.http = &r
+	return func() []string { return requests }
+}
+
+func (s *WinRMSuite) TestNegotiateParameters(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", NewParameters("PT120S", "en-US", 153600))
+	c.Assert(err, IsNil)
+	requests := negotiateRequester(c, client, getConfigResponse)
+
+	c.Assert(client.NegotiateParameters(context.Background()), IsNil)
+	c.Check(requests(), DeepEquals, []string{"Get"})
+	c.Check(client.Parameters.EnvelopeSize, Equals, 500*1024)
+	c.Check(client.Parameters.Timeout, Equals, "PT1M")
+	c.Check(DefaultParameters.EnvelopeSize, Equals, 153600)
+}
+
+func (s *WinRMSuite) TestNegotiateParametersKeepsShorterTimeout(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", NewParameters("PT20S", "en-US", 153600))
+	c.Assert(err, IsNil)
+	negotiateRequester(c, client, getConfigResponse)
+
+	c.Assert(client.NegotiateParameters(context.Background()), IsNil)
+	c.Check(client.Parameters.Timeout, Equals, "PT20S")
+}
+
+func (s *WinRMSuite) TestNegotiateParametersIdentify(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "user", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	requests := negotiateRequester(c, client, accessDeniedResponse)
+
+	c.Assert(client.NegotiateParameters(context.Background()), IsNil)
+	c.Check(requests(), DeepEquals, []string{"Get", "Identify"})
+	c.Check(client.Parameters.EnvelopeSize, Equals, 153600)
+	c.Check(client.Parameters.Timeout, Equals, "PT60S")
+
+	client.Parameters.EnvelopeSize = 1024 * 1024
+	c.Assert(client.NegotiateParameters(context.Background()), IsNil)
+	c.Check(client.Parameters.EnvelopeSize, Equals, 500*1024)
+}
+
+func (s *WinRMSuite) TestNegotiateParametersTransportError(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		return "", errors.New("connection refused")
+	}
+	client.http = &r
+
+	c.Assert(client.NegotiateParameters(context.Background()), ErrorMatches, "connection refused")
+	c.Check(client.Parameters.EnvelopeSize, Equals, 153600)
+}
+
+func (s *WinRMSuite) TestIdentify(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	negotiateRequester(c, client, getConfigResponse)
+
+	identity, err := client.Identify(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(identity.ProtocolVersion, Equals, "http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd")
+	c.Check(identity.ProductVendor, Equals, "Microsoft Corporation")
+	c.Check(identity.StackVersion(), Equals, "3.0")
+
+	c.Check((&Identity{ProductVersion: "OS: 5.2.3790 SP: 2.0 Stack: 1.1"}).StackVersion(), Equals, "1.1")
+	c.Check((&Identity{ProductVersion: "Openwsman 2.6"}).StackVersion(), Equals, "")
+
+	_, err = ParseIdentifyResponse(createShellResponse)
+	c.Assert(err, ErrorMatches, "invalid identify response.*")
+}
@@ -1,11 +1,16 @@
 package winrm
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 
-	"github.com/Azure/go-ntlmssp"
+	"github.com/bodgit/ntlmssp"
 	"github.com/masterzen/winrm/soap"
 )
 
@@ -19,15 +24,119 @@ func (c *ClientNTLM) Transport(endpoint *Endpoint) error {
 	if err := c.clientRequest.Transport(endpoint); err != nil {
 		return err
 	}
-	c.clientRequest.transport = &ntlmssp.Negotiator{RoundTripper: c.clientRequest.transport}
+	c.clientRequest.transport = &ntlmNegotiator{RoundTripper: c.clientRequest.transport}
 	return nil
 }
 
+// ntlmNegotiator is a http.RoundTripper decorator converting the basic authentication of the
+// requests to NTLM when the server asks for it. Over HTTPS, the authentication is bound to
+// the certificate of the server, for the servers requiring channel binding tokens
+// (CbtHardeningLevel).
+type ntlmNegotiator struct {
+	http.RoundTripper
+}
+
+// RoundTrip sends req, authenticating with the NTLM handshake on the same connection
+func (n *ntlmNegotiator) RoundTrip(req *http.Request) (*http.Response, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return n.RoundTripper.RoundTrip(req)
+	}
+	basic := req.Header.Get("Authorization")
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	send := func(authorization string) (*http.Response, error) {
+		r := req.Clone(req.Context())
+		r.Header.Del("Authorization")
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		if req.Body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		return n.RoundTripper.RoundTrip(r)
+	}
+
+	// the server may still know the connection as authenticated
+	res, err := send("")
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	scheme, _ := ntlmChallenge(res)
+	if scheme == "" {
+		drain(res)
+		if res, err = send(basic); err != nil || res.StatusCode != http.StatusUnauthorized {
+			return res, err
+		}
+		if scheme, _ = ntlmChallenge(res); scheme == "" {
+			return res, nil
+		}
+	}
+	drain(res)
+
+	user, domain := splitUsername(username)
+	client, err := ntlmssp.NewClient(ntlmssp.SetUserInfo(user, password), ntlmssp.SetDomain(domain), ntlmssp.SetVersion(ntlmssp.DefaultVersion()))
+	if err != nil {
+		return nil, err
+	}
+	negotiate, err := client.Authenticate(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res, err = send(scheme + " " + base64.StdEncoding.EncodeToString(negotiate)); err != nil {
+		return nil, err
+	}
+	challengeScheme, challenge := ntlmChallenge(res)
+	if challengeScheme == "" || len(challenge) == 0 {
+		// let the caller deal with the failed negotiation
+		return res, nil
+	}
+	drain(res)
+
+	authenticate, err := client.Authenticate(challenge, channelBindings(res.TLS))
+	if err != nil {
+		return nil, err
+	}
+	return send(scheme + " " + base64.StdEncoding.EncodeToString(authenticate))
+}
+
+// ntlmChallenge returns the scheme, Negotiate or NTLM, and the token of the authentication
+// res asks for, or an empty scheme when it is another one
+func ntlmChallenge(res *http.Response) (string, []byte) {
+	for _, header := range res.Header.Values("WWW-Authenticate") {
+		scheme, value, _ := strings.Cut(header, " ")
+		if strings.EqualFold(scheme, "Negotiate") || strings.EqualFold(scheme, "NTLM") {
+			token, _ := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+			return scheme, token
+		}
+	}
+	return "", nil
+}
+
+// drain reads and closes the body of res, keeping its connection for the next request
+func drain(res *http.Response) {
+	_, _ = io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+}
+
 // Post make post to the winrm soap service (forwarded to clientRequest implementation)
 func (c ClientNTLM) Post(client *Client, request *soap.SoapMessage) (string, error) {
 	return c.clientRequest.Post(client, request)
 }
 
+// PostWithContext make post to the winrm soap service, canceling the request with ctx
+// (forwarded to clientRequest implementation)
+func (c ClientNTLM) PostWithContext(ctx context.Context, client *Client, request *soap.SoapMessage) (string, error) {
+	return c.clientRequest.PostWithContext(ctx, client, request)
+}
+
 //NewClientNTLMWithDial NewClientNTLMWithDial
 func NewClientNTLMWithDial(dial func(network, addr string) (net.Conn, error)) *ClientNTLM {
 	return &ClientNTLM{
@@ -1,7 +1,11 @@
 package winrm
 
 import (
+	"encoding/base64"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 
 	"net"
 	"time"
@@ -55,3 +59,103 @@ func (s *WinRMSuite) TestHttpNTLMViaCustomDialerRequest(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(usedCustomDialer, Equals, true)
 }
+
+// ntlmServer stands in for a WinRM service authenticating the requests with NTLM, answering
+// the authenticated ones with response
+type ntlmServer struct {
+	response string
+	// channelBindings, when set, is the hash of the channel bindings required
+	channelBindings []byte
+
+	mutex sync.Mutex
+	// pending are the authentications in progress, by remote address
+	pending         map[string]*ntlmAcceptor
+	authentications int
+}
+
+func (s *ntlmServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	scheme, value, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token, err := base64.StdEncoding.DecodeString(value)
+	if scheme != "Negotiate" || err != nil {
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	acceptor := s.pending[r.RemoteAddr]
+	if acceptor == nil {
+		acceptor = &ntlmAcceptor{channelBindings: s.channelBindings}
+	}
+	challenge, err := acceptor.accept(token)
+	if err != nil {
+		delete(s.pending, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", "Negotiate")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if challenge != nil {
+		s.pending[r.RemoteAddr] = acceptor
+		w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(challenge))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	delete(s.pending, r.RemoteAddr)
+	s.authentications++
+	w.Header().Set("Content-Type", "application/soap+xml")
+	_, _ = w.Write([]byte(s.response))
+}
+
+func runNTLMServer(c *C, server *ntlmServer, https bool) (*Client, func()) {
+	server.pending = make(map[string]*ntlmAcceptor)
+	ts := httptest.NewUnstartedServer(server)
+	if https {
+		ts.StartTLS()
+	} else {
+		ts.Start()
+	}
+	if https && server.channelBindings == nil {
+		server.channelBindings = testChannelBindings(ts.Certificate())
+	}
+	host, port, err := FindHostAndPortFromURL(ts.URL)
+	c.Assert(err, IsNil)
+
+	params := *DefaultParameters
+	params.TransportDecorator = func() Transporter { return &ClientNTLM{} }
+	client, err := NewClientWithParameters(NewEndpoint(host, port, https, true, nil, nil, nil, 0), testUser, testPassword, &params)
+	c.Assert(err, IsNil)
+	return client, ts.Close
+}
+
+func (s *WinRMSuite) TestHttpNTLMAuthentication(c *C) {
+	server := &ntlmServer{response: response}
+	client, stop := runNTLMServer(c, server, false)
+	defer stop()
+
+	shell, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Check(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Check(server.authentications, Equals, 1)
+}
+
+func (s *WinRMSuite) TestHttpsNTLMChannelBinding(c *C) {
+	server := &ntlmServer{response: response}
+	client, stop := runNTLMServer(c, server, true)
+	defer stop()
+
+	shell, err := client.CreateShell()
+	c.Assert(err, IsNil)
+	c.Check(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Check(server.authentications, Equals, 1)
+}
+
+func (s *WinRMSuite) TestHttpsNTLMChannelBindingMismatch(c *C) {
+	server := &ntlmServer{response: response, channelBindings: make([]byte, 16)}
+	client, stop := runNTLMServer(c, server, true)
+	defer stop()
+
+	_, err := client.CreateShell()
+	c.Assert(err, ErrorMatches, "http response error: 401 - .*")
+	c.Check(server.authentications, Equals, 0)
+}
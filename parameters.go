@@ -1,6 +1,9 @@
 package winrm
 
-import "net"
+import (
+	"net"
+	"time"
+)
 
 // Parameters struct defines
 // metadata information and http transport config
@@ -10,6 +13,13 @@ type Parameters struct {
 	EnvelopeSize       int
 	TransportDecorator func() Transporter
 	Dial               func(network, addr string) (net.Conn, error)
+
+	// StdinBufferSize enables the buffering of the input written to commands, which is sent
+	// once that many bytes are buffered, at most the input fitting in an envelope
+	StdinBufferSize int
+	// StdinFlushInterval enables the buffering of the input written to commands, which is
+	// sent at most that long after being written
+	StdinFlushInterval time.Duration
 }
 
 // DefaultParameters return constant config
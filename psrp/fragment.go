@@ -0,0 +1,160 @@
+package psrp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// FragmentHeaderLength is the size of the header prefixing every fragment blob
+const FragmentHeaderLength = 8 + 8 + 1 + 4
+
+const (
+	fragmentStart byte = 0x1
+	fragmentEnd   byte = 0x2
+)
+
+// Fragment is a chunk of an encoded Message, see MS-PSRP 2.2.4
+type Fragment struct {
+	ObjectID   uint64
+	FragmentID uint64
+	Start      bool
+	End        bool
+	Blob       []byte
+}
+
+// MarshalBinary encodes the fragment header followed by its blob
+func (f *Fragment) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, FragmentHeaderLength+len(f.Blob))
+	binary.BigEndian.PutUint64(buf[0:8], f.ObjectID)
+	binary.BigEndian.PutUint64(buf[8:16], f.FragmentID)
+
+	var flags byte
+	if f.Start {
+		flags |= fragmentStart
+	}
+	if f.End {
+		flags |= fragmentEnd
+	}
+	buf[16] = flags
+
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(f.Blob)))
+	copy(buf[FragmentHeaderLength:], f.Blob)
+	return buf, nil
+}
+
+// Fragmenter splits messages into fragments no larger than a given size.
+// A Fragmenter hands out object ids, so a single one must be used for all
+// the messages sent to a given runspace pool.
+type Fragmenter struct {
+	mutex           sync.Mutex
+	maxFragmentSize int
+	objectID        uint64
+}
+
+// NewFragmenter returns a Fragmenter producing fragments of at most maxFragmentSize bytes, header included
+func NewFragmenter(maxFragmentSize int) *Fragmenter {
+	return &Fragmenter{maxFragmentSize: maxFragmentSize}
+}
+
+// Fragment encodes the message and returns the encoded fragments in order
+func (f *Fragmenter) Fragment(message *Message) ([][]byte, error) {
+	data, err := message.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	blobSize := f.maxFragmentSize - FragmentHeaderLength
+	if blobSize <= 0 {
+		return nil, fmt.Errorf("fragment size %d is too small", f.maxFragmentSize)
+	}
+
+	f.mutex.Lock()
+	f.objectID++
+	objectID := f.objectID
+	f.mutex.Unlock()
+
+	var fragments [][]byte
+	for fragmentID := uint64(0); ; fragmentID++ {
+		n := len(data)
+		if n > blobSize {
+			n = blobSize
+		}
+		fragment := &Fragment{
+			ObjectID:   objectID,
+			FragmentID: fragmentID,
+			Start:      fragmentID == 0,
+			End:        n == len(data),
+			Blob:       data[:n],
+		}
+		encoded, err := fragment.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, encoded)
+
+		data = data[n:]
+		if len(data) == 0 {
+			return fragments, nil
+		}
+	}
+}
+
+// Defragmenter reassembles the fragments received from the server into messages
+type Defragmenter struct {
+	mutex    sync.Mutex
+	incoming []byte
+	pending  map[uint64]*bytes.Buffer
+}
+
+// NewDefragmenter returns an empty Defragmenter
+func NewDefragmenter() *Defragmenter {
+	return &Defragmenter{pending: make(map[uint64]*bytes.Buffer)}
+}
+
+// Write feeds raw stream data to the Defragmenter and returns the messages
+// that have been completed by it. Data may end in the middle of a fragment,
+// the remainder is kept until the next call.
+func (d *Defragmenter) Write(data []byte) ([]*Message, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.incoming = append(d.incoming, data...)
+
+	var messages []*Message
+	for len(d.incoming) >= FragmentHeaderLength {
+		blobLength := int(binary.BigEndian.Uint32(d.incoming[17:21]))
+		if len(d.incoming) < FragmentHeaderLength+blobLength {
+			break
+		}
+
+		objectID := binary.BigEndian.Uint64(d.incoming[0:8])
+		flags := d.incoming[16]
+		blob := d.incoming[FragmentHeaderLength : FragmentHeaderLength+blobLength]
+
+		buf, ok := d.pending[objectID]
+		if flags&fragmentStart != 0 {
+			buf = new(bytes.Buffer)
+			d.pending[objectID] = buf
+		} else if !ok {
+			return messages, fmt.Errorf("received fragment for unknown object %d", objectID)
+		}
+		buf.Write(blob)
+
+		d.incoming = d.incoming[FragmentHeaderLength+blobLength:]
+
+		if flags&fragmentEnd == 0 {
+			continue
+		}
+		delete(d.pending, objectID)
+
+		message := new(Message)
+		if err := message.UnmarshalBinary(buf.Bytes()); err != nil {
+			return messages, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,98 @@
+package psrp
+
+import (
+	"bytes"
+
+	"github.com/gofrs/uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestFragmentMarshal(c *C) {
+	fragment := &Fragment{ObjectID: 1, FragmentID: 2, Start: true, End: true, Blob: []byte("abc")}
+	data, err := fragment.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, []byte{
+		0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, 0, 0, 0, 2,
+		3,
+		0, 0, 0, 3,
+		'a', 'b', 'c',
+	})
+}
+
+func (s *MySuite) TestFragmentSingle(c *C) {
+	fragmenter := NewFragmenter(1024)
+	message := NewSessionCapabilityMessage(uuid.Must(uuid.NewV4()))
+
+	fragments, err := fragmenter.Fragment(message)
+	c.Assert(err, IsNil)
+	c.Assert(fragments, HasLen, 1)
+
+	messages, err := NewDefragmenter().Write(fragments[0])
+	c.Assert(err, IsNil)
+	c.Assert(messages, HasLen, 1)
+	c.Assert(messages[0], DeepEquals, message)
+}
+
+func (s *MySuite) TestFragmentMultiple(c *C) {
+	fragmenter := NewFragmenter(FragmentHeaderLength + 100)
+	rpid := uuid.Must(uuid.NewV4())
+	first := NewInitRunspacePoolMessage(rpid, 1, 1)
+	second := NewCreatePipelineMessage(rpid, uuid.Must(uuid.NewV4()), "Get-Process")
+
+	one, err := fragmenter.Fragment(first)
+	c.Assert(err, IsNil)
+	c.Assert(len(one) > 1, Equals, true)
+	two, err := fragmenter.Fragment(second)
+	c.Assert(err, IsNil)
+
+	// interleave the fragments of both messages and split them at odd boundaries
+	var stream []byte
+	for i := 0; i < len(one) || i < len(two); i++ {
+		if i < len(one) {
+			stream = append(stream, one[i]...)
+		}
+		if i < len(two) {
+			stream = append(stream, two[i]...)
+		}
+	}
+
+	defragmenter := NewDefragmenter()
+	var messages []*Message
+	for len(stream) > 0 {
+		n := 37
+		if n > len(stream) {
+			n = len(stream)
+		}
+		got, err := defragmenter.Write(stream[:n])
+		c.Assert(err, IsNil)
+		messages = append(messages, got...)
+		stream = stream[n:]
+	}
+
+	c.Assert(messages, HasLen, 2)
+	c.Assert(messages[0], DeepEquals, first)
+	c.Assert(messages[1], DeepEquals, second)
+}
+
+func (s *MySuite) TestFragmentObjectIDs(c *C) {
+	fragmenter := NewFragmenter(1024)
+	message := NewSessionCapabilityMessage(uuid.Nil)
+	one, _ := fragmenter.Fragment(message)
+	two, _ := fragmenter.Fragment(message)
+	c.Assert(one[0][:8], DeepEquals, []byte{0, 0, 0, 0, 0, 0, 0, 1})
+	c.Assert(two[0][:8], DeepEquals, []byte{0, 0, 0, 0, 0, 0, 0, 2})
+}
+
+func (s *MySuite) TestDefragmentUnknownObject(c *C) {
+	fragment := &Fragment{ObjectID: 4, FragmentID: 1, End: true, Blob: []byte("abc")}
+	data, _ := fragment.MarshalBinary()
+	_, err := NewDefragmenter().Write(data)
+	c.Assert(err, ErrorMatches, ".*unknown object 4")
+}
+
+func (s *MySuite) TestFragmentTooSmall(c *C) {
+	_, err := NewFragmenter(FragmentHeaderLength).Fragment(NewSessionCapabilityMessage(uuid.Nil))
+	c.Assert(err, NotNil)
+	c.Assert(bytes.Contains([]byte(err.Error()), []byte("too small")), Equals, true)
+}
@@ -0,0 +1,141 @@
+package psrp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/gofrs/uuid"
+)
+
+// Destination indicates which side of the connection a message is addressed to
+type Destination uint32
+
+// Message destinations
+const (
+	DestinationClient Destination = 0x00000001
+	DestinationServer Destination = 0x00000002
+)
+
+// MessageType identifies the kind of PSRP message
+type MessageType uint32
+
+// Message types, see MS-PSRP 2.2.1
+const (
+	SessionCapability        MessageType = 0x00010002
+	InitRunspacePool         MessageType = 0x00010004
+	PublicKey                MessageType = 0x00010005
+	EncryptedSessionKey      MessageType = 0x00010006
+	PublicKeyRequest         MessageType = 0x00010007
+	ConnectRunspacePool      MessageType = 0x00010008
+	RunspacePoolInitData     MessageType = 0x0002100B
+	ResetRunspaceState       MessageType = 0x0002100C
+	SetMaxRunspaces          MessageType = 0x00021002
+	SetMinRunspaces          MessageType = 0x00021003
+	RunspaceAvailability     MessageType = 0x00021004
+	RunspacePoolStateMessage MessageType = 0x00021005
+	CreatePipeline           MessageType = 0x00021006
+	GetAvailableRunspaces    MessageType = 0x00021007
+	UserEvent                MessageType = 0x00021008
+	ApplicationPrivateData   MessageType = 0x00021009
+	GetCommandMetadata       MessageType = 0x0002100A
+	RunspacePoolHostCall     MessageType = 0x00021100
+	RunspacePoolHostResponse MessageType = 0x00021101
+	PipelineInput            MessageType = 0x00041002
+	EndOfPipelineInput       MessageType = 0x00041003
+	PipelineOutput           MessageType = 0x00041004
+	ErrorRecord              MessageType = 0x00041005
+	PipelineStateMessage     MessageType = 0x00041006
+	DebugRecord              MessageType = 0x00041007
+	VerboseRecord            MessageType = 0x00041008
+	WarningRecord            MessageType = 0x00041009
+	ProgressRecord           MessageType = 0x00041010
+	InformationRecord        MessageType = 0x00041011
+	PipelineHostCall         MessageType = 0x00041100
+	PipelineHostResponse     MessageType = 0x00041101
+)
+
+// messageHeaderLength is destination + message type + RPID + PID
+const messageHeaderLength = 4 + 4 + 16 + 16
+
+// utf8BOM may prefix the data of messages sent by the server
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// Message is a PSRP message, the unit exchanged between the client and the
+// server once fragments have been reassembled
+type Message struct {
+	Destination    Destination
+	Type           MessageType
+	RunspacePoolID uuid.UUID
+	PipelineID     uuid.UUID
+	Data           []byte
+}
+
+// NewMessage returns a new message addressed to the server
+func NewMessage(messageType MessageType, rpid, pid uuid.UUID, data []byte) *Message {
+	return &Message{
+		Destination:    DestinationServer,
+		Type:           messageType,
+		RunspacePoolID: rpid,
+		PipelineID:     pid,
+		Data:           data,
+	}
+}
+
+// MarshalBinary encodes the message as described in MS-PSRP 2.2.1
+func (m *Message) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, messageHeaderLength+len(m.Data)))
+	if err := binary.Write(buf, binary.LittleEndian, uint32(m.Destination)); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint32(m.Type)); err != nil {
+		return nil, err
+	}
+	buf.Write(guidBytes(m.RunspacePoolID))
+	buf.Write(guidBytes(m.PipelineID))
+	buf.Write(m.Data)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a message as described in MS-PSRP 2.2.1
+func (m *Message) UnmarshalBinary(data []byte) error {
+	if len(data) < messageHeaderLength {
+		return errors.New("psrp message is too short")
+	}
+
+	m.Destination = Destination(binary.LittleEndian.Uint32(data[0:4]))
+	m.Type = MessageType(binary.LittleEndian.Uint32(data[4:8]))
+
+	var err error
+	if m.RunspacePoolID, err = guidFromBytes(data[8:24]); err != nil {
+		return fmt.Errorf("decoding runspace pool id: %w", err)
+	}
+	if m.PipelineID, err = guidFromBytes(data[24:40]); err != nil {
+		return fmt.Errorf("decoding pipeline id: %w", err)
+	}
+
+	m.Data = bytes.TrimPrefix(data[messageHeaderLength:], utf8BOM)
+	return nil
+}
+
+// guidBytes converts an RFC 4122 uuid to the mixed-endian layout
+// Windows uses for GUIDs on the wire
+func guidBytes(id uuid.UUID) []byte {
+	b := make([]byte, 16)
+	copy(b, id.Bytes())
+	b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	b[4], b[5] = b[5], b[4]
+	b[6], b[7] = b[7], b[6]
+	return b
+}
+
+// guidFromBytes is the reverse of guidBytes
+func guidFromBytes(b []byte) (uuid.UUID, error) {
+	buf := make([]byte, 16)
+	copy(buf, b)
+	buf[0], buf[1], buf[2], buf[3] = buf[3], buf[2], buf[1], buf[0]
+	buf[4], buf[5] = buf[5], buf[4]
+	buf[6], buf[7] = buf[7], buf[6]
+	return uuid.FromBytes(buf)
+}
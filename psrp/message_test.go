@@ -0,0 +1,55 @@
+package psrp
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type MySuite struct{}
+
+var _ = Suite(&MySuite{})
+
+func (s *MySuite) TestGUIDLayout(c *C) {
+	id := uuid.Must(uuid.FromString("00112233-4455-6677-8899-aabbccddeeff"))
+	c.Assert(guidBytes(id), DeepEquals, []byte{
+		0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66,
+		0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff,
+	})
+
+	back, err := guidFromBytes(guidBytes(id))
+	c.Assert(err, IsNil)
+	c.Assert(back, Equals, id)
+}
+
+func (s *MySuite) TestMessageRoundTrip(c *C) {
+	rpid := uuid.Must(uuid.NewV4())
+	pid := uuid.Must(uuid.NewV4())
+	message := NewMessage(CreatePipeline, rpid, pid, []byte("<Obj/>"))
+
+	data, err := message.MarshalBinary()
+	c.Assert(err, IsNil)
+	c.Assert(data[:8], DeepEquals, []byte{0x02, 0x00, 0x00, 0x00, 0x06, 0x10, 0x02, 0x00})
+
+	decoded := new(Message)
+	c.Assert(decoded.UnmarshalBinary(data), IsNil)
+	c.Assert(decoded, DeepEquals, message)
+}
+
+func (s *MySuite) TestMessageStripsBOM(c *C) {
+	message := NewMessage(PipelineOutput, uuid.Nil, uuid.Nil, append([]byte{0xEF, 0xBB, 0xBF}, "<S>hi</S>"...))
+	data, err := message.MarshalBinary()
+	c.Assert(err, IsNil)
+
+	decoded := new(Message)
+	c.Assert(decoded.UnmarshalBinary(data), IsNil)
+	c.Assert(string(decoded.Data), Equals, "<S>hi</S>")
+}
+
+func (s *MySuite) TestMessageTooShort(c *C) {
+	c.Assert(new(Message).UnmarshalBinary([]byte{0x01}), ErrorMatches, ".*too short.*")
+}
@@ -0,0 +1,202 @@
+package psrp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/gofrs/uuid"
+	"github.com/masterzen/winrm/clixml"
+)
+
+// ProtocolVersion is the PSRP protocol version announced by this client
+const ProtocolVersion = "2.3"
+
+// RunspacePoolState is the state of a runspace pool, see MS-PSRP 2.2.3.4
+type RunspacePoolState int
+
+// Runspace pool states
+const (
+	RunspacePoolBeforeOpen RunspacePoolState = iota
+	RunspacePoolOpening
+	RunspacePoolOpened
+	RunspacePoolClosed
+	RunspacePoolClosing
+	RunspacePoolBroken
+	RunspacePoolNegotiationSent
+	RunspacePoolNegotiationSucceeded
+	RunspacePoolConnecting
+	RunspacePoolDisconnected
+)
+
+// PipelineState is the state of a pipeline, see MS-PSRP 2.2.3.5
+type PipelineState int
+
+// Pipeline states
+const (
+	PipelineNotStarted PipelineState = iota
+	PipelineRunning
+	PipelineStopping
+	PipelineStopped
+	PipelineCompleted
+	PipelineFailed
+	PipelineDisconnected
+)
+
+// Terminal reports whether no more messages will be sent for the pipeline
+func (s PipelineState) Terminal() bool {
+	return s == PipelineStopped || s == PipelineCompleted || s == PipelineFailed
+}
+
+const hostInfo = `<Obj N="HostInfo" RefId="%d"><MS><B N="_isHostNull">true</B><B N="_isHostUINull">true</B><B N="_isHostRawUINull">true</B><B N="_useRunspaceHost">true</B></MS></Obj>`
+
+// NewSessionCapabilityMessage returns the SESSION_CAPABILITY message opening a runspace pool
+func NewSessionCapabilityMessage(rpid uuid.UUID) *Message {
+	data := `<Obj RefId="0"><MS>` +
+		`<Version N="protocolversion">` + ProtocolVersion + `</Version>` +
+		`<Version N="PSVersion">2.0</Version>` +
+		`<Version N="SerializationVersion">1.1.0.1</Version>` +
+		`</MS></Obj>`
+	return NewMessage(SessionCapability, rpid, uuid.Nil, []byte(data))
+}
+
+// NewInitRunspacePoolMessage returns the INIT_RUNSPACEPOOL message for a pool of minRunspaces to maxRunspaces runspaces
+func NewInitRunspacePoolMessage(rpid uuid.UUID, minRunspaces, maxRunspaces int) *Message {
+	data := `<Obj RefId="0"><MS>` +
+		fmt.Sprintf(`<I32 N="MinRunspaces">%d</I32><I32 N="MaxRunspaces">%d</I32>`, minRunspaces, maxRunspaces) +
+		`<Obj N="PSThreadOptions" RefId="1"><TN RefId="0"><T>System.Management.Automation.Runspaces.PSThreadOptions</T><T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>Default</ToString><I32>0</I32></Obj>` +
+		`<Obj N="ApartmentState" RefId="2"><TN RefId="1"><T>System.Threading.ApartmentState</T><T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>Unknown</ToString><I32>2</I32></Obj>` +
+		fmt.Sprintf(hostInfo, 3) +
+		`<Obj N="ApplicationArguments" RefId="4"><TN RefId="2"><T>System.Management.Automation.PSPrimitiveDictionary</T><T>System.Collections.Hashtable</T><T>System.Object</T></TN><DCT /></Obj>` +
+		`</MS></Obj>`
+	return NewMessage(InitRunspacePool, rpid, uuid.Nil, []byte(data))
+}
+
+// NewCreatePipelineMessage returns the CREATE_PIPELINE message running script in a new pipeline pid
+func NewCreatePipelineMessage(rpid, pid uuid.UUID, script string) *Message {
+	merge := func(name string, refID int) string {
+		return fmt.Sprintf(`<Obj N="%s" RefId="%d"><TNRef RefId="1" /><ToString>None</ToString><I32>0</I32></Obj>`, name, refID)
+	}
+
+	data := `<Obj RefId="0"><MS>` +
+		`<Obj N="PowerShell" RefId="1"><MS>` +
+		`<Obj N="Cmds" RefId="2"><TN RefId="0"><T>System.Collections.Generic.List` + "`" + `1[[System.Management.Automation.PSObject, System.Management.Automation, Version=1.0.0.0, Culture=neutral, PublicKeyToken=31bf3856ad364e35]]</T><T>System.Object</T></TN><LST>` +
+		`<Obj RefId="3"><MS>` +
+		`<S N="Cmd">` + clixml.EncodeString(script) + `</S>` +
+		`<B N="IsScript">true</B>` +
+		`<Nil N="UseLocalScope" />` +
+		`<Obj N="MergeMyResult" RefId="4"><TN RefId="1"><T>System.Management.Automation.Runspaces.PipelineResultTypes</T><T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>None</ToString><I32>0</I32></Obj>` +
+		merge("MergeToResult", 5) +
+		merge("MergePreviousResults", 6) +
+		merge("MergeError", 7) +
+		merge("MergeWarning", 8) +
+		merge("MergeVerbose", 9) +
+		merge("MergeDebug", 10) +
+		merge("MergeInformation", 11) +
+		`<Obj N="Args" RefId="12"><TNRef RefId="0" /><LST /></Obj>` +
+		`</MS></Obj>` +
+		`</LST></Obj>` +
+		`<B N="IsNested">false</B>` +
+		`<Nil N="History" />` +
+		`<B N="RedirectShellErrorOutputPipe">true</B>` +
+		`</MS></Obj>` +
+		`<B N="NoInput">true</B>` +
+		`<Obj N="ApartmentState" RefId="13"><TN RefId="2"><T>System.Threading.ApartmentState</T><T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>Unknown</ToString><I32>2</I32></Obj>` +
+		`<Obj N="RemoteStreamOptions" RefId="14"><TN RefId="3"><T>System.Management.Automation.RemoteStreamOptions</T><T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>0</ToString><I32>0</I32></Obj>` +
+		`<B N="AddToHistory">false</B>` +
+		fmt.Sprintf(hostInfo, 15) +
+		`<B N="IsNested">false</B>` +
+		`</MS></Obj>`
+	return NewMessage(CreatePipeline, rpid, pid, []byte(data))
+}
+
+// Property returns the text content of the first element carrying the N=name attribute,
+// or its ToString when the element is a complex object
+func (m *Message) Property(name string) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(m.Data))
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("property %s not found", name)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "N" && attr.Value == name {
+				var value struct {
+					Text     string `xml:",chardata"`
+					ToString string `xml:"ToString"`
+				}
+				if err := decoder.DecodeElement(&value, &start); err != nil {
+					return "", err
+				}
+				if value.ToString != "" {
+					return clixml.DecodeString(value.ToString), nil
+				}
+				return clixml.DecodeString(value.Text), nil
+			}
+		}
+	}
+}
+
+// RunspacePoolState returns the state carried by a RUNSPACEPOOL_STATE message
+func (m *Message) RunspacePoolState() (RunspacePoolState, error) {
+	if m.Type != RunspacePoolStateMessage {
+		return 0, fmt.Errorf("message 0x%08X is not a runspace pool state", uint32(m.Type))
+	}
+	value, err := m.Property("RunspaceState")
+	if err != nil {
+		return 0, err
+	}
+	state, err := strconv.Atoi(value)
+	return RunspacePoolState(state), err
+}
+
+// PipelineState returns the state carried by a PIPELINE_STATE message
+func (m *Message) PipelineState() (PipelineState, error) {
+	if m.Type != PipelineStateMessage {
+		return 0, fmt.Errorf("message 0x%08X is not a pipeline state", uint32(m.Type))
+	}
+	value, err := m.Property("PipelineState")
+	if err != nil {
+		return 0, err
+	}
+	state, err := strconv.Atoi(value)
+	return PipelineState(state), err
+}
+
+// String returns a textual representation of the object carried by the message:
+// the value of a primitive, or the ToString of a complex object
+func (m *Message) String() string {
+	decoder := xml.NewDecoder(bytes.NewReader(m.Data))
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Local == "ToString" && depth == 2 || depth == 1 && t.Name.Local != "Obj" {
+				var content string
+				if err := decoder.DecodeElement(&content, &t); err != nil {
+					return ""
+				}
+				return clixml.DecodeString(content)
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package psrp
+
+import (
+	"strings"
+
+	"github.com/gofrs/uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *MySuite) TestCreatePipelineMessage(c *C) {
+	message := NewCreatePipelineMessage(uuid.Nil, uuid.Nil, "Write-Output 'a & b'")
+	c.Assert(message.Type, Equals, CreatePipeline)
+	c.Assert(strings.Contains(string(message.Data), `<S N="Cmd">Write-Output &apos;a &amp; b&apos;</S>`), Equals, true)
+
+	cmd, err := message.Property("Cmd")
+	c.Assert(err, IsNil)
+	c.Assert(cmd, Equals, "Write-Output 'a & b'")
+}
+
+func (s *MySuite) TestRunspacePoolState(c *C) {
+	message := NewMessage(RunspacePoolStateMessage, uuid.Nil, uuid.Nil, []byte(`<Obj RefId="0"><MS><I32 N="RunspaceState">2</I32></MS></Obj>`))
+	state, err := message.RunspacePoolState()
+	c.Assert(err, IsNil)
+	c.Assert(state, Equals, RunspacePoolOpened)
+
+	_, err = message.PipelineState()
+	c.Assert(err, NotNil)
+}
+
+func (s *MySuite) TestPipelineState(c *C) {
+	message := NewMessage(PipelineStateMessage, uuid.Nil, uuid.Nil, []byte(`<Obj RefId="0"><MS><I32 N="PipelineState">4</I32></MS></Obj>`))
+	state, err := message.PipelineState()
+	c.Assert(err, IsNil)
+	c.Assert(state, Equals, PipelineCompleted)
+	c.Assert(state.Terminal(), Equals, true)
+	c.Assert(PipelineRunning.Terminal(), Equals, false)
+}
+
+func (s *MySuite) TestMessageString(c *C) {
+	primitive := NewMessage(PipelineOutput, uuid.Nil, uuid.Nil, []byte(`<S>line_x000A_</S>`))
+	c.Assert(primitive.String(), Equals, "line\n")
+
+	object := NewMessage(ErrorRecord, uuid.Nil, uuid.Nil, []byte(`<Obj RefId="0"><TN RefId="0"><T>System.Management.Automation.ErrorRecord</T></TN><ToString>boom</ToString><MS><Obj N="Exception" RefId="1"><ToString>inner</ToString></Obj></MS></Obj>`))
+	c.Assert(object.String(), Equals, "boom")
+}
+
+func (s *MySuite) TestPropertyOfObject(c *C) {
+	message := NewMessage(PipelineStateMessage, uuid.Nil, uuid.Nil, []byte(`<Obj RefId="0"><MS><I32 N="PipelineState">5</I32><Obj N="ExceptionAsErrorRecord" RefId="1"><ToString>The term 'foo' is not recognized</ToString></Obj></MS></Obj>`))
+	reason, err := message.Property("ExceptionAsErrorRecord")
+	c.Assert(err, IsNil)
+	c.Assert(reason, Equals, "The term 'foo' is not recognized")
+}
@@ -2,11 +2,26 @@ package winrm
 
 import (
 	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/masterzen/simplexml/dom"
+	"github.com/masterzen/winrm/clixml"
+	"github.com/masterzen/winrm/psrp"
 	"github.com/masterzen/winrm/soap"
 )
 
+// Resource URIs of the shells supported by this package
+const (
+	ResourceURICmd        = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd"
+	ResourceURIPowerShell = "http://schemas.microsoft.com/powershell/Microsoft.PowerShell"
+)
+
 func genUUID() string {
 	id := uuid.Must(uuid.NewV4())
 	return "uuid:" + id.String()
@@ -25,23 +40,57 @@ func defaultHeaders(message *soap.SoapMessage, url string, params *Parameters) *
 
 //NewOpenShellRequest makes a new soap request
 func NewOpenShellRequest(uri string, params *Parameters) *soap.SoapMessage {
+	return NewOpenShellRequestWithOptions(uri, nil, params)
+}
+
+// NewOpenShellRequestWithOptions creates a cmd shell configured by options, the
+// defaults being used when options is nil
+func NewOpenShellRequestWithOptions(uri string, options *ShellOptions, params *Parameters) *soap.SoapMessage {
 	if params == nil {
 		params = DefaultParameters
 	}
+	if options == nil {
+		options = &ShellOptions{}
+	}
+
+	noProfile := "FALSE"
+	if options.NoProfile {
+		noProfile = "TRUE"
+	}
+	codepage := options.Codepage
+	if codepage == 0 {
+		codepage = DefaultCodepage
+	}
 
 	message := soap.NewMessage()
 	defaultHeaders(message, uri, params).
 		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Create").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
-		AddOption(soap.NewHeaderOption("WINRS_NOPROFILE", "FALSE")).
-		AddOption(soap.NewHeaderOption("WINRS_CODEPAGE", "65001")).
+		ResourceURI(ResourceURICmd).
+		AddOption(soap.NewHeaderOption("WINRS_NOPROFILE", noProfile)).
+		AddOption(soap.NewHeaderOption("WINRS_CODEPAGE", strconv.Itoa(codepage))).
 		Build()
 
 	body := message.CreateBodyElement("Shell", soap.DOM_NS_WIN_SHELL)
+	if len(options.Environment) > 0 {
+		environment := message.CreateElement(body, "Environment", soap.DOM_NS_WIN_SHELL)
+		for _, name := range sortedKeys(options.Environment) {
+			variable := message.CreateElement(environment, "Variable", soap.DOM_NS_WIN_SHELL)
+			variable.SetAttr("Name", soap.EscapeXML(name))
+			variable.SetContent(soap.EscapeXML(options.Environment[name]))
+		}
+	}
+	if options.WorkingDirectory != "" {
+		directory := message.CreateElement(body, "WorkingDirectory", soap.DOM_NS_WIN_SHELL)
+		directory.SetContent(soap.EscapeXML(options.WorkingDirectory))
+	}
+	if options.IdleTimeOut > 0 {
+		idleTimeOut := message.CreateElement(body, "IdleTimeOut", soap.DOM_NS_WIN_SHELL)
+		idleTimeOut.SetContent(clixml.FormatDuration(options.IdleTimeOut))
+	}
 	input := message.CreateElement(body, "InputStreams", soap.DOM_NS_WIN_SHELL)
-	input.SetContent("stdin")
+	input.SetContent(soap.EscapeXML(strings.Join(options.inputStreams(), " ")))
 	output := message.CreateElement(body, "OutputStreams", soap.DOM_NS_WIN_SHELL)
-	output.SetContent("stdout stderr")
+	output.SetContent(soap.EscapeXML(strings.Join(options.outputStreams(), " ")))
 
 	return message
 }
@@ -55,7 +104,7 @@ func NewDeleteShellRequest(uri, shellID string, params *Parameters) *soap.SoapMe
 	defaultHeaders(message, uri, params).
 		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete").
 		ShellId(shellID).
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		ResourceURI(ResourceURICmd).
 		Build()
 
 	message.NewBody()
@@ -71,7 +120,7 @@ func NewExecuteCommandRequest(uri, shellID, command string, arguments []string,
 	message := soap.NewMessage()
 	defaultHeaders(message, uri, params).
 		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		ResourceURI(ResourceURICmd).
 		ShellId(shellID).
 		AddOption(soap.NewHeaderOption("WINRS_CONSOLEMODE_STDIN", "TRUE")).
 		AddOption(soap.NewHeaderOption("WINRS_SKIP_CMD_SHELL", "FALSE")).
@@ -95,13 +144,26 @@ func NewExecuteCommandRequest(uri, shellID, command string, arguments []string,
 
 //NewGetOutputRequest NewGetOutputRequest
 func NewGetOutputRequest(uri, shellID, commandID, streams string, params *Parameters) *soap.SoapMessage {
+	message, _ := newReceiveRequest(uri, shellID, commandID, streams, params)
+	return message
+}
+
+// NewResumeOutputRequest receives the output of commandID starting at the response sequenceID,
+// resuming the streams of a command of a reconnected shell
+func NewResumeOutputRequest(uri, shellID, commandID, streams string, sequenceID uint64, params *Parameters) *soap.SoapMessage {
+	message, receive := newReceiveRequest(uri, shellID, commandID, streams, params)
+	receive.SetAttr("SequenceId", strconv.FormatUint(sequenceID, 10))
+	return message
+}
+
+func newReceiveRequest(uri, shellID, commandID, streams string, params *Parameters) (*soap.SoapMessage, *dom.Element) {
 	if params == nil {
 		params = DefaultParameters
 	}
 	message := soap.NewMessage()
 	defaultHeaders(message, uri, params).
 		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		ResourceURI(ResourceURICmd).
 		ShellId(shellID).
 		Build()
 
@@ -110,6 +172,62 @@ func NewGetOutputRequest(uri, shellID, commandID, streams string, params *Parame
 	desiredStreams.SetAttr("CommandId", commandID)
 	desiredStreams.SetContent(streams)
 
+	return message, receive
+}
+
+// NewDisconnectRequest disconnects the client from shellID, the server keeping the shell
+// and its commands running for idleTimeOut, or its default when zero
+func NewDisconnectRequest(uri, shellID string, idleTimeOut time.Duration, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Disconnect").
+		ResourceURI(ResourceURICmd).
+		ShellId(shellID).
+		Build()
+
+	disconnect := message.CreateBodyElement("Disconnect", soap.DOM_NS_WIN_SHELL)
+	if idleTimeOut > 0 {
+		timeout := message.CreateElement(disconnect, "IdleTimeOut", soap.DOM_NS_WIN_SHELL)
+		timeout.SetContent(clixml.FormatDuration(idleTimeOut))
+	}
+
+	return message
+}
+
+// NewReconnectRequest reconnects the client which disconnected from shellID
+func NewReconnectRequest(uri, shellID string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Reconnect").
+		ResourceURI(ResourceURICmd).
+		ShellId(shellID).
+		Build()
+
+	message.CreateBodyElement("Reconnect", soap.DOM_NS_WIN_SHELL)
+
+	return message
+}
+
+// NewConnectRequest connects a new client to the disconnected shellID
+func NewConnectRequest(uri, shellID string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Connect").
+		ResourceURI(ResourceURICmd).
+		ShellId(shellID).
+		Build()
+
+	message.CreateBodyElement("Connect", soap.DOM_NS_WIN_SHELL)
+
 	return message
 }
 
@@ -122,7 +240,7 @@ func NewSendInputRequest(uri, shellID, commandID string, input []byte, eof bool,
 
 	defaultHeaders(message, uri, params).
 		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		ResourceURI(ResourceURICmd).
 		ShellId(shellID).
 		Build()
 
@@ -141,6 +259,11 @@ func NewSendInputRequest(uri, shellID, commandID string, input []byte, eof bool,
 
 //NewSignalRequest NewSignalRequest
 func NewSignalRequest(uri string, shellID string, commandID string, params *Parameters) *soap.SoapMessage {
+	return NewSignalRequestWithCode(uri, shellID, commandID, SignalTerminate, params)
+}
+
+// NewSignalRequestWithCode sends the signal code, such as SignalCtrlC, to commandID
+func NewSignalRequestWithCode(uri, shellID, commandID, code string, params *Parameters) *soap.SoapMessage {
 	if params == nil {
 		params = DefaultParameters
 	}
@@ -148,14 +271,352 @@ func NewSignalRequest(uri string, shellID string, commandID string, params *Para
 
 	defaultHeaders(message, uri, params).
 		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal").
-		ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd").
+		ResourceURI(ResourceURICmd).
 		ShellId(shellID).
 		Build()
 
 	signal := message.CreateBodyElement("Signal", soap.DOM_NS_WIN_SHELL)
 	signal.SetAttr("CommandId", commandID)
-	code := message.CreateElement(signal, "Code", soap.DOM_NS_WIN_SHELL)
-	code.SetContent("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate")
+	message.CreateElement(signal, "Code", soap.DOM_NS_WIN_SHELL).SetContent(code)
 
 	return message
 }
+
+// NewCreateRunspacePoolRequest opens a PowerShell shell hosting the runspace pool shellID,
+// creationXML holds the fragmented SESSION_CAPABILITY and INIT_RUNSPACEPOOL messages
+func NewCreateRunspacePoolRequest(uri, shellID string, creationXML []byte, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Create").
+		ResourceURI(ResourceURIPowerShell).
+		AddOption(soap.NewHeaderOption("protocolversion", psrp.ProtocolVersion)).
+		Build()
+
+	body := message.CreateBodyElement("Shell", soap.DOM_NS_WIN_SHELL)
+	body.SetAttr("ShellId", shellID)
+	input := message.CreateElement(body, "InputStreams", soap.DOM_NS_WIN_SHELL)
+	input.SetContent("stdin pr")
+	output := message.CreateElement(body, "OutputStreams", soap.DOM_NS_WIN_SHELL)
+	output.SetContent("stdout")
+
+	creation := dom.CreateElement("creationXml")
+	creation.SetAttr("xmlns", "http://schemas.microsoft.com/powershell")
+	creation.SetContent(base64.StdEncoding.EncodeToString(creationXML))
+	body.AddChild(creation)
+
+	return message
+}
+
+// NewCreatePipelineRequest starts the pipeline commandID in the runspace pool shellID,
+// arguments holds the first fragments of the CREATE_PIPELINE message
+func NewCreatePipelineRequest(uri, shellID, commandID string, arguments []byte, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command").
+		ResourceURI(ResourceURIPowerShell).
+		ShellId(shellID).
+		Build()
+
+	body := message.CreateBodyElement("CommandLine", soap.DOM_NS_WIN_SHELL)
+	body.SetAttr("CommandId", commandID)
+	message.CreateElement(body, "Command", soap.DOM_NS_WIN_SHELL)
+	argumentsElement := message.CreateElement(body, "Arguments", soap.DOM_NS_WIN_SHELL)
+	argumentsElement.SetContent(base64.StdEncoding.EncodeToString(arguments))
+
+	return message
+}
+
+// NewRunspacePoolReceiveRequest receives the PSRP fragments sent by the runspace pool shellID,
+// or by its pipeline commandID when it is not empty
+func NewRunspacePoolReceiveRequest(uri, shellID, commandID string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive").
+		ResourceURI(ResourceURIPowerShell).
+		ShellId(shellID).
+		AddOption(soap.NewHeaderOption("WSMAN_CMDSHELL_OPTION_KEEPALIVE", "TRUE")).
+		Build()
+
+	receive := message.CreateBodyElement("Receive", soap.DOM_NS_WIN_SHELL)
+	desiredStreams := message.CreateElement(receive, "DesiredStream", soap.DOM_NS_WIN_SHELL)
+	if commandID != "" {
+		desiredStreams.SetAttr("CommandId", commandID)
+	}
+	desiredStreams.SetContent("stdout")
+
+	return message
+}
+
+// NewRunspacePoolSendRequest sends PSRP fragments to the pipeline commandID of the runspace pool shellID
+func NewRunspacePoolSendRequest(uri, shellID, commandID string, fragments []byte, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send").
+		ResourceURI(ResourceURIPowerShell).
+		ShellId(shellID).
+		Build()
+
+	send := message.CreateBodyElement("Send", soap.DOM_NS_WIN_SHELL)
+	streams := message.CreateElement(send, "Stream", soap.DOM_NS_WIN_SHELL)
+	streams.SetAttr("Name", "stdin")
+	streams.SetAttr("CommandId", commandID)
+	streams.SetContent(base64.StdEncoding.EncodeToString(fragments))
+
+	return message
+}
+
+// NewRunspacePoolSignalRequest sends the signal code to the pipeline commandID of the runspace
+// pool shellID, SignalPowerShellCtrlC stopping it and SignalTerminate releasing it
+func NewRunspacePoolSignalRequest(uri, shellID, commandID, code string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal").
+		ResourceURI(ResourceURIPowerShell).
+		ShellId(shellID).
+		Build()
+
+	signal := message.CreateBodyElement("Signal", soap.DOM_NS_WIN_SHELL)
+	signal.SetAttr("CommandId", commandID)
+	message.CreateElement(signal, "Code", soap.DOM_NS_WIN_SHELL).SetContent(code)
+
+	return message
+}
+
+// NewDeleteRunspacePoolRequest closes the runspace pool shellID
+func NewDeleteRunspacePoolRequest(uri, shellID string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete").
+		ShellId(shellID).
+		ResourceURI(ResourceURIPowerShell).
+		Build()
+
+	message.NewBody()
+
+	return message
+}
+
+// NewEnumerateRequest starts the enumeration of the instances of resourceURI, restricted to
+// those matching filter when it is not nil. The first items are returned in the response.
+func NewEnumerateRequest(uri, resourceURI string, filter *Filter, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate").
+		ResourceURI(resourceURI).
+		Build()
+
+	enumerate := message.CreateBodyElement("Enumerate", soap.DOM_NS_ENUM)
+	message.CreateElement(enumerate, "OptimizeEnumeration", soap.DOM_NS_WSMAN_DMTF)
+	maxElements := message.CreateElement(enumerate, "MaxElements", soap.DOM_NS_WSMAN_DMTF)
+	maxElements.SetContent(strconv.Itoa(enumerationMaxElements))
+	if filter != nil {
+		filterElement := message.CreateElement(enumerate, "Filter", soap.DOM_NS_WSMAN_DMTF)
+		filterElement.SetAttr("Dialect", filter.Dialect)
+		filterElement.SetContent(soap.EscapeXML(filter.Query))
+	}
+
+	return message
+}
+
+// NewPullRequest pulls the next items of the enumeration enumerationContext
+func NewPullRequest(uri, resourceURI, enumerationContext string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull").
+		ResourceURI(resourceURI).
+		Build()
+
+	pull := message.CreateBodyElement("Pull", soap.DOM_NS_ENUM)
+	contextElement := message.CreateElement(pull, "EnumerationContext", soap.DOM_NS_ENUM)
+	contextElement.SetContent(soap.EscapeXML(enumerationContext))
+	maxElements := message.CreateElement(pull, "MaxElements", soap.DOM_NS_ENUM)
+	maxElements.SetContent(strconv.Itoa(enumerationMaxElements))
+
+	return message
+}
+
+// NewReleaseRequest releases the enumeration enumerationContext before it reached its end
+func NewReleaseRequest(uri, resourceURI, enumerationContext string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	defaultHeaders(message, uri, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/enumeration/Release").
+		ResourceURI(resourceURI).
+		Build()
+
+	release := message.CreateBodyElement("Release", soap.DOM_NS_ENUM)
+	contextElement := message.CreateElement(release, "EnumerationContext", soap.DOM_NS_ENUM)
+	contextElement.SetContent(soap.EscapeXML(enumerationContext))
+
+	return message
+}
+
+// NewGetRequest retrieves the instance of resourceURI identified by selectors, such as
+// winrm/config or a WMI object
+func NewGetRequest(uri, resourceURI string, selectors, options map[string]string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	transferHeaders(message, uri, resourceURI, selectors, options, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Get").
+		Build()
+
+	message.NewBody()
+
+	return message
+}
+
+// NewIdentifyRequest asks the service to identify itself, a request without any of the
+// WS-Addressing headers of the other requests
+func NewIdentifyRequest() *soap.SoapMessage {
+	message := soap.NewMessage()
+	message.Header().Build()
+	message.CreateBodyElement("Identify", soap.DOM_NS_WSMAN_ID)
+	return message
+}
+
+// NewPutRequest updates the instance of resourceURI identified by selectors with body,
+// the XML of the new representation of the instance
+func NewPutRequest(uri, resourceURI string, selectors, options map[string]string, body string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	transferHeaders(message, uri, resourceURI, selectors, options, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Put").
+		Build()
+
+	message.NewBody().SetContent(body)
+
+	return message
+}
+
+// NewCreateRequest creates a new instance of resourceURI from body, the XML of its representation
+func NewCreateRequest(uri, resourceURI string, options map[string]string, body string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	transferHeaders(message, uri, resourceURI, nil, options, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Create").
+		Build()
+
+	message.NewBody().SetContent(body)
+
+	return message
+}
+
+// NewDeleteRequest deletes the instance of resourceURI identified by selectors
+func NewDeleteRequest(uri, resourceURI string, selectors, options map[string]string, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	transferHeaders(message, uri, resourceURI, selectors, options, params).
+		Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete").
+		Build()
+
+	message.NewBody()
+
+	return message
+}
+
+// NewInvokeRequest invokes the method of the instance of resourceURI identified by selectors
+// with parameters, a parameter holding a slice being passed as an array
+func NewInvokeRequest(uri, resourceURI string, selectors map[string]string, method string, parameters map[string]interface{}, params *Parameters) *soap.SoapMessage {
+	if params == nil {
+		params = DefaultParameters
+	}
+	message := soap.NewMessage()
+	header := defaultHeaders(message, uri, params).ResourceURI(resourceURI)
+	for _, name := range sortedKeys(selectors) {
+		header.AddSelector(name, selectors[name])
+	}
+	header.Action(strings.TrimSuffix(resourceURI, "/") + "/" + method).Build()
+
+	ns := dom.Namespace{Prefix: "p", Uri: resourceURI}
+	input := message.CreateBodyElement(method+"_INPUT", ns)
+	names := make([]string, 0, len(parameters))
+	for name := range parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range parameterValues(parameters[name]) {
+			message.CreateElement(input, name, ns).SetContent(soap.EscapeXML(value))
+		}
+	}
+
+	return message
+}
+
+// parameterValues formats the value of a method parameter, a slice giving one value per item
+func parameterValues(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case fmt.Stringer:
+		return []string{v.String()}
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice {
+		values := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			values = append(values, fmt.Sprint(rv.Index(i).Interface()))
+		}
+		return values
+	}
+	return []string{fmt.Sprint(value)}
+}
+
+// transferHeaders adds the resource URI, the selectors and the options of a WS-Transfer
+// request to the default headers, in the order of their names
+func transferHeaders(message *soap.SoapMessage, uri, resourceURI string, selectors, options map[string]string, params *Parameters) *soap.SoapHeader {
+	header := defaultHeaders(message, uri, params).ResourceURI(resourceURI)
+	for _, name := range sortedKeys(selectors) {
+		header.AddSelector(name, selectors[name])
+	}
+	for _, name := range sortedKeys(options) {
+		header.AddOption(soap.NewHeaderOption(soap.EscapeXML(name), soap.EscapeXML(options[name])))
+	}
+	return header
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
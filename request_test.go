@@ -3,6 +3,7 @@ package winrm
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ChrisTrenkamp/goxpath"
 	"github.com/ChrisTrenkamp/goxpath/tree"
@@ -27,6 +28,33 @@ func (s *WinRMSuite) TestOpenShellRequest(c *C) {
 	assertXPath(c, openShell.Doc(), "//a:To", "http://localhost")
 	assertXPath(c, openShell.Doc(), "//env:Body/rsp:Shell/rsp:InputStreams", "stdin")
 	assertXPath(c, openShell.Doc(), "//env:Body/rsp:Shell/rsp:OutputStreams", "stdout stderr")
+	assertXPath(c, openShell.Doc(), "//w:OptionSet/w:Option[@Name=\"WINRS_NOPROFILE\"]", "FALSE")
+	assertXPath(c, openShell.Doc(), "//w:OptionSet/w:Option[@Name=\"WINRS_CODEPAGE\"]", "65001")
+	assertXPathNil(c, openShell.Doc(), "//rsp:Environment")
+	assertXPathNil(c, openShell.Doc(), "//rsp:WorkingDirectory")
+	assertXPathNil(c, openShell.Doc(), "//rsp:IdleTimeOut")
+}
+
+func (s *WinRMSuite) TestOpenShellRequestWithOptions(c *C) {
+	openShell := NewOpenShellRequestWithOptions("http://localhost", &ShellOptions{
+		WorkingDirectory: `C:\Program Files\App & Co`,
+		Environment:      map[string]string{"APP_ENV": "production", "PATH_EXTRA": `C:\tools`},
+		IdleTimeOut:      90 * time.Second,
+		Codepage:         437,
+		NoProfile:        true,
+		InputStreams:     []string{"stdin", "pr"},
+		OutputStreams:    []string{"stdout", "stderr", "debug"},
+	}, nil)
+	defer openShell.Free()
+
+	assertXPath(c, openShell.Doc(), "//w:OptionSet/w:Option[@Name=\"WINRS_NOPROFILE\"]", "TRUE")
+	assertXPath(c, openShell.Doc(), "//w:OptionSet/w:Option[@Name=\"WINRS_CODEPAGE\"]", "437")
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:WorkingDirectory", `C:\Program Files\App & Co`)
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:Environment/rsp:Variable[@Name=\"APP_ENV\"]", "production")
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:Environment/rsp:Variable[@Name=\"PATH_EXTRA\"]", `C:\tools`)
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:IdleTimeOut", "PT1M30S")
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:InputStreams", "stdin pr")
+	assertXPath(c, openShell.Doc(), "//rsp:Shell/rsp:OutputStreams", "stdout stderr debug")
 }
 
 func (s *WinRMSuite) TestDeleteShellRequest(c *C) {
@@ -74,6 +102,42 @@ func (s *WinRMSuite) TestGetOutputRequest(c *C) {
 	assertXPath(c, request.Doc(), "//rsp:Receive/rsp:DesiredStream[@CommandId=\"COMMANDID\"]", "stdout stderr")
 }
 
+func (s *WinRMSuite) TestResumeOutputRequest(c *C) {
+	request := NewResumeOutputRequest("http://localhost", "SHELLID", "COMMANDID", "stdout stderr", 42, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive")
+	assertXPath(c, request.Doc(), "//w:Selector[@Name=\"ShellId\"]", "SHELLID")
+	assertXPath(c, request.Doc(), "//rsp:Receive/@SequenceId", "42")
+	assertXPath(c, request.Doc(), "//rsp:Receive/rsp:DesiredStream[@CommandId=\"COMMANDID\"]", "stdout stderr")
+}
+
+func (s *WinRMSuite) TestDisconnectRequests(c *C) {
+	request := NewDisconnectRequest("http://localhost", "SHELLID", 2*time.Hour, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Disconnect")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd")
+	assertXPath(c, request.Doc(), "//w:Selector[@Name=\"ShellId\"]", "SHELLID")
+	assertXPath(c, request.Doc(), "//rsp:Disconnect/rsp:IdleTimeOut", "PT2H")
+
+	request = NewDisconnectRequest("http://localhost", "SHELLID", 0, nil)
+	defer request.Free()
+	assertXPathNil(c, request.Doc(), "//rsp:Disconnect/rsp:IdleTimeOut")
+
+	request = NewReconnectRequest("http://localhost", "SHELLID", nil)
+	defer request.Free()
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Reconnect")
+	assertXPath(c, request.Doc(), "//w:Selector[@Name=\"ShellId\"]", "SHELLID")
+	c.Assert(request.String(), Contains, "<rsp:Reconnect")
+
+	request = NewConnectRequest("http://localhost", "SHELLID", nil)
+	defer request.Free()
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Connect")
+	assertXPath(c, request.Doc(), "//w:Selector[@Name=\"ShellId\"]", "SHELLID")
+	c.Assert(request.String(), Contains, "<rsp:Connect")
+}
+
 func (s *WinRMSuite) TestSendInputRequest(c *C) {
 	request := NewSendInputRequest("http://localhost", "SHELLID", "COMMANDID", []byte{31, 32}, true, nil)
 	defer request.Free()
@@ -103,6 +167,168 @@ func (s *WinRMSuite) TestSignalRequest(c *C) {
 	assertXPath(c, request.Doc(), "//rsp:Signal[@CommandId=\"COMMANDID\"]/rsp:Code", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate")
 }
 
+func (s *WinRMSuite) TestSignalRequestWithCode(c *C) {
+	for _, code := range []string{SignalCtrlC, SignalCtrlBreak} {
+		request := NewSignalRequestWithCode("http://localhost", "SHELLID", "COMMANDID", code, nil)
+		defer request.Free()
+
+		assertXPath(c, request.Doc(), "//a:Action", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal")
+		assertXPath(c, request.Doc(), "//rsp:Signal[@CommandId=\"COMMANDID\"]/rsp:Code", code)
+	}
+}
+
+func (s *WinRMSuite) TestCreateRunspacePoolRequest(c *C) {
+	request := NewCreateRunspacePoolRequest("http://localhost", "SHELLID", []byte{31, 32}, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/powershell/Microsoft.PowerShell")
+	assertXPath(c, request.Doc(), "//w:Option[@Name=\"protocolversion\"]", "2.3")
+	assertXPath(c, request.Doc(), "//env:Body/rsp:Shell/@ShellId", "SHELLID")
+	assertXPath(c, request.Doc(), "//env:Body/rsp:Shell/rsp:InputStreams", "stdin pr")
+	assertXPath(c, request.Doc(), "//env:Body/rsp:Shell/rsp:OutputStreams", "stdout")
+	assertXPath(c, request.Doc(), "//env:Body/rsp:Shell/*[local-name()=\"creationXml\"]", "HyA=")
+}
+
+func (s *WinRMSuite) TestCreatePipelineRequest(c *C) {
+	request := NewCreatePipelineRequest("http://localhost", "SHELLID", "COMMANDID", []byte{31, 32}, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/powershell/Microsoft.PowerShell")
+	assertXPath(c, request.Doc(), "//w:Selector[@Name=\"ShellId\"]", "SHELLID")
+	assertXPath(c, request.Doc(), "//rsp:CommandLine/@CommandId", "COMMANDID")
+	assertXPath(c, request.Doc(), "//rsp:CommandLine/rsp:Arguments", "HyA=")
+}
+
+func (s *WinRMSuite) TestRunspacePoolReceiveRequest(c *C) {
+	request := NewRunspacePoolReceiveRequest("http://localhost", "SHELLID", "", nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/powershell/Microsoft.PowerShell")
+	assertXPath(c, request.Doc(), "//w:Option[@Name=\"WSMAN_CMDSHELL_OPTION_KEEPALIVE\"]", "TRUE")
+	assertXPath(c, request.Doc(), "//rsp:Receive/rsp:DesiredStream", "stdout")
+	assertXPathNil(c, request.Doc(), "//rsp:Receive/rsp:DesiredStream/@CommandId")
+
+	request = NewRunspacePoolReceiveRequest("http://localhost", "SHELLID", "COMMANDID", nil)
+	defer request.Free()
+	assertXPath(c, request.Doc(), "//rsp:Receive/rsp:DesiredStream[@CommandId=\"COMMANDID\"]", "stdout")
+}
+
+func (s *WinRMSuite) TestRunspacePoolSendRequest(c *C) {
+	request := NewRunspacePoolSendRequest("http://localhost", "SHELLID", "COMMANDID", []byte{31, 32}, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/powershell/Microsoft.PowerShell")
+	assertXPath(c, request.Doc(), "//rsp:Send/rsp:Stream[@CommandId=\"COMMANDID\"][@Name=\"stdin\"]", "HyA=")
+}
+
+func (s *WinRMSuite) TestRunspacePoolSignalAndDeleteRequest(c *C) {
+	request := NewRunspacePoolSignalRequest("http://localhost", "SHELLID", "COMMANDID", SignalTerminate, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/powershell/Microsoft.PowerShell")
+	assertXPath(c, request.Doc(), "//rsp:Signal[@CommandId=\"COMMANDID\"]/rsp:Code", "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/signal/terminate")
+
+	request = NewRunspacePoolSignalRequest("http://localhost", "SHELLID", "COMMANDID", SignalPowerShellCtrlC, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//rsp:Signal[@CommandId=\"COMMANDID\"]/rsp:Code", "http://schemas.microsoft.com/powershell/signal/crtl_c")
+
+	request = NewDeleteRunspacePoolRequest("http://localhost", "SHELLID", nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/powershell/Microsoft.PowerShell")
+	assertXPath(c, request.Doc(), "//w:Selector[@Name=\"ShellId\"]", "SHELLID")
+}
+
+func (s *WinRMSuite) TestEnumerateRequest(c *C) {
+	request := NewEnumerateRequest("http://localhost", "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*", WQLFilter("SELECT * FROM Win32_LogicalDisk WHERE Size > 0 AND Name <> 'C:'"), nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Enumerate")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/*")
+	assertXPath(c, request.Doc(), "//n:Enumerate/w:MaxElements", "32000")
+	assertXPath(c, request.Doc(), "//n:Enumerate/w:Filter[@Dialect=\"http://schemas.microsoft.com/wbem/wsman/1/WQL\"]", "SELECT * FROM Win32_LogicalDisk WHERE Size > 0 AND Name <> 'C:'")
+	assertXPath(c, request.Doc(), "//n:Enumerate/w:OptimizeEnumeration", "")
+
+	request = NewEnumerateRequest("http://localhost", "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service", nil, nil)
+	defer request.Free()
+	assertXPathNil(c, request.Doc(), "//n:Enumerate/w:Filter")
+}
+
+func (s *WinRMSuite) TestPullAndReleaseRequest(c *C) {
+	request := NewPullRequest("http://localhost", "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service", "uuid:CONTEXT", nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Pull")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service")
+	assertXPath(c, request.Doc(), "//n:Pull/n:EnumerationContext", "uuid:CONTEXT")
+	assertXPath(c, request.Doc(), "//n:Pull/n:MaxElements", "32000")
+
+	request = NewReleaseRequest("http://localhost", "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service", "uuid:CONTEXT", nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/enumeration/Release")
+	assertXPath(c, request.Doc(), "//n:Release/n:EnumerationContext", "uuid:CONTEXT")
+}
+
+func (s *WinRMSuite) TestTransferRequests(c *C) {
+	resourceURI := "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service"
+	request := NewGetRequest("http://localhost", resourceURI, map[string]string{"Name": "WinRM"}, map[string]string{"Locale": "en-US"}, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/transfer/Get")
+	assertXPath(c, request.Doc(), "//w:ResourceURI", resourceURI)
+	assertXPath(c, request.Doc(), "//w:SelectorSet/w:Selector[@Name=\"Name\"]", "WinRM")
+	assertXPath(c, request.Doc(), "//w:OptionSet/w:Option[@Name=\"Locale\"]", "en-US")
+
+	body := InstanceXML("Service", "http://schemas.microsoft.com/wbem/wsman/1/config/service", map[string]string{"MaxConnections": "300", "AllowUnencrypted": "false"})
+	request = NewPutRequest("http://localhost", "http://schemas.microsoft.com/wbem/wsman/1/config/service", nil, nil, body, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/transfer/Put")
+	assertXPathNil(c, request.Doc(), "//w:SelectorSet")
+	assertXPath(c, request.Doc(), "//env:Body/*[local-name()=\"Service\"]/*[local-name()=\"MaxConnections\"]", "300")
+
+	request = NewCreateRequest("http://localhost", "http://schemas.microsoft.com/wbem/wsman/1/config/listener", nil, body, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create")
+	assertXPath(c, request.Doc(), "//env:Body/*[local-name()=\"Service\"]/*[local-name()=\"AllowUnencrypted\"]", "false")
+
+	request = NewDeleteRequest("http://localhost", "http://schemas.microsoft.com/wbem/wsman/1/config/listener", map[string]string{"Transport": "HTTP", "Address": "*"}, nil, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete")
+	assertXPath(c, request.Doc(), "//w:SelectorSet/w:Selector[@Name=\"Transport\"]", "HTTP")
+	assertXPath(c, request.Doc(), "//w:SelectorSet/w:Selector[@Name=\"Address\"]", "*")
+}
+
+func (s *WinRMSuite) TestIdentifyRequest(c *C) {
+	request := NewIdentifyRequest()
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//env:Body/wsmid:Identify", "")
+	assertXPathNil(c, request.Doc(), "//a:Action")
+	assertXPathNil(c, request.Doc(), "//w:ResourceURI")
+}
+
+func (s *WinRMSuite) TestInvokeRequest(c *C) {
+	resourceURI := "http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Process"
+	request := NewInvokeRequest("http://localhost", resourceURI, map[string]string{"Handle": "42"}, "SetPriority", map[string]interface{}{"Priority": 64, "Names": []string{"a", "b"}}, nil)
+	defer request.Free()
+
+	assertXPath(c, request.Doc(), "//a:Action", resourceURI+"/SetPriority")
+	assertXPath(c, request.Doc(), "//w:SelectorSet/w:Selector[@Name=\"Handle\"]", "42")
+	assertXPath(c, request.Doc(), "//env:Body/*[local-name()=\"SetPriority_INPUT\"]/*[local-name()=\"Priority\"]", "64")
+	assertXPath(c, request.Doc(), "//env:Body/*[local-name()=\"SetPriority_INPUT\"]/*[local-name()=\"Names\"]", "a")
+	assertXPath(c, request.Doc(), "//env:Body/*[local-name()=\"SetPriority_INPUT\"]/*[local-name()=\"Names\"]", "b")
+}
+
 func assertXPath(c *C, doc *dom.Document, request string, expected string) {
 	nodes, err := parseXPath(doc, request)
 
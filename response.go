@@ -2,6 +2,7 @@ package winrm
 
 import (
 	"encoding/base64"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"strconv"
@@ -171,3 +172,219 @@ func ParseSlurpOutputResponse(response string, stream io.Writer, streamType stri
 
 	return finished, exitCode, err
 }
+
+// ReceiveOutput is the output of a command in a Receive response
+type ReceiveOutput struct {
+	// Streams maps the name of the output streams to their decoded content
+	Streams map[string][]byte
+	// SequenceID is the sequence id of the response, if HasSequenceID
+	SequenceID    uint64
+	HasSequenceID bool
+	// Done is set once the command exited with ExitCode
+	Done     bool
+	ExitCode int
+}
+
+// ParseReceiveResponse parses the output of a command in a Receive response
+func ParseReceiveResponse(response string) (*ReceiveOutput, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+
+	output := &ReceiveOutput{Streams: make(map[string][]byte)}
+	streams, err := xPath(doc, "//rsp:Stream")
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range streams {
+		content, err := base64.StdEncoding.DecodeString(node.ResValue())
+		if err != nil {
+			return nil, fmt.Errorf("decoding stream: %w", err)
+		}
+		name := attribute(node, "Name")
+		output.Streams[name] = append(output.Streams[name], content...)
+	}
+
+	output.SequenceID, output.HasSequenceID, err = receiveSequenceID(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	output.Done, _ = any(doc, "//*[@State='http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done']")
+	if exit, _ := first(doc, "//rsp:ExitCode"); output.Done && exit != "" {
+		if output.ExitCode, err = strconv.Atoi(exit); err != nil {
+			return nil, fmt.Errorf("parsing exit code: %w", err)
+		}
+	}
+	return output, nil
+}
+
+// ParseReceiveSequenceID returns the SequenceID of a Receive response, and whether the
+// response holds one
+func ParseReceiveSequenceID(response string) (uint64, bool, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return 0, false, err
+	}
+	return receiveSequenceID(doc)
+}
+
+func receiveSequenceID(doc tree.Node) (uint64, bool, error) {
+	sequence, err := first(doc, "//rsp:ReceiveResponse/@SequenceID")
+	if err != nil || sequence == "" {
+		return 0, false, err
+	}
+	sequenceID, err := strconv.ParseUint(sequence, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parsing sequence id %q: %w", sequence, err)
+	}
+	return sequenceID, true, nil
+}
+
+// ParseEnumerateResponse parses the response to an Enumerate or a Pull request, returning
+// the context of the enumeration, the items it holds and whether the enumeration is over
+func ParseEnumerateResponse(response string) (string, []*Instance, bool, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	enumerationContext, err := first(doc, "//n:EnumerationContext")
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	var nodes tree.NodeSet
+	for _, path := range []string{"//n:EnumerateResponse/w:Items/*", "//n:PullResponse/n:Items/*"} {
+		found, err := xPath(doc, path)
+		if err != nil {
+			return "", nil, false, err
+		}
+		nodes = append(nodes, found...)
+	}
+
+	items := make([]*Instance, 0, len(nodes))
+	for _, node := range nodes {
+		item, err := newInstance(node)
+		if err != nil {
+			return "", nil, false, err
+		}
+		items = append(items, item)
+	}
+
+	end, err := any(doc, "//w:EndOfSequence|//n:EndOfSequence")
+	return enumerationContext, items, end, err
+}
+
+// ParseTransferResponse parses the response to a Get or a Put request, returning the
+// instance held in its body, or nil when the body is empty
+func ParseTransferResponse(response string) (*Instance, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := xPath(doc, "//env:Body/*")
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+
+	return newInstance(nodes[0])
+}
+
+// ParseIdentifyResponse parses the response to an Identify request
+func ParseIdentifyResponse(response string) (*Identity, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+
+	identified, err := any(doc, "//wsmid:IdentifyResponse")
+	if err != nil {
+		return nil, err
+	}
+	if !identified {
+		return nil, fmt.Errorf("invalid identify response: no IdentifyResponse element")
+	}
+
+	identity := &Identity{}
+	for _, field := range []struct {
+		value *string
+		xpath string
+	}{
+		{&identity.ProtocolVersion, "//wsmid:IdentifyResponse/wsmid:ProtocolVersion"},
+		{&identity.ProductVendor, "//wsmid:IdentifyResponse/wsmid:ProductVendor"},
+		{&identity.ProductVersion, "//wsmid:IdentifyResponse/wsmid:ProductVersion"},
+	} {
+		value, err := first(doc, field.xpath)
+		if err != nil {
+			return nil, err
+		}
+		*field.value = strings.TrimSpace(value)
+	}
+	return identity, nil
+}
+
+// ParseResourceCreatedResponse parses the response to a Create request, returning the
+// reference to the created instance
+func ParseResourceCreatedResponse(response string) (*EndpointReference, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := any(doc, "//x:ResourceCreated")
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, fmt.Errorf("invalid create response: no ResourceCreated element")
+	}
+
+	reference := &EndpointReference{Selectors: make(map[string]string)}
+	if reference.Address, err = first(doc, "//x:ResourceCreated/a:Address"); err != nil {
+		return nil, err
+	}
+	if reference.ResourceURI, err = first(doc, "//x:ResourceCreated//w:ResourceURI"); err != nil {
+		return nil, err
+	}
+
+	selectors, err := xPath(doc, "//x:ResourceCreated//w:Selector")
+	if err != nil {
+		return nil, err
+	}
+	for _, selector := range selectors {
+		elem, ok := selector.(tree.Elem)
+		if !ok {
+			continue
+		}
+		start, _ := elem.GetToken().(xml.StartElement)
+		for _, attr := range start.Attr {
+			if attr.Name.Local == "Name" {
+				reference.Selectors[attr.Value] = selector.ResValue()
+			}
+		}
+	}
+
+	return reference, nil
+}
+
+// ParseInvokeResponse parses the response to the invocation of method, returning the
+// method_OUTPUT element holding its output parameters
+func ParseInvokeResponse(response string, method string) (*Instance, error) {
+	doc, err := xmltree.ParseXML(strings.NewReader(response))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := xPath(doc, fmt.Sprintf("//env:Body/*[local-name()=%q]", method+"_OUTPUT"))
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("invalid invoke response: no %s_OUTPUT element", method)
+	}
+
+	return newInstance(nodes[0])
+}
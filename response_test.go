@@ -3,6 +3,7 @@ package winrm
 import (
 	"bytes"
 	"errors"
+	"strings"
 
 	. "gopkg.in/check.v1"
 )
@@ -84,3 +85,36 @@ func (s *WinRMSuite) TestDoneSlurpOutputResponse(c *C) {
 	c.Assert("", Equals, stdout.String())
 	c.Assert("", Equals, stderr.String())
 }
+
+func (s *WinRMSuite) TestReceiveSequenceID(c *C) {
+	response := strings.Replace(outputResponse, "<rsp:ReceiveResponse>", "<rsp:ReceiveResponse SequenceID=\"7\">", 1)
+	sequenceID, ok, err := ParseReceiveSequenceID(response)
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, true)
+	c.Check(sequenceID, Equals, uint64(7))
+
+	_, ok, err = ParseReceiveSequenceID(outputResponse)
+	c.Assert(err, IsNil)
+	c.Check(ok, Equals, false)
+}
+
+func (s *WinRMSuite) TestReceiveResponse(c *C) {
+	response := strings.Replace(outputResponse, "<rsp:ReceiveResponse>", "<rsp:ReceiveResponse SequenceID=\"3\">", 1)
+	response = strings.Replace(response, "Name=\"stderr\"", "Name=\"progress\"", 1)
+	output, err := ParseReceiveResponse(response)
+	c.Assert(err, IsNil)
+	c.Check(output.Streams, DeepEquals, map[string][]byte{
+		"stdout":   []byte("That's all folks!!!"),
+		"progress": []byte("This is stderr, I'm pretty sure!"),
+	})
+	c.Check(output.HasSequenceID, Equals, true)
+	c.Check(output.SequenceID, Equals, uint64(3))
+	c.Check(output.Done, Equals, false)
+
+	output, err = ParseReceiveResponse(doneCommandResponse)
+	c.Assert(err, IsNil)
+	c.Check(output.Streams, HasLen, 0)
+	c.Check(output.HasSequenceID, Equals, false)
+	c.Check(output.Done, Equals, true)
+	c.Check(output.ExitCode, Equals, 123)
+}
@@ -0,0 +1,306 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gofrs/uuid"
+	"github.com/masterzen/winrm/clixml"
+	"github.com/masterzen/winrm/psrp"
+)
+
+// runspacePoolEnvelopeOverhead is the room kept in an envelope for the SOAP headers
+// around base64 encoded PSRP fragments
+const runspacePoolEnvelopeOverhead = 2048
+
+// SignalPowerShellCtrlC stops a pipeline of a RunspacePool, see NewRunspacePoolSignalRequest,
+// the misspelling being the one of the protocol. It doesn't apply to the commands of a Shell.
+const SignalPowerShellCtrlC = "http://schemas.microsoft.com/powershell/signal/crtl_c"
+
+// RunspacePool is the local view of a remote PowerShell runspace pool.
+// Contrary to a Shell, which runs processes through cmd.exe, a RunspacePool
+// talks the PowerShell Remoting Protocol (MS-PSRP): it is opened once and then
+// runs any number of pipelines without spawning a powershell.exe per command.
+type RunspacePool struct {
+	client       *Client
+	id           uuid.UUID
+	shellID      string
+	fragmenter   *psrp.Fragmenter
+	defragmenter *psrp.Defragmenter
+
+	mutex sync.Mutex
+	state psrp.RunspacePoolState
+}
+
+// PipelineResult holds what a pipeline sent back, each stream being a list of PSRP
+// messages whose Data is the CLIXML serialization of the object and whose String()
+// is its textual representation
+type PipelineResult struct {
+	State       psrp.PipelineState
+	Output      []*psrp.Message
+	Errors      []*psrp.Message
+	Warnings    []*psrp.Message
+	Verbose     []*psrp.Message
+	Debug       []*psrp.Message
+	Information []*psrp.Message
+	Progress    []*psrp.Message
+}
+
+// Objects returns the deserialized output objects of the pipeline, see clixml.UnmarshalValue
+func (r *PipelineResult) Objects() ([]interface{}, error) {
+	objects := make([]interface{}, 0, len(r.Output))
+	for _, message := range r.Output {
+		object, err := clixml.UnmarshalValue(message.Data)
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// ErrorRecords returns the deserialized error records written by the pipeline
+func (r *PipelineResult) ErrorRecords() ([]*clixml.ErrorRecord, error) {
+	records := make([]*clixml.ErrorRecord, 0, len(r.Errors))
+	for _, message := range r.Errors {
+		object, err := clixml.UnmarshalValue(message.Data)
+		if err != nil {
+			return records, err
+		}
+		record, ok := object.(*clixml.ErrorRecord)
+		if !ok {
+			return records, fmt.Errorf("message is not an error record: %s", message)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// CreateRunspacePool opens a runspace pool on the remote host,
+// which is the prealable for invoking PowerShell pipelines.
+func (c *Client) CreateRunspacePool(ctx context.Context) (*RunspacePool, error) {
+	pool := &RunspacePool{
+		client:       c,
+		id:           uuid.Must(uuid.NewV4()),
+		fragmenter:   psrp.NewFragmenter((c.Parameters.EnvelopeSize - runspacePoolEnvelopeOverhead) / 4 * 3),
+		defragmenter: psrp.NewDefragmenter(),
+		state:        psrp.RunspacePoolBeforeOpen,
+	}
+
+	var creationXML []byte
+	for _, message := range []*psrp.Message{
+		psrp.NewSessionCapabilityMessage(pool.id),
+		psrp.NewInitRunspacePoolMessage(pool.id, 1, 1),
+	} {
+		fragments, err := pool.fragmenter.Fragment(message)
+		if err != nil {
+			return nil, err
+		}
+		creationXML = append(creationXML, bytes.Join(fragments, nil)...)
+	}
+
+	request := NewCreateRunspacePoolRequest(c.url, strings.ToUpper(pool.id.String()), creationXML, &c.Parameters)
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.shellID, err = ParseOpenShellResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	for pool.getState() != psrp.RunspacePoolOpened {
+		if err := ctx.Err(); err != nil {
+			_ = pool.Close()
+			return nil, err
+		}
+
+		messages, _, err := pool.receive(ctx, "", pool.defragmenter)
+		if err != nil {
+			_ = pool.Close()
+			return nil, err
+		}
+
+		for _, message := range messages {
+			if message.Type != psrp.RunspacePoolStateMessage {
+				continue
+			}
+			state, err := message.RunspacePoolState()
+			if err != nil {
+				_ = pool.Close()
+				return nil, err
+			}
+			pool.setState(state)
+			if state == psrp.RunspacePoolBroken || state == psrp.RunspacePoolClosed {
+				reason, _ := message.Property("ExceptionAsErrorRecord")
+				_ = pool.Close()
+				return nil, fmt.Errorf("runspace pool could not be opened: %s", reason)
+			}
+		}
+	}
+
+	return pool, nil
+}
+
+func (p *RunspacePool) getState() psrp.RunspacePoolState {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.state
+}
+
+func (p *RunspacePool) setState(state psrp.RunspacePoolState) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.state = state
+}
+
+// ID returns the runspace pool id, which is also the id of the shell hosting it
+func (p *RunspacePool) ID() string {
+	return p.shellID
+}
+
+// Invoke runs the PowerShell script in a new pipeline of the runspace pool
+// and waits for its completion. If the context is canceled, the pipeline is stopped.
+// A pipeline that fails returns its partial result along with an error.
+func (p *RunspacePool) Invoke(ctx context.Context, script string) (*PipelineResult, error) {
+	if p.getState() != psrp.RunspacePoolOpened {
+		return nil, errors.New("runspace pool is not opened")
+	}
+
+	pid := uuid.Must(uuid.NewV4())
+	fragments, err := p.fragmenter.Fragment(psrp.NewCreatePipelineMessage(p.id, pid, script))
+	if err != nil {
+		return nil, err
+	}
+
+	request := NewCreatePipelineRequest(p.client.url, p.shellID, strings.ToUpper(pid.String()), fragments[0], &p.client.Parameters)
+	defer request.Free()
+
+	response, err := p.client.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	commandID, err := ParseExecuteCommandResponse(response)
+	if err != nil {
+		return nil, err
+	}
+	result := &PipelineResult{State: psrp.PipelineRunning}
+	defer func() {
+		// an interrupted pipeline is stopped, even when ctx is canceled, a finished one released
+		code := SignalTerminate
+		if !result.State.Terminal() {
+			code = SignalPowerShellCtrlC
+		}
+		p.signal(context.WithoutCancel(ctx), commandID, code)
+	}()
+
+	for _, fragment := range fragments[1:] {
+		send := NewRunspacePoolSendRequest(p.client.url, p.shellID, commandID, fragment, &p.client.Parameters)
+		_, err := p.client.sendRequestWithContext(ctx, send)
+		send.Free()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	defragmenter := psrp.NewDefragmenter()
+	for !result.State.Terminal() {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		messages, done, err := p.receive(ctx, commandID, defragmenter)
+		if err != nil {
+			return result, err
+		}
+
+		for _, message := range messages {
+			switch message.Type {
+			case psrp.PipelineOutput:
+				result.Output = append(result.Output, message)
+			case psrp.ErrorRecord:
+				result.Errors = append(result.Errors, message)
+			case psrp.WarningRecord:
+				result.Warnings = append(result.Warnings, message)
+			case psrp.VerboseRecord:
+				result.Verbose = append(result.Verbose, message)
+			case psrp.DebugRecord:
+				result.Debug = append(result.Debug, message)
+			case psrp.InformationRecord:
+				result.Information = append(result.Information, message)
+			case psrp.ProgressRecord:
+				result.Progress = append(result.Progress, message)
+			case psrp.PipelineStateMessage:
+				if result.State, err = message.PipelineState(); err != nil {
+					return result, err
+				}
+				if result.State == psrp.PipelineFailed {
+					reason, _ := message.Property("ExceptionAsErrorRecord")
+					return result, fmt.Errorf("pipeline failed: %s", reason)
+				}
+			}
+		}
+
+		if done && !result.State.Terminal() {
+			return result, errors.New("pipeline ended without reporting its state")
+		}
+	}
+
+	return result, nil
+}
+
+// receive fetches the pending PSRP messages of the runspace pool, or of its pipeline commandID
+func (p *RunspacePool) receive(ctx context.Context, commandID string, defragmenter *psrp.Defragmenter) ([]*psrp.Message, bool, error) {
+	request := NewRunspacePoolReceiveRequest(p.client.url, p.shellID, commandID, &p.client.Parameters)
+	defer request.Free()
+
+	response, err := p.client.sendRequestWithContext(ctx, request)
+	if err != nil {
+		if isOperationTimeout(err) {
+			// nothing was sent by the server in time, poll again
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	var stream bytes.Buffer
+	done, _, err := ParseSlurpOutputResponse(response, &stream, "stdout")
+	if err != nil {
+		return nil, false, err
+	}
+
+	messages, err := defragmenter.Write(stream.Bytes())
+	return messages, done, err
+}
+
+// signal sends the signal code to the pipeline commandID, such as SignalTerminate releasing it on the server
+func (p *RunspacePool) signal(ctx context.Context, commandID, code string) {
+	request := NewRunspacePoolSignalRequest(p.client.url, p.shellID, commandID, code, &p.client.Parameters)
+	defer request.Free()
+
+	_, _ = p.client.sendRequestWithContext(ctx, request)
+}
+
+// Close will close this runspace pool. No pipelines can be invoked once the runspace pool is closed.
+func (p *RunspacePool) Close() error {
+	return p.CloseWithContext(context.Background())
+}
+
+// CloseWithContext will close this runspace pool, canceling the request with ctx.
+// No pipelines can be invoked once the runspace pool is closed.
+func (p *RunspacePool) CloseWithContext(ctx context.Context) error {
+	request := NewDeleteRunspacePoolRequest(p.client.url, p.shellID, &p.client.Parameters)
+	defer request.Free()
+
+	p.setState(psrp.RunspacePoolClosed)
+	_, err := p.client.sendRequestWithContext(ctx, request)
+	return err
+}
@@ -0,0 +1,259 @@
+package winrm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterzen/winrm/psrp"
+	. "gopkg.in/check.v1"
+)
+
+// recorded PSRP exchange: the streams below are the base64 fragments sent by the server
+var (
+	createRunspacePoolResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:x="http://schemas.xmlsoap.org/ws/2004/09/transfer" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd"><s:Header><a:Action>http://schemas.xmlsoap.org/ws/2004/09/transfer/CreateResponse</a:Action><a:MessageID>uuid:0A5E8C5B-2C1D-4A7F-8C39-3E1AE6F3B1A2</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:6AB1F3C4-1D38-4F4E-8A4B-0B5D29D5F0E7</a:RelatesTo></s:Header><s:Body><x:ResourceCreated><a:Address>http://127.0.0.1:5985/wsman</a:Address><a:ReferenceParameters><w:ResourceURI>http://schemas.microsoft.com/powershell/Microsoft.PowerShell</w:ResourceURI><w:SelectorSet><w:Selector Name="ShellId">E6A1D9A5-5F2B-4B6E-9A0A-0B6F1A2C3D4E</w:Selector></w:SelectorSet></a:ReferenceParameters></x:ResourceCreated><rsp:Shell><rsp:ShellId>E6A1D9A5-5F2B-4B6E-9A0A-0B6F1A2C3D4E</rsp:ShellId><rsp:ResourceUri>http://schemas.microsoft.com/powershell/Microsoft.PowerShell</rsp:ResourceUri><rsp:Owner>Administrator</rsp:Owner><rsp:ClientIP>127.0.0.1</rsp:ClientIP><rsp:IdleTimeOut>PT7200.000S</rsp:IdleTimeOut><rsp:InputStreams>stdin pr</rsp:InputStreams><rsp:OutputStreams>stdout</rsp:OutputStreams><rsp:ShellRunTime>P0DT0H0M0S</rsp:ShellRunTime><rsp:ShellInactivity>P0DT0H0M0S</rsp:ShellInactivity></rsp:Shell></s:Body></s:Envelope>`
+
+	runspacePoolOpenedResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd"><s:Header><a:Action>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse</a:Action><a:MessageID>uuid:5B2D7E1C-4F0A-4C8B-9E6D-7A1C3B5D9F0E</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:9D3A5C7E-2B4F-4A6C-8E0D-1F3B5D7A9C2E</a:RelatesTo></s:Header><s:Body><rsp:ReceiveResponse><rsp:Stream Name="stdout">AAAAAAAAAAEAAAAAAAAAAAMAAAEAAQAAAAkQAgCl2aHmK19uS5oKC28aLD1OAAAAAAAAAAAAAAAAAAAAADxPYmogUmVmSWQ9IjAiPjxNUz48T2JqIE49IkFwcGxpY2F0aW9uUHJpdmF0ZURhdGEiIFJlZklkPSIxIj48VE4gUmVmSWQ9IjAiPjxUPlN5c3RlbS5NYW5hZ2VtZW50LkF1dG9tYXRpb24uUFNQcmltaXRpdmVEaWN0aW9uYXJ5PC9UPjxUPlN5c3RlbS5Db2xsZWN0aW9ucy5IYXNodGFibGU8L1Q+PFQ+U3lzdGVtLk9iamVjdDwvVD48L1ROPjxEQ1QgLz48L09iaj48L01TPjwvT2JqPgAAAAAAAAACAAAAAAAAAAADAAAAZAEAAAAFEAIApdmh5itfbkuaCgtvGiw9TgAAAAAAAAAAAAAAAAAAAAA8T2JqIFJlZklkPSIwIj48TVM+PEkzMiBOPSJSdW5zcGFjZVN0YXRlIj4yPC9JMzI+PC9NUz48L09iaj4=</rsp:Stream></rsp:ReceiveResponse></s:Body></s:Envelope>`
+
+	createPipelineResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd"><s:Header><a:Action>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandResponse</a:Action><a:MessageID>uuid:7C1E3A5B-9D2F-4B6A-8C0E-4D6F8A1B3C5E</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:2E4A6C8D-1F3B-4D5A-9E7C-0B2D4F6A8C1E</a:RelatesTo></s:Header><s:Body><rsp:CommandResponse><rsp:CommandId>3C5E7D2A-1B4F-4E8A-9C6D-2F0E1A3B5C7D</rsp:CommandId></rsp:CommandResponse></s:Body></s:Envelope>`
+
+	pipelineCompletedResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd"><s:Header><a:Action>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse</a:Action><a:MessageID>uuid:8D2F4B6C-0E3A-4C7B-9D1F-5E7A9B2C4D6F</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:3F5B7D9E-2A4C-4E6B-8F0D-1C3E5A7B9D2F</a:RelatesTo></s:Header><s:Body><rsp:ReceiveResponse><rsp:Stream Name="stdout" CommandId="3C5E7D2A-1B4F-4E8A-9C6D-2F0E1A3B5C7D">AAAAAAAAAAMAAAAAAAAAAAMAAABHAQAAAAQQBACl2aHmK19uS5oKC28aLD1OKn1ePE8bik6cbS8OGjtcfTxTPmhlbGxvX3gwMDBEX194MDAwQV93b3JsZDwvUz4AAAAAAAAABAAAAAAAAAAAAwAAASYBAAAABRAEAKXZoeYrX25LmgoLbxosPU4qfV48TxuKTpxtLw4aO1x977u/PE9iaiBSZWZJZD0iMCI+PFROIFJlZklkPSIwIj48VD5TeXN0ZW0uTWFuYWdlbWVudC5BdXRvbWF0aW9uLkVycm9yUmVjb3JkPC9UPjxUPlN5c3RlbS5PYmplY3Q8L1Q+PC9UTj48VG9TdHJpbmc+c29tZXRoaW5nIHdlbnQgd3Jvbmc8L1RvU3RyaW5nPjxNUz48T2JqIE49IkV4Y2VwdGlvbiIgUmVmSWQ9IjEiPjxUb1N0cmluZz5TeXN0ZW0uRXhjZXB0aW9uOiBzb21ldGhpbmcgd2VudCB3cm9uZzwvVG9TdHJpbmc+PC9PYmo+PC9NUz48L09iaj4AAAAAAAAABQAAAAAAAAAAAwAAAGQBAAAABhAEAKXZoeYrX25LmgoLbxosPU4qfV48TxuKTpxtLw4aO1x9PE9iaiBSZWZJZD0iMCI+PE1TPjxJMzIgTj0iUGlwZWxpbmVTdGF0ZSI+NDwvSTMyPjwvTVM+PC9PYmo+</rsp:Stream><rsp:CommandState CommandId="3C5E7D2A-1B4F-4E8A-9C6D-2F0E1A3B5C7D" State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"></rsp:CommandState></rsp:ReceiveResponse></s:Body></s:Envelope>`
+
+	pipelineFailedResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd"><s:Header><a:Action>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/ReceiveResponse</a:Action><a:MessageID>uuid:1A3C5E7B-9D2F-4A6C-8E0B-2D4F6A8C1E3B</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:4B6D8F1A-3C5E-4A7B-9D2F-0E2A4C6E8B1D</a:RelatesTo></s:Header><s:Body><rsp:ReceiveResponse><rsp:Stream Name="stdout" CommandId="3C5E7D2A-1B4F-4E8A-9C6D-2F0E1A3B5C7D">AAAAAAAAAAYAAAAAAAAAAAMAAAFAAQAAAAYQBACl2aHmK19uS5oKC28aLD1OKn1ePE8bik6cbS8OGjtcfTxPYmogUmVmSWQ9IjAiPjxNUz48STMyIE49IlBpcGVsaW5lU3RhdGUiPjU8L0kzMj48T2JqIE49IkV4Y2VwdGlvbkFzRXJyb3JSZWNvcmQiIFJlZklkPSIxIj48VE4gUmVmSWQ9IjAiPjxUPlN5c3RlbS5NYW5hZ2VtZW50LkF1dG9tYXRpb24uRXJyb3JSZWNvcmQ8L1Q+PFQ+U3lzdGVtLk9iamVjdDwvVD48L1ROPjxUb1N0cmluZz5UaGUgdGVybSAnR2V0LU5vdGhpbmcnIGlzIG5vdCByZWNvZ25pemVkIGFzIHRoZSBuYW1lIG9mIGEgY21kbGV0LjwvVG9TdHJpbmc+PC9PYmo+PC9NUz48L09iaj4=</rsp:Stream><rsp:CommandState CommandId="3C5E7D2A-1B4F-4E8A-9C6D-2F0E1A3B5C7D" State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/Done"></rsp:CommandState></rsp:ReceiveResponse></s:Body></s:Envelope>`
+)
+
+// runPSRPFakeServer replays a recorded PSRP exchange, answering each pipeline with pipelineResponse
+func runPSRPFakeServer(c *C, pipelineResponse string) (*httptest.Server, string, int, error) {
+	return StartTestServer(psrpFakeHandler(c, pipelineResponse))
+}
+
+func psrpFakeHandler(c *C, pipelineResponse string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		b, err := io.ReadAll(r.Body)
+		defer r.Body.Close()
+		c.Assert(err, IsNil)
+		body := string(b)
+		c.Assert(body, Contains, ResourceURIPowerShell)
+
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			c.Assert(body, Contains, "creationXml")
+			fmt.Fprintln(w, createRunspacePoolResponse)
+		case strings.Contains(body, "shell/Receive") && !strings.Contains(body, "CommandId"):
+			fmt.Fprintln(w, runspacePoolOpenedResponse)
+		case strings.Contains(body, "shell/Command"):
+			fmt.Fprintln(w, createPipelineResponse)
+		case strings.Contains(body, "shell/Receive"):
+			fmt.Fprintln(w, pipelineResponse)
+		case strings.Contains(body, "shell/Signal"):
+			w.WriteHeader(http.StatusOK)
+		case strings.Contains(body, "transfer/Delete"):
+			w.WriteHeader(http.StatusOK)
+		default:
+			c.Errorf("unexpected request %s", body)
+		}
+	})
+}
+
+func (s *WinRMSuite) TestRunspacePoolInvoke(c *C) {
+	ts, host, port, err := runPSRPFakeServer(c, pipelineCompletedResponse)
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	pool, err := client.CreateRunspacePool(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(pool.ID(), Equals, "E6A1D9A5-5F2B-4B6E-9A0A-0B6F1A2C3D4E")
+
+	// the same runspace pool runs several pipelines
+	for i := 0; i < 2; i++ {
+		result, err := pool.Invoke(context.Background(), "Write-Output \"hello`r`nworld\"; Write-Error 'something went wrong'")
+		c.Assert(err, IsNil)
+		c.Assert(result.State, Equals, psrp.PipelineCompleted)
+		c.Assert(result.Output, HasLen, 1)
+		c.Assert(result.Output[0].String(), Equals, "hello\r\nworld")
+		c.Assert(result.Errors, HasLen, 1)
+		c.Assert(result.Errors[0].String(), Equals, "something went wrong")
+
+		objects, err := result.Objects()
+		c.Assert(err, IsNil)
+		c.Assert(objects, DeepEquals, []interface{}{"hello\r\nworld"})
+
+		records, err := result.ErrorRecords()
+		c.Assert(err, IsNil)
+		c.Assert(records, HasLen, 1)
+		c.Assert(records[0].Error(), Equals, "something went wrong")
+	}
+
+	c.Assert(pool.Close(), IsNil)
+}
+
+func (s *WinRMSuite) TestRunspacePoolInvokeFailure(c *C) {
+	ts, host, port, err := runPSRPFakeServer(c, pipelineFailedResponse)
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	pool, err := client.CreateRunspacePool(context.Background())
+	c.Assert(err, IsNil)
+	defer pool.Close()
+
+	result, err := pool.Invoke(context.Background(), "Get-Nothing")
+	c.Assert(err, ErrorMatches, "pipeline failed: The term 'Get-Nothing' is not recognized.*")
+	c.Assert(result.State, Equals, psrp.PipelineFailed)
+}
+
+func (s *WinRMSuite) TestRunspacePoolInvokeLargeScript(c *C) {
+	var sent int
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/soap+xml")
+		b, _ := io.ReadAll(r.Body)
+		body := string(b)
+		switch {
+		case strings.Contains(body, "transfer/Create"):
+			fmt.Fprintln(w, createRunspacePoolResponse)
+		case strings.Contains(body, "shell/Receive") && !strings.Contains(body, "CommandId"):
+			fmt.Fprintln(w, runspacePoolOpenedResponse)
+		case strings.Contains(body, "shell/Command"):
+			fmt.Fprintln(w, createPipelineResponse)
+		case strings.Contains(body, "shell/Send"):
+			sent++
+			w.WriteHeader(http.StatusOK)
+		case strings.Contains(body, "shell/Receive"):
+			fmt.Fprintln(w, pipelineCompletedResponse)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClientWithParameters(endpoint, "Administrator", "v3r1S3cre7", NewParameters("PT60S", "en-US", 8192))
+	c.Assert(err, IsNil)
+
+	pool, err := client.CreateRunspacePool(context.Background())
+	c.Assert(err, IsNil)
+	defer pool.Close()
+
+	_, err = pool.Invoke(context.Background(), strings.Repeat("Write-Output 'padding';", 1000))
+	c.Assert(err, IsNil)
+	c.Assert(sent > 0, Equals, true)
+}
+
+func (s *WinRMSuite) TestRunspacePoolInvokeCanceled(c *C) {
+	ts, host, port, err := runPSRPFakeServer(c, pipelineCompletedResponse)
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	pool, err := client.CreateRunspacePool(context.Background())
+	c.Assert(err, IsNil)
+	defer pool.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = pool.Invoke(ctx, "Start-Sleep 3600")
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *WinRMSuite) TestRunspacePoolInvokeConcurrentClose(c *C) {
+	ts, host, port, err := runPSRPFakeServer(c, pipelineCompletedResponse)
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	pool, err := client.CreateRunspacePool(context.Background())
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = pool.Invoke(context.Background(), "Write-Output 'hello'")
+		}()
+	}
+	c.Assert(pool.Close(), IsNil)
+	wg.Wait()
+
+	_, err = pool.Invoke(context.Background(), "Write-Output 'hello'")
+	c.Assert(err, ErrorMatches, "runspace pool is not opened")
+}
+
+func (s *WinRMSuite) TestRunspacePoolCloseWithContext(c *C) {
+	ts, host, port, err := runPSRPFakeServer(c, pipelineCompletedResponse)
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	pool, err := client.CreateRunspacePool(context.Background())
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Assert(pool.CloseWithContext(ctx), Equals, context.Canceled)
+	_, err = pool.Invoke(context.Background(), "Write-Output 'hello'")
+	c.Assert(err, ErrorMatches, "runspace pool is not opened")
+	c.Assert(pool.CloseWithContext(context.Background()), IsNil)
+}
+
+func (s *WinRMSuite) TestRunspacePoolInvokeStopsPipeline(c *C) {
+	// the pipeline keeps running, its output holding no state
+	handler := psrpFakeHandler(c, runspacePoolOpenedResponse)
+	var mutex sync.Mutex
+	var signals []string
+	signal := regexp.MustCompile(`<rsp:Code>([^<]*)</rsp:Code>`)
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		if match := signal.FindStringSubmatch(string(b)); match != nil {
+			mutex.Lock()
+			signals = append(signals, match[1])
+			mutex.Unlock()
+		}
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		handler(w, r)
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+	pool, err := client.CreateRunspacePool(context.Background())
+	c.Assert(err, IsNil)
+	defer pool.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = pool.Invoke(ctx, "Start-Sleep 3600")
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	c.Check(signals, DeepEquals, []string{SignalPowerShellCtrlC})
+}
@@ -1,11 +1,37 @@
 package winrm
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Shell is the local view of a WinRM Shell of a given Client
 type Shell struct {
 	client *Client
 	id     string
+	// outputStreams are the output streams received for the commands, stdout and stderr
+	// when empty
+	outputStreams []string
+}
+
+// ID returns the id of the shell, which allows connecting to it from another client once it
+// is disconnected, see Client.ConnectShell
+func (s *Shell) ID() string {
+	return s.id
+}
+
+// SetOutputStreams selects the output streams received for the commands executed afterwards,
+// which must be output streams of the shell, e.g. the OutputStreams of its ShellOptions, or a
+// subset of them. Streams other than stdout and stderr are read with Command.Stream.
+func (s *Shell) SetOutputStreams(streams ...string) {
+	s.outputStreams = streams
+}
+
+func (s *Shell) receivedStreams() []string {
+	if len(s.outputStreams) == 0 {
+		return []string{"stdout", "stderr"}
+	}
+	return s.outputStreams
 }
 
 // Execute command on the given Shell, returning either an error or a Command
@@ -17,10 +43,11 @@ func (s *Shell) Execute(command string, arguments ...string) (*Command, error) {
 
 // ExecuteWithContext command on the given Shell, returning either an error or a Command
 func (s *Shell) ExecuteWithContext(ctx context.Context, command string, arguments ...string) (*Command, error) {
+	started := time.Now()
 	request := NewExecuteCommandRequest(s.client.url, s.id, command, arguments, &s.client.Parameters)
 	defer request.Free()
 
-	response, err := s.client.sendRequest(request)
+	response, err := s.client.sendRequestWithContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -30,16 +57,50 @@ func (s *Shell) ExecuteWithContext(ctx context.Context, command string, argument
 		return nil, err
 	}
 
-	cmd := newCommand(ctx, s, commandID)
+	cmd := newCommand(ctx, s, commandID, started)
 
 	return cmd, nil
 }
 
 // Close will terminate this shell. No commands can be issued once the shell is closed.
 func (s *Shell) Close() error {
+	return s.CloseWithContext(context.Background())
+}
+
+// CloseWithContext will terminate this shell, canceling the request with ctx.
+// No commands can be issued once the shell is closed.
+func (s *Shell) CloseWithContext(ctx context.Context) error {
 	request := NewDeleteShellRequest(s.client.url, s.id, &s.client.Parameters)
 	defer request.Free()
 
-	_, err := s.client.sendRequest(request)
+	_, err := s.client.sendRequestWithContext(ctx, request)
 	return err
 }
+
+// Disconnect disconnects the client from this shell, the server keeping the shell and its
+// commands running for idleTimeOut, or the IdleTimeOut of the shell when zero. The commands
+// of the shell stop receiving their output, which is resumed with ResumeCommand once the shell
+// is reconnected with Reconnect, or connected by another client with Client.ConnectShell.
+func (s *Shell) Disconnect(ctx context.Context, idleTimeOut time.Duration) error {
+	request := NewDisconnectRequest(s.client.url, s.id, idleTimeOut, &s.client.Parameters)
+	defer request.Free()
+
+	_, err := s.client.sendRequestWithContext(ctx, request)
+	return err
+}
+
+// Reconnect reconnects the client to this shell after Disconnect
+func (s *Shell) Reconnect(ctx context.Context) error {
+	request := NewReconnectRequest(s.client.url, s.id, &s.client.Parameters)
+	defer request.Free()
+
+	_, err := s.client.sendRequestWithContext(ctx, request)
+	return err
+}
+
+// ResumeCommand resumes receiving the output of the command commandID of this reconnected
+// shell, starting at the output sequenceID, which is the SequenceID of the Command before the
+// shell was disconnected, or 0 to receive the output buffered by the server
+func (s *Shell) ResumeCommand(ctx context.Context, commandID string, sequenceID uint64) *Command {
+	return newResumedCommand(ctx, s, commandID, sequenceID)
+}
@@ -0,0 +1,77 @@
+package winrm
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultCodepage is the codepage of the shells, UTF-8, unless ShellOptions sets another one
+const DefaultCodepage = 65001
+
+// ShellOptions configures the shell created by CreateShellWithOptions.
+// The zero value gives the same shell as CreateShell.
+type ShellOptions struct {
+	// WorkingDirectory is the directory the commands start in, the user profile by default
+	WorkingDirectory string
+	// Environment holds the variables added to the environment of the commands
+	Environment map[string]string
+	// IdleTimeOut is the time after which the server deletes the shell if it isn't used,
+	// the server default (winrm/config/winrs IdleTimeout) being used when zero
+	IdleTimeOut time.Duration
+	// Codepage is the codepage of the console of the shell, DefaultCodepage when zero
+	Codepage int
+	// NoProfile prevents loading the user profile when creating the shell
+	NoProfile bool
+	// InputStreams are the names of the input streams of the shell, stdin by default
+	InputStreams []string
+	// OutputStreams are the names of the output streams of the shell, stdout and stderr
+	// by default. Commands executed with ExecuteWithContext read stdout and stderr,
+	// which must then be part of the custom streams.
+	OutputStreams []string
+}
+
+func (o *ShellOptions) inputStreams() []string {
+	if len(o.InputStreams) == 0 {
+		return []string{"stdin"}
+	}
+	return o.InputStreams
+}
+
+func (o *ShellOptions) outputStreams() []string {
+	if len(o.OutputStreams) == 0 {
+		return []string{"stdout", "stderr"}
+	}
+	return o.OutputStreams
+}
+
+// CreateShellWithOptions creates a WinRM Shell configured by options, so that its commands run
+// in the given directory with the given environment without cd or set prefixes:
+//
+//	shell, err := client.CreateShellWithOptions(ctx, &winrm.ShellOptions{
+//		WorkingDirectory: `C:\app`,
+//		Environment:      map[string]string{"APP_ENV": "production"},
+//	})
+func (c *Client) CreateShellWithOptions(ctx context.Context, options *ShellOptions) (*Shell, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := NewOpenShellRequestWithOptions(c.url, options, &c.Parameters)
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	shellID, err := ParseOpenShellResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	shell := c.NewShell(shellID)
+	if options != nil {
+		shell.outputStreams = options.outputStreams()
+	}
+	return shell, nil
+}
@@ -0,0 +1,50 @@
+package winrm
+
+import (
+	"context"
+	"time"
+
+	"github.com/masterzen/winrm/soap"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WinRMSuite) TestCreateShellWithOptions(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		c.Check(message.String(), Contains, "<rsp:WorkingDirectory>C:\\app</rsp:WorkingDirectory>")
+		c.Check(message.String(), Contains, `<rsp:Variable Name="APP_ENV">production</rsp:Variable>`)
+		c.Check(message.String(), Contains, "<rsp:IdleTimeOut>PT30M</rsp:IdleTimeOut>")
+		return createShellResponse, nil
+	}
+	client.http = &r
+
+	shell, err := client.CreateShellWithOptions(context.Background(), &ShellOptions{
+		WorkingDirectory: `C:\app`,
+		Environment:      map[string]string{"APP_ENV": "production"},
+		IdleTimeOut:      30 * time.Minute,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(shell.id, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+}
+
+func (s *WinRMSuite) TestCreateShellWithOptionsCanceled(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		c.Error("no request should be sent")
+		return "", nil
+	}
+	client.http = &r
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.CreateShellWithOptions(ctx, nil)
+	c.Assert(err, Equals, context.Canceled)
+}
@@ -0,0 +1,227 @@
+package winrm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Defaults of the ShellPool options
+const (
+	DefaultShellPoolSize             = 4
+	DefaultShellPoolIdleTTL          = 5 * time.Minute
+	DefaultShellPoolHealthCheckAfter = 30 * time.Second
+)
+
+// ErrShellPoolClosed is returned when acquiring a shell from a closed ShellPool
+var ErrShellPoolClosed = errors.New("shell pool is closed")
+
+// ShellPoolOptions configures a ShellPool, the zero value of each field selecting its default
+type ShellPoolOptions struct {
+	// MaxShells bounds the number of shells opened by the pool, DefaultShellPoolSize when zero.
+	// It must stay below the MaxShellsPerUser quota of the server.
+	MaxShells int
+	// IdleTTL is the time after which an unused shell is closed, DefaultShellPoolIdleTTL when zero
+	IdleTTL time.Duration
+	// HealthCheckAfter is the time after which an unused shell is checked to still exist on
+	// the server before being handed out again, DefaultShellPoolHealthCheckAfter when zero
+	HealthCheckAfter time.Duration
+	// Shell configures the shells created by the pool, see CreateShellWithOptions
+	Shell *ShellOptions
+}
+
+// ShellPool keeps a bounded number of shells open on the endpoint of a Client and hands
+// them out, saving the round trips of creating and deleting a shell for every command:
+//
+//	pool := client.NewShellPool(nil)
+//	defer pool.Close()
+//	client.UseShellPool(pool)
+//
+// The Run* methods of the client then reuse the shells of the pool.
+type ShellPool struct {
+	client  *Client
+	options ShellPoolOptions
+
+	slots chan struct{}
+	stop  chan struct{}
+
+	mutex  sync.Mutex
+	idle   []*pooledShell
+	closed bool
+}
+
+type pooledShell struct {
+	shell    *Shell
+	released time.Time
+}
+
+// NewShellPool returns a pool of the shells of this client configured by options, which may be
+// nil. The pool must be closed when no longer used.
+func (c *Client) NewShellPool(options *ShellPoolOptions) *ShellPool {
+	pool := &ShellPool{client: c, stop: make(chan struct{})}
+	if options != nil {
+		pool.options = *options
+	}
+	if pool.options.MaxShells <= 0 {
+		pool.options.MaxShells = DefaultShellPoolSize
+	}
+	if pool.options.IdleTTL <= 0 {
+		pool.options.IdleTTL = DefaultShellPoolIdleTTL
+	}
+	if pool.options.HealthCheckAfter <= 0 {
+		pool.options.HealthCheckAfter = DefaultShellPoolHealthCheckAfter
+	}
+	pool.slots = make(chan struct{}, pool.options.MaxShells)
+
+	go pool.reap()
+
+	return pool
+}
+
+// UseShellPool makes the Run* methods of the client run their commands in the shells of pool,
+// instead of creating a shell for each of them. It must be called before running commands.
+// A nil pool restores the default behavior.
+func (c *Client) UseShellPool(pool *ShellPool) {
+	c.shellPool = pool
+}
+
+// Acquire hands out a shell of the pool, opening a new one when none is available, and
+// waiting for a shell to be released when MaxShells are in use. The shell must be given back
+// with Release, or with Discard when it is no longer usable.
+func (p *ShellPool) Acquire(ctx context.Context) (*Shell, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for {
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			<-p.slots
+			return nil, ErrShellPoolClosed
+		}
+		var idle *pooledShell
+		if n := len(p.idle); n > 0 {
+			// hand out the most recently used shell, letting the others expire
+			idle, p.idle = p.idle[n-1], p.idle[:n-1]
+		}
+		p.mutex.Unlock()
+
+		if idle == nil {
+			break
+		}
+		if time.Since(idle.released) < p.options.HealthCheckAfter || p.healthy(ctx, idle.shell) {
+			return idle.shell, nil
+		}
+		if err := ctx.Err(); err != nil {
+			p.mutex.Lock()
+			p.idle = append(p.idle, idle)
+			p.mutex.Unlock()
+			<-p.slots
+			return nil, err
+		}
+		_ = idle.shell.CloseWithContext(ctx)
+	}
+
+	shell, err := p.client.CreateShellWithOptions(ctx, p.options.Shell)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return shell, nil
+}
+
+// healthy checks that the shell still exists on the server
+func (p *ShellPool) healthy(ctx context.Context, shell *Shell) bool {
+	_, err := p.client.Get(ctx, ResourceURICmd, map[string]string{"ShellId": shell.id}, nil)
+	return err == nil
+}
+
+// Release gives back a shell acquired from the pool, for it to be handed out again.
+// The commands executed in the shell must be done.
+func (p *ShellPool) Release(shell *Shell) {
+	p.mutex.Lock()
+	closed := p.closed
+	if !closed {
+		p.idle = append(p.idle, &pooledShell{shell: shell, released: time.Now()})
+	}
+	p.mutex.Unlock()
+
+	if closed {
+		_ = shell.Close()
+	}
+	<-p.slots
+}
+
+// Discard closes a shell acquired from the pool which is no longer usable, such as one
+// whose command failed, freeing its place in the pool
+func (p *ShellPool) Discard(shell *Shell) {
+	_ = shell.Close()
+	<-p.slots
+}
+
+// reap closes the shells unused for longer than IdleTTL until the pool is closed
+func (p *ShellPool) reap() {
+	interval := p.options.IdleTTL / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case now := <-ticker.C:
+			for _, shell := range p.expired(now) {
+				_ = shell.Close()
+			}
+		}
+	}
+}
+
+// expired removes from the pool the shells unused for longer than IdleTTL at now
+func (p *ShellPool) expired(now time.Time) []*Shell {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var expired []*Shell
+	idle := p.idle[:0]
+	for _, pooled := range p.idle {
+		if now.Sub(pooled.released) >= p.options.IdleTTL {
+			expired = append(expired, pooled.shell)
+			continue
+		}
+		idle = append(idle, pooled)
+	}
+	p.idle = idle
+	return expired
+}
+
+// Close closes the unused shells of the pool, the ones in use being closed when released.
+// It is safe to call Close several times.
+func (p *ShellPool) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mutex.Unlock()
+
+	close(p.stop)
+
+	var err error
+	for _, pooled := range idle {
+		if closeErr := pooled.shell.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
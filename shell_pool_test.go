@@ -0,0 +1,204 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+const shellGoneResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xml:lang="en-US"><s:Header><a:Action>http://schemas.dmtf.org/wbem/wsman/1/wsman/fault</a:Action></s:Header><s:Body><s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>w:InvalidSelectors</s:Value></s:Subcode></s:Code><s:Reason><s:Text xml:lang="en-US">The WS-Management service cannot process the request because the request contained invalid selectors for the resource. </s:Text></s:Reason></s:Fault></s:Body></s:Envelope>`
+
+// shellPoolFakeServer counts the shells created and deleted, each shell having its own id
+type shellPoolFakeServer struct {
+	mutex   sync.Mutex
+	created int
+	deleted []string
+	checked int
+	gone    map[string]bool
+}
+
+func (f *shellPoolFakeServer) counts() (int, int, int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.created, len(f.deleted), f.checked
+}
+
+func runShellPoolFakeServer(c *C) (*Client, *shellPoolFakeServer, func()) {
+	fake := &shellPoolFakeServer{gone: make(map[string]bool)}
+	empty := "<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body/></s:Envelope>"
+
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		body := string(b)
+		shellID, _ := ParseOpenShellResponse(body)
+
+		fake.mutex.Lock()
+		defer fake.mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/soap+xml")
+		switch {
+		case strings.Contains(body, "transfer/Create<"):
+			fake.created++
+			id := fmt.Sprintf("00000000-0000-4000-8000-%012d", fake.created)
+			fmt.Fprintln(w, strings.ReplaceAll(createShellResponse, "67A74734-DD32-4F10-89DE-49A060483810", id))
+		case strings.Contains(body, "transfer/Get<"):
+			fake.checked++
+			if fake.gone[shellID] {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintln(w, shellGoneResponse)
+				return
+			}
+			fmt.Fprintln(w, empty)
+		case strings.Contains(body, "transfer/Delete<"):
+			fake.deleted = append(fake.deleted, shellID)
+			fmt.Fprintln(w, empty)
+		case strings.Contains(body, "shell/Command<"):
+			fmt.Fprintln(w, executeCommandResponse)
+		case strings.Contains(body, "shell/Receive<"):
+			fmt.Fprintln(w, doneCommandExitCode0Response)
+		default:
+			fmt.Fprintln(w, empty)
+		}
+	}))
+	c.Assert(err, IsNil)
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	return client, fake, ts.Close
+}
+
+func (s *WinRMSuite) TestShellPoolReusesShells(c *C) {
+	client, fake, stop := runShellPoolFakeServer(c)
+	defer stop()
+
+	pool := client.NewShellPool(&ShellPoolOptions{MaxShells: 2})
+	client.UseShellPool(pool)
+
+	for i := 0; i < 5; i++ {
+		_, _, exitCode, err := client.RunCmdWithContext(context.Background(), "hostname")
+		c.Assert(err, IsNil)
+		c.Assert(exitCode, Equals, 0)
+	}
+
+	created, deleted, _ := fake.counts()
+	c.Check(created, Equals, 1)
+	c.Check(deleted, Equals, 0)
+
+	c.Assert(pool.Close(), IsNil)
+	created, deleted, _ = fake.counts()
+	c.Check(created, Equals, 1)
+	c.Check(deleted, Equals, 1)
+
+	c.Assert(pool.Close(), IsNil)
+}
+
+func (s *WinRMSuite) TestShellPoolBounded(c *C) {
+	client, fake, stop := runShellPoolFakeServer(c)
+	defer stop()
+
+	pool := client.NewShellPool(&ShellPoolOptions{MaxShells: 2})
+	defer pool.Close()
+
+	first, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	second, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(first.id, Not(Equals), second.id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pool.Acquire(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	pool.Release(second)
+	third, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(third.id, Equals, second.id)
+
+	pool.Discard(first)
+	fourth, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(fourth.id, Not(Equals), first.id)
+
+	created, deleted, _ := fake.counts()
+	c.Check(created, Equals, 3)
+	c.Check(deleted, Equals, 1)
+}
+
+func (s *WinRMSuite) TestShellPoolHealthCheck(c *C) {
+	client, fake, stop := runShellPoolFakeServer(c)
+	defer stop()
+
+	pool := client.NewShellPool(&ShellPoolOptions{HealthCheckAfter: time.Nanosecond})
+	defer pool.Close()
+
+	shell, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	pool.Release(shell)
+
+	again, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(again.id, Equals, shell.id)
+	pool.Release(again)
+
+	fake.mutex.Lock()
+	fake.gone[shell.id] = true
+	fake.mutex.Unlock()
+
+	replaced, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	c.Check(replaced.id, Not(Equals), shell.id)
+
+	created, deleted, checked := fake.counts()
+	c.Check(created, Equals, 2)
+	c.Check(deleted, Equals, 1)
+	c.Check(checked, Equals, 2)
+}
+
+func (s *WinRMSuite) TestShellPoolIdleTTL(c *C) {
+	client, _, stop := runShellPoolFakeServer(c)
+	defer stop()
+
+	pool := client.NewShellPool(&ShellPoolOptions{IdleTTL: time.Minute})
+	defer pool.Close()
+
+	first, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	second, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+	pool.Release(first)
+	pool.Release(second)
+	pool.idle[0].released = time.Now().Add(-2 * time.Minute)
+
+	expired := pool.expired(time.Now())
+	c.Assert(expired, HasLen, 1)
+	c.Check(expired[0].id, Equals, first.id)
+	c.Assert(pool.idle, HasLen, 1)
+	c.Check(pool.idle[0].shell.id, Equals, second.id)
+}
+
+func (s *WinRMSuite) TestShellPoolClosed(c *C) {
+	client, fake, stop := runShellPoolFakeServer(c)
+	defer stop()
+
+	pool := client.NewShellPool(nil)
+	shell, err := pool.Acquire(context.Background())
+	c.Assert(err, IsNil)
+
+	c.Assert(pool.Close(), IsNil)
+	_, err = pool.Acquire(context.Background())
+	c.Assert(err, Equals, ErrShellPoolClosed)
+
+	pool.Release(shell)
+	_, deleted, _ := fake.counts()
+	c.Check(deleted, Equals, 1)
+}
@@ -1,6 +1,14 @@
 package winrm
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/masterzen/winrm/soap"
 	. "gopkg.in/check.v1"
 )
@@ -42,3 +50,114 @@ func (s *WinRMSuite) TestShellCloseResponse(c *C) {
 
 	shell.Close()
 }
+
+func (s *WinRMSuite) TestShellCloseWithContextHangingServer(c *C) {
+	stop := make(chan struct{})
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stop:
+		}
+	}))
+	c.Assert(err, IsNil)
+	defer ts.Close()
+	defer close(stop)
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	shell := client.NewShell("67A74734-DD32-4F10-89DE-49A060483810")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = shell.CloseWithContext(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < 5*time.Second, Equals, true)
+}
+
+func (s *WinRMSuite) TestShellDisconnectAndReconnect(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	var actions []string
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		c.Assert(body, Contains, "67A74734-DD32-4F10-89DE-49A060483810")
+		switch {
+		case strings.Contains(body, "shell/Disconnect<"):
+			c.Assert(body, Contains, "<rsp:IdleTimeOut>PT1H</rsp:IdleTimeOut>")
+			actions = append(actions, "Disconnect")
+		case strings.Contains(body, "shell/Reconnect<"):
+			actions = append(actions, "Reconnect")
+		}
+		return "", nil
+	}
+	client.http = &r
+
+	shell := client.NewShell("67A74734-DD32-4F10-89DE-49A060483810")
+	c.Assert(shell.Disconnect(context.Background(), time.Hour), IsNil)
+	c.Assert(shell.Reconnect(context.Background()), IsNil)
+	c.Check(actions, DeepEquals, []string{"Disconnect", "Reconnect"})
+}
+
+func (s *WinRMSuite) TestConnectShellResumesCommand(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	withSequence := func(response string, sequenceID int) string {
+		return strings.Replace(response, "<rsp:ReceiveResponse>", fmt.Sprintf("<rsp:ReceiveResponse SequenceID=\"%d\">", sequenceID), 1)
+	}
+	var sequenceIDs []string
+	r := Requester{}
+	r.http = func(client *Client, message *soap.SoapMessage) (string, error) {
+		body := message.String()
+		switch {
+		case strings.Contains(body, "shell/Connect<"):
+			c.Assert(body, Contains, "67A74734-DD32-4F10-89DE-49A060483810")
+			return "", nil
+		case strings.Contains(body, "shell/Receive<"):
+			c.Assert(body, Contains, "CommandId=\"1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4\"")
+			sequenceID := regexp.MustCompile(`SequenceId="(\d+)"`).FindStringSubmatch(body)
+			c.Assert(sequenceID, HasLen, 2)
+			sequenceIDs = append(sequenceIDs, sequenceID[1])
+			if len(sequenceIDs) == 1 {
+				return withSequence(singleOutputResponse, 3), nil
+			}
+			return withSequence(doneCommandResponse, 4), nil
+		}
+		c.Fatalf("unexpected request %s", body)
+		return "", nil
+	}
+	client.http = &r
+
+	shell, err := client.ConnectShell(context.Background(), "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Assert(err, IsNil)
+	c.Check(shell.ID(), Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+
+	command := shell.ResumeCommand(context.Background(), "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4", 3)
+	stdout, err := io.ReadAll(command.Stdout)
+	c.Assert(err, IsNil)
+	command.Wait()
+
+	c.Check(string(stdout), Equals, "That's all folks!!!")
+	c.Check(command.ExitCode(), Equals, 123)
+	c.Check(command.ID(), Equals, "1A6DEE6B-EC68-4DD6-87E9-030C0048ECC4")
+	c.Check(command.SequenceID(), Equals, uint64(5))
+	c.Check(sequenceIDs, DeepEquals, []string{"3", "4"})
+}
+
+func (s *WinRMSuite) TestConnectShellCanceled(c *C) {
+	endpoint := NewEndpoint("localhost", 5985, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.ConnectShell(ctx, "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Assert(err, Equals, context.Canceled)
+}
@@ -0,0 +1,166 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/masterzen/winrm/clixml"
+)
+
+// ResourceURIShell is the resource URI enumerating the shells of every resource URI,
+// such as ResourceURICmd and ResourceURIPowerShell
+const ResourceURIShell = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell"
+
+// States of a shell reported by ListShells
+const (
+	ShellStateConnected    = "Connected"
+	ShellStateDisconnected = "Disconnected"
+)
+
+// ShellInfo describes a shell open on the server, see ListShells
+type ShellInfo struct {
+	ID          string
+	Name        string
+	ResourceURI string
+	// Owner is the user who opened the shell, e.g. DOMAIN\user
+	Owner    string
+	ClientIP string
+	// ProcessID is the id of the process hosting the shell on the server
+	ProcessID int
+	// State is ShellStateConnected or ShellStateDisconnected
+	State       string
+	IdleTimeOut time.Duration
+	// RunTime is the time since the shell was opened
+	RunTime time.Duration
+	// IdleTime is the time since the shell was last used
+	IdleTime time.Duration
+	// Commands is the number of commands running in the shell, which the server reports
+	// as the child processes of the shell
+	Commands int
+	// Instance holds all the properties of the shell
+	Instance *Instance
+}
+
+// ListShells returns the shells open on the server by the user of this client, or by
+// every user when it is an administrator
+func (c *Client) ListShells(ctx context.Context) ([]*ShellInfo, error) {
+	items, err := c.EnumerateAll(ctx, ResourceURIShell, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	shells := make([]*ShellInfo, 0, len(items))
+	for _, item := range items {
+		shell, err := newShellInfo(item)
+		if err != nil {
+			return nil, err
+		}
+		shells = append(shells, shell)
+	}
+	return shells, nil
+}
+
+func newShellInfo(item *Instance) (*ShellInfo, error) {
+	shell := &ShellInfo{
+		ID:          item.Get("ShellId"),
+		Name:        item.Get("Name"),
+		ResourceURI: item.Get("ResourceUri"),
+		Owner:       item.Get("Owner"),
+		ClientIP:    item.Get("ClientIP"),
+		State:       item.Get("State"),
+		Instance:    item,
+	}
+
+	var err error
+	if shell.ProcessID, err = atoi(item.Get("ProcessId")); err != nil {
+		return nil, fmt.Errorf("parsing ProcessId of shell %s: %w", shell.ID, err)
+	}
+	if shell.Commands, err = atoi(item.Get("ChildProcesses")); err != nil {
+		return nil, fmt.Errorf("parsing ChildProcesses of shell %s: %w", shell.ID, err)
+	}
+	if shell.IdleTimeOut, err = parseDuration(item.Get("IdleTimeOut")); err != nil {
+		return nil, fmt.Errorf("parsing IdleTimeOut of shell %s: %w", shell.ID, err)
+	}
+	if shell.RunTime, err = parseDuration(item.Get("ShellRunTime")); err != nil {
+		return nil, fmt.Errorf("parsing ShellRunTime of shell %s: %w", shell.ID, err)
+	}
+	if shell.IdleTime, err = parseDuration(item.Get("ShellInactivity")); err != nil {
+		return nil, fmt.Errorf("parsing ShellInactivity of shell %s: %w", shell.ID, err)
+	}
+	return shell, nil
+}
+
+// DeleteShell deletes the shell id of the cmd resource URI, such as a shell left open by a
+// process which died, terminating its commands
+func (c *Client) DeleteShell(ctx context.Context, id string) error {
+	return c.Delete(ctx, ResourceURICmd, map[string]string{"ShellId": id}, nil)
+}
+
+// ReapShells deletes the shells opened by the user of this client more than olderThan ago,
+// whatever their resource URI and state, returning the ids of the deleted shells.
+// The shells in use by this client, such as the ones of its ShellPool, must be younger.
+func (c *Client) ReapShells(ctx context.Context, olderThan time.Duration) ([]string, error) {
+	shells, err := c.ListShells(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for _, shell := range shells {
+		if shell.RunTime < olderThan || !sameUser(shell.Owner, c.username) {
+			continue
+		}
+		resourceURI := shell.ResourceURI
+		if resourceURI == "" {
+			resourceURI = ResourceURICmd
+		}
+		if err := c.Delete(ctx, resourceURI, map[string]string{"ShellId": shell.ID}, nil); err != nil {
+			return deleted, fmt.Errorf("deleting shell %s: %w", shell.ID, err)
+		}
+		deleted = append(deleted, shell.ID)
+	}
+	return deleted, nil
+}
+
+// sameUser compares the owner of a shell with the name of a user, the domain being
+// ignored when either of them doesn't have one
+func sameUser(owner, username string) bool {
+	if strings.EqualFold(owner, username) {
+		return true
+	}
+	ownerDomain, ownerName := splitUser(owner)
+	userDomain, userName := splitUser(username)
+	if ownerDomain != "" && userDomain != "" && !strings.EqualFold(ownerDomain, userDomain) {
+		return false
+	}
+	return strings.EqualFold(ownerName, userName)
+}
+
+// splitUser splits DOMAIN\user and user@domain names into their domain and user parts
+func splitUser(name string) (string, string) {
+	if i := strings.Index(name, `\`); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		return name[i+1:], name[:i]
+	}
+	return "", name
+}
+
+func atoi(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
+
+// parseDuration parses the xs:duration s, such as P0DT1H30M5.5S, which is 0 when empty
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return clixml.ParseDuration(s)
+}
@@ -0,0 +1,115 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+const enumerateShellsResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:n="http://schemas.xmlsoap.org/ws/2004/09/enumeration" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xml:lang="en-US"><s:Header><a:Action>http://schemas.xmlsoap.org/ws/2004/09/enumeration/EnumerateResponse</a:Action><a:MessageID>uuid:9A1C3E5F-7B2D-4F6A-8C0E-1D3F5A7B9C2E</a:MessageID><a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To><a:RelatesTo>uuid:6E8A0C2D-4F1B-4D3A-9E5C-7B9D1F3A5C8E</a:RelatesTo></s:Header><s:Body><n:EnumerateResponse><n:EnumerationContext></n:EnumerationContext><w:Items>` +
+	`<rsp:Shell xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><rsp:ShellId>67A74734-DD32-4F10-89DE-49A060483810</rsp:ShellId><rsp:ResourceUri>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd</rsp:ResourceUri><rsp:Owner>WIN-SERVER\Administrator</rsp:Owner><rsp:ClientIP>10.0.0.12</rsp:ClientIP><rsp:ProcessId>4312</rsp:ProcessId><rsp:IdleTimeOut>PT7200.000S</rsp:IdleTimeOut><rsp:InputStreams>stdin</rsp:InputStreams><rsp:OutputStreams>stdout stderr</rsp:OutputStreams><rsp:MaxIdleTimeOut>PT2147483.647S</rsp:MaxIdleTimeOut><rsp:Locale>en-US</rsp:Locale><rsp:DataLocale>en-US</rsp:DataLocale><rsp:CompressionMode>NoCompression</rsp:CompressionMode><rsp:ProfileLoaded>Yes</rsp:ProfileLoaded><rsp:Encoding>UTF8</rsp:Encoding><rsp:BufferMode>Block</rsp:BufferMode><rsp:State>Disconnected</rsp:State><rsp:ShellRunTime>P1DT2H3M4S</rsp:ShellRunTime><rsp:ShellInactivity>P0DT1H0M0.5S</rsp:ShellInactivity><rsp:MemoryUsed>18MB</rsp:MemoryUsed><rsp:ChildProcesses>2</rsp:ChildProcesses></rsp:Shell>` +
+	`<rsp:Shell xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><rsp:ShellId>0B0E5C2A-6F3D-4E1B-9A7C-2D4F6B8E0A1C</rsp:ShellId><rsp:Name>Runspace1</rsp:Name><rsp:ResourceUri>http://schemas.microsoft.com/powershell/Microsoft.PowerShell</rsp:ResourceUri><rsp:Owner>administrator</rsp:Owner><rsp:ClientIP>10.0.0.12</rsp:ClientIP><rsp:ProcessId>5128</rsp:ProcessId><rsp:IdleTimeOut>PT7200.000S</rsp:IdleTimeOut><rsp:State>Connected</rsp:State><rsp:ShellRunTime>P0DT5H0M0S</rsp:ShellRunTime><rsp:ShellInactivity>P0DT0H0M1S</rsp:ShellInactivity><rsp:ChildProcesses>0</rsp:ChildProcesses></rsp:Shell>` +
+	`<rsp:Shell xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><rsp:ShellId>3C5E7A9B-1D2F-4B6A-8E0C-4F6A8C0E2B3D</rsp:ShellId><rsp:ResourceUri>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd</rsp:ResourceUri><rsp:Owner>WIN-SERVER\Deployer</rsp:Owner><rsp:ClientIP>10.0.0.40</rsp:ClientIP><rsp:ProcessId>2044</rsp:ProcessId><rsp:IdleTimeOut>PT7200.000S</rsp:IdleTimeOut><rsp:State>Disconnected</rsp:State><rsp:ShellRunTime>P3DT0H0M0S</rsp:ShellRunTime><rsp:ShellInactivity>P3DT0H0M0S</rsp:ShellInactivity><rsp:ChildProcesses>1</rsp:ChildProcesses></rsp:Shell>` +
+	`<rsp:Shell xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell"><rsp:ShellId>5D7F9B1C-3E4A-4C8B-9F1D-6A8C0E2F4B5D</rsp:ShellId><rsp:ResourceUri>http://schemas.microsoft.com/wbem/wsman/1/windows/shell/cmd</rsp:ResourceUri><rsp:Owner>WIN-SERVER\Administrator</rsp:Owner><rsp:ClientIP>10.0.0.12</rsp:ClientIP><rsp:ProcessId>6220</rsp:ProcessId><rsp:IdleTimeOut>PT7200.000S</rsp:IdleTimeOut><rsp:State>Connected</rsp:State><rsp:ShellRunTime>P0DT0H0M30S</rsp:ShellRunTime><rsp:ShellInactivity>P0DT0H0M10S</rsp:ShellInactivity><rsp:ChildProcesses>1</rsp:ChildProcesses></rsp:Shell>` +
+	`</w:Items><w:EndOfSequence/></n:EnumerateResponse></s:Body></s:Envelope>`
+
+// runShellsFakeServer answers the enumeration of the shells and records the deleted ones
+func runShellsFakeServer(c *C) (*Client, func() []string, func()) {
+	var mutex sync.Mutex
+	var deleted []string
+
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		body := string(b)
+
+		w.Header().Set("Content-Type", "application/soap+xml")
+		switch {
+		case strings.Contains(body, "enumeration/Enumerate<"):
+			c.Assert(body, Contains, "<w:ResourceURI mustUnderstand=\"true\">http://schemas.microsoft.com/wbem/wsman/1/windows/shell</w:ResourceURI>")
+			fmt.Fprintln(w, enumerateShellsResponse)
+		case strings.Contains(body, "transfer/Delete<"):
+			shellID, _ := ParseOpenShellResponse(body)
+			resourceURI := body[strings.Index(body, "ResourceURI"):]
+			resourceURI = resourceURI[strings.Index(resourceURI, ">")+1 : strings.Index(resourceURI, "<")]
+			mutex.Lock()
+			deleted = append(deleted, shellID+" "+resourceURI)
+			mutex.Unlock()
+			fmt.Fprintln(w, "<s:Envelope xmlns:s=\"http://www.w3.org/2003/05/soap-envelope\"><s:Body/></s:Envelope>")
+		default:
+			c.Errorf("unexpected request %s", body)
+		}
+	}))
+	c.Assert(err, IsNil)
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	return client, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return deleted
+	}, ts.Close
+}
+
+func (s *WinRMSuite) TestListShells(c *C) {
+	client, _, stop := runShellsFakeServer(c)
+	defer stop()
+
+	shells, err := client.ListShells(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(shells, HasLen, 4)
+
+	shell := shells[0]
+	c.Check(shell.ID, Equals, "67A74734-DD32-4F10-89DE-49A060483810")
+	c.Check(shell.ResourceURI, Equals, ResourceURICmd)
+	c.Check(shell.Owner, Equals, `WIN-SERVER\Administrator`)
+	c.Check(shell.ClientIP, Equals, "10.0.0.12")
+	c.Check(shell.ProcessID, Equals, 4312)
+	c.Check(shell.State, Equals, ShellStateDisconnected)
+	c.Check(shell.IdleTimeOut, Equals, 2*time.Hour)
+	c.Check(shell.RunTime, Equals, 26*time.Hour+3*time.Minute+4*time.Second)
+	c.Check(shell.IdleTime, Equals, time.Hour+500*time.Millisecond)
+	c.Check(shell.Commands, Equals, 2)
+	c.Check(shell.Instance.Get("MemoryUsed"), Equals, "18MB")
+
+	c.Check(shells[1].Name, Equals, "Runspace1")
+	c.Check(shells[1].State, Equals, ShellStateConnected)
+}
+
+func (s *WinRMSuite) TestDeleteShell(c *C) {
+	client, deleted, stop := runShellsFakeServer(c)
+	defer stop()
+
+	c.Assert(client.DeleteShell(context.Background(), "67A74734-DD32-4F10-89DE-49A060483810"), IsNil)
+	c.Assert(deleted(), DeepEquals, []string{"67A74734-DD32-4F10-89DE-49A060483810 " + ResourceURICmd})
+}
+
+func (s *WinRMSuite) TestReapShells(c *C) {
+	client, deleted, stop := runShellsFakeServer(c)
+	defer stop()
+
+	reaped, err := client.ReapShells(context.Background(), time.Hour)
+	c.Assert(err, IsNil)
+	// the shell of another user and the one younger than an hour are kept
+	c.Assert(reaped, DeepEquals, []string{"67A74734-DD32-4F10-89DE-49A060483810", "0B0E5C2A-6F3D-4E1B-9A7C-2D4F6B8E0A1C"})
+	c.Assert(deleted(), DeepEquals, []string{
+		"67A74734-DD32-4F10-89DE-49A060483810 " + ResourceURICmd,
+		"0B0E5C2A-6F3D-4E1B-9A7C-2D4F6B8E0A1C " + ResourceURIPowerShell,
+	})
+}
+
+func (s *WinRMSuite) TestSameUser(c *C) {
+	c.Check(sameUser(`WIN-SERVER\Administrator`, "administrator"), Equals, true)
+	c.Check(sameUser(`WIN-SERVER\Administrator`, `win-server\administrator`), Equals, true)
+	c.Check(sameUser(`CORP\deployer`, "deployer@corp"), Equals, true)
+	c.Check(sameUser(`CORP\deployer`, `OTHER\deployer`), Equals, false)
+	c.Check(sameUser(`CORP\deployer`, "Administrator"), Equals, false)
+}
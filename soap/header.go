@@ -25,6 +25,7 @@ type SoapHeader struct {
 	action          string
 	shellID         string
 	resourceURI     string
+	selectors       []HeaderOption
 	options         []HeaderOption
 	message         *SoapMessage
 }
@@ -39,6 +40,7 @@ type HeaderBuilder interface {
 	Action(string) *SoapHeader
 	ShellId(string) *SoapHeader
 	resourceURI(string) *SoapHeader
+	AddSelector(string, string) *SoapHeader
 	AddOption(*HeaderOption) *SoapHeader
 	Options([]HeaderOption) *SoapHeader
 	Build(*SoapMessage) *SoapMessage
@@ -91,6 +93,12 @@ func (sh *SoapHeader) ResourceURI(resourceURI string) *SoapHeader {
 	return sh
 }
 
+// AddSelector adds a selector identifying the resource instance, next to the ShellId one if any
+func (sh *SoapHeader) AddSelector(name string, value string) *SoapHeader {
+	sh.selectors = append(sh.selectors, HeaderOption{key: name, value: value})
+	return sh
+}
+
 func (sh *SoapHeader) AddOption(option *HeaderOption) *SoapHeader {
 	sh.options = append(sh.options, *option)
 	return sh
@@ -142,11 +150,18 @@ func (sh *SoapHeader) Build() *SoapMessage {
 		action.SetContent(sh.action)
 	}
 
-	if sh.shellID != "" {
+	if sh.shellID != "" || len(sh.selectors) > 0 {
 		selectorSet := sh.createElement(header, "SelectorSet", DOM_NS_WSMAN_DMTF)
-		selector := sh.createElement(selectorSet, "Selector", DOM_NS_WSMAN_DMTF)
-		selector.SetAttr("Name", "ShellId")
-		selector.SetContent(sh.shellID)
+		if sh.shellID != "" {
+			selector := sh.createElement(selectorSet, "Selector", DOM_NS_WSMAN_DMTF)
+			selector.SetAttr("Name", "ShellId")
+			selector.SetContent(sh.shellID)
+		}
+		for _, option := range sh.selectors {
+			selector := sh.createElement(selectorSet, "Selector", DOM_NS_WSMAN_DMTF)
+			selector.SetAttr("Name", EscapeXML(option.key))
+			selector.SetContent(EscapeXML(option.value))
+		}
 	}
 
 	if sh.resourceURI != "" {
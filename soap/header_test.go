@@ -149,3 +149,24 @@ func (s *MySuite) TestOptionsHeaderBuild(c *C) {
 
 	c.Check(msg.String(), Equals, expected)
 }
+
+func (s *MySuite) TestSelectorsHeaderBuild(c *C) {
+	h := initDocument()
+	msg := h.Action("http://schemas.xmlsoap.org/ws/2004/09/transfer/Get").ResourceURI("http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service").
+		AddSelector("Name", "WinRM").AddSelector("Path", `C:\a&b`).Build()
+
+	expected := `<?xml version="1.0" encoding="utf-8" ?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd" xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/wsman.xsd">
+  <env:Header>
+    <a:Action mustUnderstand="true">http://schemas.xmlsoap.org/ws/2004/09/transfer/Get</a:Action>
+    <w:SelectorSet>
+      <w:Selector Name="Name">WinRM</w:Selector>
+      <w:Selector Name="Path">C:\a&amp;b</w:Selector>
+    </w:SelectorSet>
+    <w:ResourceURI mustUnderstand="true">http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service</w:ResourceURI>
+  </env:Header>
+</env:Envelope>
+`
+
+	c.Check(msg.String(), Equals, expected)
+}
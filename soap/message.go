@@ -1,6 +1,9 @@
 package soap
 
 import (
+	"bytes"
+	"encoding/xml"
+
 	"github.com/masterzen/simplexml/dom"
 )
 
@@ -71,3 +74,11 @@ func (message *SoapMessage) Header() *SoapHeader {
 	}
 	return message.header
 }
+
+// EscapeXML escapes s for use as element content or attribute value, which the DOM doesn't
+// do by itself
+func EscapeXML(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
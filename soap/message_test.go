@@ -53,3 +53,8 @@ func (s *MySuite) TestNewMessage(c *C) {
 
 	c.Check(message.String(), Equals, expected)
 }
+
+func (s *MySuite) TestEscapeXML(c *C) {
+	c.Check(EscapeXML(`a<b & "c"`), Equals, "a&lt;b &amp; &#34;c&#34;")
+	c.Check(EscapeXML("plain"), Equals, "plain")
+}
@@ -0,0 +1,101 @@
+package winrm
+
+import (
+	"context"
+	"strings"
+
+	"github.com/masterzen/winrm/soap"
+)
+
+// EndpointReference identifies a resource instance, as returned when it is created
+type EndpointReference struct {
+	Address     string
+	ResourceURI string
+	Selectors   map[string]string
+}
+
+// Get retrieves the instance of resourceURI identified by selectors, such as the WinRM
+// configuration with http://schemas.microsoft.com/wbem/wsman/1/config and no selectors, or a
+// WMI object with http://schemas.microsoft.com/wbem/wsman/1/wmi/root/cimv2/Win32_Service and
+// the selector Name set to the name of the service. options are sent in the OptionSet header.
+func (c *Client) Get(ctx context.Context, resourceURI string, selectors, options map[string]string) (*Instance, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := NewGetRequest(c.url, resourceURI, selectors, options, &c.Parameters)
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTransferResponse(response)
+}
+
+// Put updates the instance of resourceURI identified by selectors with body, the XML of
+// the properties to change wrapped in the instance element, see InstanceXML.
+// It returns the instance as updated by the server, if it sent it back.
+func (c *Client) Put(ctx context.Context, resourceURI string, selectors, options map[string]string, body string) (*Instance, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := NewPutRequest(c.url, resourceURI, selectors, options, body, &c.Parameters)
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTransferResponse(response)
+}
+
+// Create creates a new instance of resourceURI from body, the XML of its representation,
+// such as a listener with http://schemas.microsoft.com/wbem/wsman/1/config/listener.
+// It returns the reference to the created instance.
+func (c *Client) Create(ctx context.Context, resourceURI string, options map[string]string, body string) (*EndpointReference, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	request := NewCreateRequest(c.url, resourceURI, options, body, &c.Parameters)
+	defer request.Free()
+
+	response, err := c.sendRequestWithContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseResourceCreatedResponse(response)
+}
+
+// Delete deletes the instance of resourceURI identified by selectors
+func (c *Client) Delete(ctx context.Context, resourceURI string, selectors, options map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	request := NewDeleteRequest(c.url, resourceURI, selectors, options, &c.Parameters)
+	defer request.Free()
+
+	_, err := c.sendRequestWithContext(ctx, request)
+	return err
+}
+
+// InstanceXML returns the XML of an instance named name in the namespace namespace with
+// the given properties, in the order of their names, for use as the body of Put or Create:
+//
+//	body := InstanceXML("Service", "http://schemas.microsoft.com/wbem/wsman/1/config/service",
+//		map[string]string{"MaxConcurrentOperationsPerUser": "1500"})
+func InstanceXML(name, namespace string, properties map[string]string) string {
+	var buf strings.Builder
+	buf.WriteString("<p:" + name + ` xmlns:p="` + soap.EscapeXML(namespace) + `">`)
+	for _, property := range sortedKeys(properties) {
+		buf.WriteString("<p:" + property + ">" + soap.EscapeXML(properties[property]) + "</p:" + property + ">")
+	}
+	buf.WriteString("</p:" + name + ">")
+	return buf.String()
+}
@@ -0,0 +1,184 @@
+package winrm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+var getConfigResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:x="http://schemas.xmlsoap.org/ws/2004/09/transfer" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">
+	<s:Header>
+		<a:Action>http://schemas.xmlsoap.org/ws/2004/09/transfer/GetResponse</a:Action>
+		<a:MessageID>uuid:1A2B3C4D-0000-4000-8000-000000000001</a:MessageID>
+		<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>
+		<a:RelatesTo>uuid:AAAAAAAA-0000-4000-8000-000000000001</a:RelatesTo>
+	</s:Header>
+	<s:Body>
+		<cfg:Config xmlns:cfg="http://schemas.microsoft.com/wbem/wsman/1/config">
+			<cfg:MaxEnvelopeSizekb>500</cfg:MaxEnvelopeSizekb>
+			<cfg:MaxTimeoutms>60000</cfg:MaxTimeoutms>
+			<cfg:MaxBatchItems>32000</cfg:MaxBatchItems>
+			<cfg:Service>
+				<cfg:AllowUnencrypted>false</cfg:AllowUnencrypted>
+			</cfg:Service>
+		</cfg:Config>
+	</s:Body>
+</s:Envelope>`
+
+var putServiceResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:x="http://schemas.xmlsoap.org/ws/2004/09/transfer">
+	<s:Header>
+		<a:Action>http://schemas.xmlsoap.org/ws/2004/09/transfer/PutResponse</a:Action>
+		<a:MessageID>uuid:1A2B3C4D-0000-4000-8000-000000000002</a:MessageID>
+		<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>
+		<a:RelatesTo>uuid:AAAAAAAA-0000-4000-8000-000000000002</a:RelatesTo>
+	</s:Header>
+	<s:Body>
+		<cfg:Service xmlns:cfg="http://schemas.microsoft.com/wbem/wsman/1/config/service">
+			<cfg:MaxConcurrentOperationsPerUser>1500</cfg:MaxConcurrentOperationsPerUser>
+			<cfg:AllowUnencrypted>false</cfg:AllowUnencrypted>
+		</cfg:Service>
+	</s:Body>
+</s:Envelope>`
+
+var createListenerResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:x="http://schemas.xmlsoap.org/ws/2004/09/transfer" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">
+	<s:Header>
+		<a:Action>http://schemas.xmlsoap.org/ws/2004/09/transfer/CreateResponse</a:Action>
+		<a:MessageID>uuid:1A2B3C4D-0000-4000-8000-000000000003</a:MessageID>
+		<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>
+		<a:RelatesTo>uuid:AAAAAAAA-0000-4000-8000-000000000003</a:RelatesTo>
+	</s:Header>
+	<s:Body>
+		<x:ResourceCreated>
+			<a:Address>http://localhost:5985/wsman</a:Address>
+			<a:ReferenceParameters>
+				<w:ResourceURI>http://schemas.microsoft.com/wbem/wsman/1/config/listener</w:ResourceURI>
+				<w:SelectorSet>
+					<w:Selector Name="Address">*</w:Selector>
+					<w:Selector Name="Transport">HTTPS</w:Selector>
+				</w:SelectorSet>
+			</a:ReferenceParameters>
+		</x:ResourceCreated>
+	</s:Body>
+</s:Envelope>`
+
+var deleteResponse = `<s:Envelope xml:lang="en-US" xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing">
+	<s:Header>
+		<a:Action>http://schemas.xmlsoap.org/ws/2004/09/transfer/DeleteResponse</a:Action>
+		<a:MessageID>uuid:1A2B3C4D-0000-4000-8000-000000000004</a:MessageID>
+		<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>
+		<a:RelatesTo>uuid:AAAAAAAA-0000-4000-8000-000000000004</a:RelatesTo>
+	</s:Header>
+	<s:Body></s:Body>
+</s:Envelope>`
+
+func runTransferFakeServer(c *C, responses map[string]string) (*Client, func() []string, func()) {
+	var mutex sync.Mutex
+	var requests []string
+
+	ts, host, port, err := StartTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		body := string(b)
+
+		w.Header().Set("Content-Type", "application/soap+xml")
+		for _, action := range []string{"Get", "Put", "Create", "Delete"} {
+			if !strings.Contains(body, "http://schemas.xmlsoap.org/ws/2004/09/transfer/"+action+"<") {
+				continue
+			}
+			mutex.Lock()
+			requests = append(requests, body)
+			mutex.Unlock()
+
+			response, ok := responses[action]
+			if !ok {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			fmt.Fprintln(w, response)
+			return
+		}
+		c.Errorf("unexpected request %s", body)
+	}))
+	c.Assert(err, IsNil)
+
+	endpoint := NewEndpoint(host, port, false, false, nil, nil, nil, 0)
+	client, err := NewClient(endpoint, "Administrator", "v3r1S3cre7")
+	c.Assert(err, IsNil)
+
+	return client, func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return requests
+	}, ts.Close
+}
+
+func (s *WinRMSuite) TestGet(c *C) {
+	client, requests, stop := runTransferFakeServer(c, map[string]string{"Get": getConfigResponse})
+	defer stop()
+
+	config, err := client.Get(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/config", nil, nil)
+	c.Assert(err, IsNil)
+	c.Assert(config, NotNil)
+	c.Check(config.XMLName.Local, Equals, "Config")
+	c.Check(config.Get("MaxEnvelopeSizekb"), Equals, "500")
+	c.Check(config.Get("MaxTimeoutms"), Equals, "60000")
+
+	var parsed struct {
+		MaxBatchItems    int
+		AllowUnencrypted bool `xml:"Service>AllowUnencrypted"`
+	}
+	c.Assert(config.Unmarshal(&parsed), IsNil)
+	c.Check(parsed.MaxBatchItems, Equals, 32000)
+	c.Check(parsed.AllowUnencrypted, Equals, false)
+
+	c.Assert(requests(), HasLen, 1)
+	c.Check(requests()[0], Contains, "<w:ResourceURI mustUnderstand=\"true\">http://schemas.microsoft.com/wbem/wsman/1/config</w:ResourceURI>")
+}
+
+func (s *WinRMSuite) TestPut(c *C) {
+	client, requests, stop := runTransferFakeServer(c, map[string]string{"Put": putServiceResponse})
+	defer stop()
+
+	body := InstanceXML("Service", "http://schemas.microsoft.com/wbem/wsman/1/config/service", map[string]string{"MaxConcurrentOperationsPerUser": "1500"})
+	service, err := client.Put(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/config/service", nil, nil, body)
+	c.Assert(err, IsNil)
+	c.Assert(service, NotNil)
+	c.Check(service.Get("MaxConcurrentOperationsPerUser"), Equals, "1500")
+
+	c.Assert(requests(), HasLen, 1)
+	c.Check(requests()[0], Contains, `<p:Service xmlns:p="http://schemas.microsoft.com/wbem/wsman/1/config/service"><p:MaxConcurrentOperationsPerUser>1500</p:MaxConcurrentOperationsPerUser></p:Service>`)
+}
+
+func (s *WinRMSuite) TestCreateAndDelete(c *C) {
+	client, requests, stop := runTransferFakeServer(c, map[string]string{"Create": createListenerResponse, "Delete": deleteResponse})
+	defer stop()
+
+	body := InstanceXML("Listener", "http://schemas.microsoft.com/wbem/wsman/1/config/listener", map[string]string{"Hostname": "host", "CertificateThumbprint": "0123456789ABCDEF"})
+	reference, err := client.Create(context.Background(), "http://schemas.microsoft.com/wbem/wsman/1/config/listener", nil, body)
+	c.Assert(err, IsNil)
+	c.Check(reference.Address, Equals, "http://localhost:5985/wsman")
+	c.Check(reference.ResourceURI, Equals, "http://schemas.microsoft.com/wbem/wsman/1/config/listener")
+	c.Check(reference.Selectors, DeepEquals, map[string]string{"Address": "*", "Transport": "HTTPS"})
+
+	err = client.Delete(context.Background(), reference.ResourceURI, reference.Selectors, nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(requests(), HasLen, 2)
+	c.Check(requests()[1], Contains, `<w:Selector Name="Transport">HTTPS</w:Selector>`)
+}
+
+func (s *WinRMSuite) TestGetCanceled(c *C) {
+	client, requests, stop := runTransferFakeServer(c, map[string]string{"Get": getConfigResponse})
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.Get(ctx, "http://schemas.microsoft.com/wbem/wsman/1/config", nil, nil)
+	c.Assert(err, Equals, context.Canceled)
+	c.Check(requests(), HasLen, 0)
+}